@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// combinedDoc is one document of an existing combined file, kept verbatim
+type combinedDoc struct {
+	name string
+	text string
+}
+
+// loadCombinedDocs splits an existing combined file into its documents in
+// file order. A missing file yields no documents.
+func loadCombinedDocs(filename string) ([]combinedDoc, error) {
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The generated header is written afresh, so it is not part of a document
+	var docs []combinedDoc
+	for _, text := range splitDocuments(stripHeaderComment(string(data))) {
+		var doc struct {
+			Spec struct {
+				Name string `yaml:"name"`
+			} `yaml:"spec"`
+		}
+		if err := yaml.Unmarshal([]byte(text), &doc); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", filename, err)
+		}
+		if doc.Spec.Name == "" {
+			return nil, fmt.Errorf("%s: document without spec.name", filename)
+		}
+		docs = append(docs, combinedDoc{doc.Spec.Name, text})
+	}
+	return docs, nil
+}
+
+// splitDocuments splits multi-document YAML on "---" separator lines. Query
+// bodies are indented block scalars, so a separator never appears in one.
+func splitDocuments(data string) []string {
+	var docs []string
+	var current strings.Builder
+	flush := func() {
+		if strings.TrimSpace(current.String()) != "" {
+			docs = append(docs, current.String())
+		}
+		current.Reset()
+	}
+	for _, line := range strings.SplitAfter(data, "\n") {
+		if strings.TrimRight(line, "\r\n") == "---" {
+			flush()
+			continue
+		}
+		current.WriteString(line)
+	}
+	flush()
+	return docs
+}
+
+// writeAppendedCombined rewrites the combined file as the existing documents
+// followed by the queries not already in it. A query whose name is already
+// present is skipped with a warning, or replaces that document in place
+// when overwrite is set.
+func writeAppendedCombined(filename string, existing []combinedDoc, queries []Query, overwrite bool) error {
+	rendered := map[string]string{}
+	for _, q := range queries {
+		var buf bytes.Buffer
+		if err := writeCheckedYAML(&buf, q, func(w io.Writer) error { return writeQueryYAML(w, q) }); err != nil {
+			return err
+		}
+		rendered[q.Name] = buf.String()
+	}
+
+	present := map[string]bool{}
+	docs := make([]string, 0, len(existing)+len(queries))
+	replaced := 0
+	for _, doc := range existing {
+		present[doc.name] = true
+		if text, ok := rendered[doc.name]; ok && overwrite {
+			docs = append(docs, text)
+			replaced++
+			continue
+		}
+		docs = append(docs, doc.text)
+	}
+
+	added := 0
+	for _, q := range queries {
+		if present[q.Name] {
+			if !overwrite {
+				warnf("%s is already in %s, skipping it (use -overwrite to replace it)\n", q.Name, filename)
+			}
+			continue
+		}
+		present[q.Name] = true
+		docs = append(docs, rendered[q.Name])
+		added++
+	}
+
+	if err := writeFileAtomic(filename, func(w io.Writer) error {
+		_, err := io.WriteString(w, headerComment+strings.Join(docs, "---\n"))
+		return err
+	}); err != nil {
+		return err
+	}
+	infof("Appended %d and replaced %d queries in %s (%d total)\n", added, replaced, filename, len(docs))
+	return nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxArchiveBytes bounds the total size extracted from an upstream archive
+const maxArchiveBytes = 1 << 30
+
+// isArchive reports whether -upstream names a release archive rather than
+// a checkout
+func isArchive(path string) bool {
+	lower := strings.ToLower(path)
+	return strings.HasSuffix(lower, ".tar.gz") || strings.HasSuffix(lower, ".tgz") || strings.HasSuffix(lower, ".zip")
+}
+
+// extractUpstream unpacks an archive into a temporary directory and returns
+// the directory to walk along with a function removing it. Release archives
+// usually wrap the kit in one top-level directory, which is descended into.
+func extractUpstream(path string) (string, func(), error) {
+	tmp, err := os.MkdirTemp("", "defense-kit-")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.RemoveAll(tmp) }
+
+	if strings.HasSuffix(strings.ToLower(path), ".zip") {
+		err = extractZip(path, tmp)
+	} else {
+		err = extractTarGz(path, tmp)
+	}
+	if err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("extracting %s: %w", path, err)
+	}
+
+	root := tmp
+	if entries, err := os.ReadDir(tmp); err == nil && len(entries) == 1 && entries[0].IsDir() {
+		root = filepath.Join(tmp, entries[0].Name())
+	}
+	debugf("Extracted %s to %s\n", path, root)
+	return root, cleanup, nil
+}
+
+func extractTarGz(path, dest string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	var total int64
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = extractDir(dest, hdr.Name)
+		case tar.TypeReg:
+			total += hdr.Size
+			if total > maxArchiveBytes {
+				return fmt.Errorf("archive is larger than %d bytes", maxArchiveBytes)
+			}
+			err = extractFile(dest, hdr.Name, tr)
+		default:
+			debugf("Skipping %s in archive (not a regular file)\n", hdr.Name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func extractZip(path, dest string) error {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	var total uint64
+	for _, zf := range zr.File {
+		switch {
+		case zf.FileInfo().IsDir():
+			err = extractDir(dest, zf.Name)
+		case zf.Mode().IsRegular():
+			total += zf.UncompressedSize64
+			if total > maxArchiveBytes {
+				return fmt.Errorf("archive is larger than %d bytes", maxArchiveBytes)
+			}
+			var rc io.ReadCloser
+			if rc, err = zf.Open(); err == nil {
+				err = extractFile(dest, zf.Name, rc)
+				rc.Close()
+			}
+		default:
+			debugf("Skipping %s in archive (not a regular file)\n", zf.Name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// archivePath resolves an archive entry name below dest, rejecting entries
+// that would escape it
+func archivePath(dest, name string) (string, error) {
+	target := filepath.Join(dest, filepath.FromSlash(name))
+	if rel, err := filepath.Rel(dest, target); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("entry %q points outside the archive", name)
+	}
+	return target, nil
+}
+
+func extractDir(dest, name string) error {
+	target, err := archivePath(dest, name)
+	if err != nil {
+		return err
+	}
+	return os.MkdirAll(target, 0755)
+}
+
+func extractFile(dest, name string, r io.Reader) error {
+	target, err := archivePath(dest, name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, io.LimitReader(r, maxArchiveBytes)); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTestArchive writes files, in order, to a .tar.gz or .zip named by
+// the extension of name
+func writeTestArchive(t *testing.T, name string, files [][2]string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if strings.HasSuffix(name, ".zip") {
+		zw := zip.NewWriter(f)
+		for _, file := range files {
+			w, err := zw.Create(file[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.Write([]byte(file[1]))
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for _, file := range files {
+		hdr := &tar.Header{Name: file[0], Mode: 0644, Size: int64(len(file[1])), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(file[1]))
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestExtractUpstream(t *testing.T) {
+	for _, name := range []string{"kit.tar.gz", "kit.zip"} {
+		t.Run(name, func(t *testing.T) {
+			archive := writeTestArchive(t, name, [][2]string{
+				{"osquery-defense-kit-1.0/detection/execution/2-shell.sql", fixtureQuery},
+				{"osquery-defense-kit-1.0/policy/ssh.sql", "SELECT 1"},
+			})
+			root, cleanup, err := extractUpstream(archive)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The single top-level directory is descended into
+			if got := strings.Join(listFiles(t, root), " "); got != "detection/execution/2-shell.sql policy/ssh.sql" {
+				t.Errorf("extracted %s", got)
+			}
+			cleanup()
+			if _, err := os.Stat(root); !os.IsNotExist(err) {
+				t.Errorf("cleanup left %s: %v", root, err)
+			}
+		})
+	}
+}
+
+func TestExtractUpstreamRejectsTraversal(t *testing.T) {
+	for _, name := range []string{"kit.tar.gz", "kit.zip"} {
+		t.Run(name, func(t *testing.T) {
+			archive := writeTestArchive(t, name, [][2]string{
+				{"kit/policy/ssh.sql", "SELECT 1"},
+				{"kit/../../evil", "pwned"},
+			})
+			// Keep the temporary directory where the test can check it is gone
+			tmp := t.TempDir()
+			t.Setenv("TMPDIR", tmp)
+
+			_, cleanup, err := extractUpstream(archive)
+			if err == nil {
+				cleanup()
+				t.Fatal("archive with ../evil extracted")
+			}
+			if !strings.Contains(err.Error(), `entry "kit/../../evil" points outside the archive`) {
+				t.Errorf("error = %v, want the escaping entry named", err)
+			}
+			// Neither the extraction directory nor ../evil beside it remains
+			if entries, _ := os.ReadDir(tmp); len(entries) > 0 {
+				t.Errorf("left behind in the temporary directory: %s", entries[0].Name())
+			}
+		})
+	}
+}
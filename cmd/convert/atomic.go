@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// written records the files this run produced, for -post-hook
+var written struct {
+	sync.Mutex
+	files []string
+}
+
+func recordWritten(filename string) {
+	written.Lock()
+	if !containsString(written.files, filename) {
+		written.files = append(written.files, filename)
+	}
+	written.Unlock()
+}
+
+// writtenFiles returns the files written so far, in write order
+func writtenFiles() []string {
+	written.Lock()
+	defer written.Unlock()
+	return append([]string(nil), written.files...)
+}
+
+// writeFileAtomic writes filename via a temp file in the same directory that
+// is renamed into place only after write succeeds, so readers never see a
+// partially written file. The temp file is removed on any failure.
+func writeFileAtomic(filename string, write func(w io.Writer) error) (err error) {
+	tmp, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("creating %s: %w", filename, err)
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	w := bufio.NewWriter(tmp)
+	if err := write(w); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("writing %s: %w", filename, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return fmt.Errorf("syncing %s: %w", filename, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("closing %s: %w", filename, err)
+	}
+
+	// CreateTemp uses 0600; match what os.Create would have produced
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), filename); err != nil {
+		return err
+	}
+	recordWritten(filename)
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// benchCorpusSize is the number of query files in the benchmark corpus
+const benchCorpusSize = 500
+
+// writeBenchCorpus writes n query files spread over the categories and a few
+// subcategories under dir
+func writeBenchCorpus(tb testing.TB, dir string, n int) {
+	tb.Helper()
+	subcategories := []string{"execution", "persistence", "c2", "evasion"}
+	for i := 0; i < n; i++ {
+		category := defaultCategories[i%len(defaultCategories)]
+		sub := filepath.Join(dir, category, subcategories[i%len(subcategories)])
+		if err := os.MkdirAll(sub, 0755); err != nil {
+			tb.Fatal(err)
+		}
+		content := fmt.Sprintf(`-- Detects suspicious process %d spawned by a network daemon
+-- platform: posix
+-- tags: process state persistent
+-- interval: %d
+-- references:
+--   * https://attack.mitre.org/techniques/T1059/004/
+SELECT p.pid, p.name, p.path, pp.name AS parent_name
+FROM processes p
+JOIN processes pp ON p.parent = pp.pid
+WHERE p.name IN ('sh', 'bash', 'zsh')
+  AND pp.name = 'daemon-%d';
+`, i, 300+i%5*300, i)
+		name := fmt.Sprintf("%d-query-%04d.sql", 1+i%3, i)
+		if err := os.WriteFile(filepath.Join(sub, name), []byte(content), 0644); err != nil {
+			tb.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParseAllQueries(b *testing.B) {
+	quietTest(b)
+	dir := b.TempDir()
+	writeBenchCorpus(b, dir, benchCorpusSize)
+	opts := parseOptions{EmptyDescription: "name", SortTags: true}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		queries, err := parseAllQueries(dir, opts)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(queries) != benchCorpusSize {
+			b.Fatalf("parsed %d queries, want %d", len(queries), benchCorpusSize)
+		}
+	}
+}
+
+func BenchmarkWriteFleetYAML(b *testing.B) {
+	quietTest(b)
+	dir := b.TempDir()
+	writeBenchCorpus(b, dir, benchCorpusSize)
+	queries, err := parseAllQueries(dir, parseOptions{EmptyDescription: "name", SortTags: true})
+	if err != nil {
+		b.Fatal(err)
+	}
+	outputDir := b.TempDir()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := writeFleetYAML(queries, outputDir, "category"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
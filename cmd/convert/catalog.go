@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// writeCatalog writes catalog.md in outputDir, a Markdown page describing
+// every query, a section per category, for people browsing the collection
+func writeCatalog(queries []Query, outputDir string) error {
+	filename := filepath.Join(outputDir, "catalog.md")
+	if err := writeFileAtomic(filename, func(w io.Writer) error {
+		return writeCatalogMarkdown(w, queries, time.Now())
+	}); err != nil {
+		return err
+	}
+	infof("Wrote %s (%d queries)\n", filename, len(queries))
+	return nil
+}
+
+// writeCatalogMarkdown writes the catalog page. now is the date detections
+// are judged stale against.
+func writeCatalogMarkdown(w io.Writer, queries []Query, now time.Time) error {
+	bw := bufio.NewWriter(w)
+
+	// Categories in the order their first query was parsed
+	var order []string
+	byCategory := map[string][]Query{}
+	names := map[string]string{} // slug -> name, for related links
+	for _, q := range queries {
+		names[q.Slug] = q.Name
+		if _, ok := byCategory[q.Category]; !ok {
+			order = append(order, q.Category)
+		}
+		byCategory[q.Category] = append(byCategory[q.Category], q)
+	}
+
+	fmt.Fprintf(bw, "# Query catalog\n\n%d queries.\n", len(queries))
+	writeCatalogStats(bw, queries, now)
+	for _, category := range order {
+		fmt.Fprintf(bw, "\n## %s\n", category)
+		for _, q := range byCategory[category] {
+			writeCatalogEntry(bw, q, names)
+		}
+	}
+	return bw.Flush()
+}
+
+// writeCatalogEntry writes one query: its short description, then the long
+// one when the source has both, then a list of its metadata. A long
+// description that starts with the short one is written alone. names maps
+// slugs to query names for the related links.
+func writeCatalogEntry(w io.Writer, q Query, names map[string]string) {
+	if q.Slug != "" {
+		fmt.Fprintf(w, "\n<a id=\"%s\"></a>\n", q.Slug)
+	}
+	fmt.Fprintf(w, "\n### %s\n", q.Name)
+	short, long := catalogText(q.Description), catalogText(q.LongDescription)
+	switch {
+	case long == "" || long == short:
+		fmt.Fprintf(w, "\n%s\n", short)
+	case strings.HasPrefix(long, short):
+		// The short description is the first sentence of the long one
+		fmt.Fprintf(w, "\n%s\n", long)
+	default:
+		fmt.Fprintf(w, "\n%s\n\n%s\n", short, long)
+	}
+
+	fmt.Fprintf(w, "\n")
+	for _, item := range catalogItems(q, names) {
+		fmt.Fprintf(w, "- **%s:** %s\n", item[0], item[1])
+	}
+}
+
+// catalogItems lists the metadata shown under a query, skipping unset values
+func catalogItems(q Query, names map[string]string) [][2]string {
+	var items [][2]string
+	add := func(label, value string) {
+		if value != "" {
+			items = append(items, [2]string{label, value})
+		}
+	}
+	platform := q.Platform
+	if platform == "" {
+		platform = "all"
+	}
+	add("Platform", platform)
+	if q.Slug != "" {
+		add("Slug", "`"+q.Slug+"`")
+	}
+	add("Severity", q.Severity)
+	add("Tags", strings.Join(q.Tags, ", "))
+	if columns := selectColumns(q.Query); columns != nil {
+		add("Columns", "`"+strings.Join(columns, "`, `")+"`")
+	} else if q.Query != "" {
+		// SELECT * and the like return whatever the table has
+		add("Columns", "unknown schema")
+	}
+	// -- overlap: references are slugs by now, which are the entry anchors
+	var related []string
+	for _, slug := range q.RelatedTo {
+		name := names[slug]
+		if name == "" {
+			name = slug
+		}
+		related = append(related, fmt.Sprintf("[%s](#%s)", name, slug))
+	}
+	add("Related", strings.Join(related, ", "))
+	if q.Runbook != "" {
+		add("Runbook", "<"+q.Runbook+">")
+	}
+	add("Note", catalogText(q.Note))
+	if !q.Created.IsZero() {
+		add("Created", q.Created.Format(time.DateOnly))
+	}
+	if !q.Updated.IsZero() {
+		add("Updated", q.Updated.Format(time.DateOnly))
+	}
+	var provenance []string
+	if q.Origin != "" {
+		provenance = append(provenance, "`"+q.Origin+"`")
+	}
+	if q.License != "" {
+		provenance = append(provenance, q.License)
+	}
+	add("Provenance", strings.Join(provenance, ", "))
+	add("Source", "`"+q.Source+"`")
+	return items
+}
+
+// writeCatalogStats writes the oldest dated detection and the detections
+// not changed within staleAfter of now, judged as -lint judges them
+func writeCatalogStats(w io.Writer, queries []Query, now time.Time) {
+	var oldest *Query
+	var stale []string
+	for i, q := range queries {
+		if q.Category != "detection" {
+			continue
+		}
+		if !q.Created.IsZero() && (oldest == nil || q.Created.Before(oldest.Created)) {
+			oldest = &queries[i]
+		}
+		if changed := lastChanged(q); !changed.IsZero() && now.Sub(changed) > staleAfter {
+			stale = append(stale, fmt.Sprintf("- %s, last changed %s", catalogLink(q), changed.Format(time.DateOnly)))
+		}
+	}
+	if oldest != nil {
+		fmt.Fprintf(w, "\nOldest detection: %s, created %s.\n", catalogLink(*oldest), oldest.Created.Format(time.DateOnly))
+	}
+	if len(stale) > 0 {
+		fmt.Fprintf(w, "\nDetections not updated in a year (%d):\n\n%s\n", len(stale), strings.Join(stale, "\n"))
+	}
+}
+
+// catalogLink links to the entry of q, or names it when it has no slug
+func catalogLink(q Query) string {
+	if q.Slug == "" {
+		return q.Name
+	}
+	return fmt.Sprintf("[%s](#%s)", q.Name, q.Slug)
+}
+
+// catalogText keeps text on one Markdown paragraph
+func catalogText(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
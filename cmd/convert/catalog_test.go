@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+// catalogEntry renders q's catalog entry
+func catalogEntry(t *testing.T, q Query) string {
+	t.Helper()
+	var buf bytes.Buffer
+	writeCatalogEntry(&buf, q, nil)
+	return buf.String()
+}
+
+func TestCatalogDescriptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+		notWant []string
+	}{
+		{
+			"summary and long description",
+			"-- Spawns a shell from a daemon, which web servers\n-- never need to do.\n-- summary: Daemon shell\nSELECT 1\n",
+			[]string{"\nDaemon shell\n\nSpawns a shell from a daemon, which web servers never need to do.\n"},
+			nil,
+		},
+		{
+			"long description only",
+			"-- Spawns a shell from a daemon.\n-- Web servers never need to.\nSELECT 1\n",
+			[]string{"\nSpawns a shell from a daemon. Web servers never need to.\n"},
+			[]string{"\nSpawns a shell from a daemon.\n"},
+		},
+		{
+			"one line",
+			"-- Spawns a shell from a daemon\nSELECT 1\n",
+			[]string{"\nSpawns a shell from a daemon\n"},
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, _ := parseTestQuery(t, "detection/execution/2-shell.sql", tt.content)
+			entry := catalogEntry(t, q)
+			for _, want := range tt.want {
+				if !strings.Contains(entry, want) {
+					t.Errorf("entry lacks %q:\n%s", want, entry)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(entry, notWant) {
+					t.Errorf("entry has %q:\n%s", notWant, entry)
+				}
+			}
+		})
+	}
+}
+
+func TestCatalogMarkdown(t *testing.T) {
+	queries := []Query{
+		{Name: "[policy] Ssh", Description: "Ssh", Category: "policy", Slug: "policy-ssh", Source: "policy/ssh.sql"},
+		{Name: "[detection/c2] Dns", Description: "Dns", Category: "detection", Slug: "detection-c2-dns", Source: "detection/c2/dns.sql", Platform: "linux"},
+		{Name: "[policy] Root", Description: "Root", Category: "policy", Slug: "policy-root", Source: "policy/root.sql"},
+	}
+	var buf bytes.Buffer
+	if err := writeCatalogMarkdown(&buf, queries, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	// Sections follow the parse order, and each query sits in its category
+	var headings []string
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(line, "#") {
+			headings = append(headings, line)
+		}
+	}
+	want := []string{"# Query catalog", "## policy", "### [policy] Ssh", "### [policy] Root", "## detection", "### [detection/c2] Dns"}
+	if strings.Join(headings, "\n") != strings.Join(want, "\n") {
+		t.Errorf("headings = %q, want %q", headings, want)
+	}
+	for _, line := range []string{"3 queries.", `<a id="detection-c2-dns"></a>`, "- **Platform:** linux", "- **Platform:** all", "- **Source:** `policy/ssh.sql`"} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("catalog lacks %q:\n%s", line, out)
+		}
+	}
+}
+
+func TestCatalogColumns(t *testing.T) {
+	q := Query{Name: "[detection/execution] Shell", Query: "SELECT p.pid, p.name AS process FROM processes p", Source: "detection/execution/shell.sql"}
+	if entry := catalogEntry(t, q); !strings.Contains(entry, "- **Columns:** `pid`, `process`\n") {
+		t.Errorf("entry lacks the columns:\n%s", entry)
+	}
+	q.Query = "SELECT * FROM processes"
+	if entry := catalogEntry(t, q); !strings.Contains(entry, "- **Columns:** unknown schema\n") {
+		t.Errorf("entry does not mark SELECT * as unknown schema:\n%s", entry)
+	}
+}
+
+func TestCatalogRunbook(t *testing.T) {
+	q, warnings := parseTestQuery(t, "incident_response/users.sql", "-- Local users\n-- triage: https://wiki.example.com/runbooks/users\nSELECT 1\n")
+	if len(warnings) > 0 || q.Runbook != "https://wiki.example.com/runbooks/users" {
+		t.Fatalf("runbook = %q with warnings %q", q.Runbook, warnings)
+	}
+	if entry := catalogEntry(t, q); !strings.Contains(entry, "- **Runbook:** <https://wiki.example.com/runbooks/users>\n") {
+		t.Errorf("entry lacks the runbook link:\n%s", entry)
+	}
+	if doc, text := emitTestQuery(t, q); doc.Metadata.Annotations["runbook"] != q.Runbook {
+		t.Errorf("runbook annotation missing:\n%s", text)
+	}
+
+	for _, value := range []string{"wiki/runbooks/users", "ftp://wiki.example.com/users", "https://"} {
+		q, warnings := parseTestQuery(t, "incident_response/users.sql", "-- Local users\n-- triage: "+value+"\nSELECT 1\n")
+		if q.Runbook != "" || len(warnings) != 1 {
+			t.Errorf("triage %q gave runbook %q with warnings %q, want it ignored with a warning", value, q.Runbook, warnings)
+		}
+		if entry := catalogEntry(t, q); strings.Contains(entry, "Runbook") {
+			t.Errorf("invalid runbook listed:\n%s", entry)
+		}
+	}
+}
+
+func TestCatalogProvenance(t *testing.T) {
+	q := Query{Name: "[detection/execution] Shell", Query: "SELECT 1", Source: "detection/execution/shell.sql"}
+	for _, tt := range []struct{ origin, license, want string }{
+		{"github.com/chainguard-dev/osquery-defense-kit", "Apache-2.0", "- **Provenance:** `github.com/chainguard-dev/osquery-defense-kit`, Apache-2.0\n"},
+		{"", "MIT", "- **Provenance:** MIT\n"},
+		{"example.com/queries", "", "- **Provenance:** `example.com/queries`\n"},
+	} {
+		q.Origin, q.License = tt.origin, tt.license
+		if entry := catalogEntry(t, q); !strings.Contains(entry, tt.want) {
+			t.Errorf("entry lacks %q:\n%s", tt.want, entry)
+		}
+	}
+	q.Origin, q.License = "", ""
+	if entry := catalogEntry(t, q); strings.Contains(entry, "Provenance") {
+		t.Errorf("unknown provenance listed:\n%s", entry)
+	}
+}
+
+func TestCatalogRelated(t *testing.T) {
+	queries := []Query{
+		{Name: "[detection/c2] Dns Tunnel", Slug: "detection-c2-dns-tunnel", Category: "detection", Query: "SELECT 1", Source: "detection/c2/dns-tunnel.sql"},
+		{Name: "[detection/c2] Long Dns Names", Slug: "detection-c2-long-dns-names", Category: "detection", Query: "SELECT 2", Source: "detection/c2/long-dns-names.sql",
+			RelatedTo: []string{"[detection/c2] dns tunnel", "missing-query"}},
+	}
+	warnings := captureWarnings(t, func() { resolveRelated(queries) })
+	if len(warnings) != 1 || !strings.Contains(warnings[0], `overlap "missing-query" does not match`) {
+		t.Errorf("warnings = %q, want one for the dangling reference", warnings)
+	}
+	if got := strings.Join(queries[1].RelatedTo, ","); got != "detection-c2-dns-tunnel" {
+		t.Fatalf("related = %q after resolving", got)
+	}
+
+	var buf bytes.Buffer
+	if err := writeCatalogMarkdown(&buf, queries, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	// The link targets the other entry's anchor
+	if !strings.Contains(out, "- **Related:** [[detection/c2] Dns Tunnel](#detection-c2-dns-tunnel)\n") {
+		t.Errorf("catalog lacks the related link:\n%s", out)
+	}
+	if !strings.Contains(out, `<a id="detection-c2-dns-tunnel"></a>`) {
+		t.Errorf("link target has no anchor:\n%s", out)
+	}
+	if strings.Count(out, "Related:") != 1 {
+		t.Errorf("related listed for a query without overlaps:\n%s", out)
+	}
+}
+
+func TestCatalogDates(t *testing.T) {
+	q, warnings := parseTestQuery(t, "detection/execution/2-shell.sql", "-- Unexpected shell\n-- created: 2021-03-04\n-- updated: 2023-06-01\nSELECT pid FROM processes\n")
+	if len(warnings) > 0 {
+		t.Errorf("unexpected warnings: %q", warnings)
+	}
+	entry := catalogEntry(t, q)
+	for _, want := range []string{"- **Created:** 2021-03-04\n", "- **Updated:** 2023-06-01\n"} {
+		if !strings.Contains(entry, want) {
+			t.Errorf("entry lacks %q:\n%s", want, entry)
+		}
+	}
+	undated, _ := parseTestQuery(t, "detection/execution/2-shell.sql", "-- Unexpected shell\nSELECT pid FROM processes\n")
+	if entry := catalogEntry(t, undated); strings.Contains(entry, "Created") || strings.Contains(entry, "Updated") {
+		t.Errorf("dates listed for an undated query:\n%s", entry)
+	}
+}
+
+func TestCatalogStats(t *testing.T) {
+	date := func(s string) time.Time {
+		d, err := time.Parse(time.DateOnly, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	now := date("2024-06-01")
+	queries := []Query{
+		{Name: "[detection/c2] Dns", Slug: "detection-c2-dns", Category: "detection", Created: date("2020-01-01"), Updated: date("2024-01-01")},
+		{Name: "[detection/c2] Beacon", Slug: "detection-c2-beacon", Category: "detection", Created: date("2021-05-01")},
+		// Judged by the last commit without -- updated:
+		{Name: "[detection/c2] Tor", Slug: "detection-c2-tor", Category: "detection", Created: date("2022-01-01"), Committed: date("2024-05-01")},
+		{Name: "[detection/c2] Undated", Slug: "detection-c2-undated", Category: "detection"},
+		// Only detections count
+		{Name: "[policy] Ssh", Slug: "policy-ssh", Category: "policy", Created: date("2019-01-01")},
+	}
+	var buf bytes.Buffer
+	if err := writeCatalogMarkdown(&buf, queries, now); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	want := "\nOldest detection: [[detection/c2] Dns](#detection-c2-dns), created 2020-01-01.\n" +
+		"\nDetections not updated in a year (1):\n\n- [[detection/c2] Beacon](#detection-c2-beacon), last changed 2021-05-01\n"
+	if !strings.Contains(out, want) {
+		t.Errorf("catalog lacks stats %q:\n%s", want, out)
+	}
+
+	buf.Reset()
+	if err := writeCatalogMarkdown(&buf, queries[3:], now); err != nil {
+		t.Fatal(err)
+	}
+	if out := buf.String(); strings.Contains(out, "Oldest") || strings.Contains(out, "not updated") {
+		t.Errorf("stats written without dated detections:\n%s", out)
+	}
+}
+
+func TestNote(t *testing.T) {
+	q, warnings := parseTestQuery(t, "detection/execution/2-shell.sql", "-- Spawns a shell from a daemon\n-- note: Expect noise on CI runners,\n-- note: which build as root.\nSELECT 1\n")
+	if len(warnings) > 0 {
+		t.Errorf("unexpected warnings: %q", warnings)
+	}
+	const note = "Expect noise on CI runners, which build as root."
+	if q.Note != note || q.Description != "Spawns a shell from a daemon" {
+		t.Errorf("note %q, description %q", q.Note, q.Description)
+	}
+
+	doc, text := emitTestQuery(t, q)
+	if doc.Metadata.Annotations["note"] != note {
+		t.Errorf("note annotation = %q in:\n%s", doc.Metadata.Annotations["note"], text)
+	}
+	if strings.Contains(doc.Spec["description"].(string), "noise") {
+		t.Errorf("note leaked into the description:\n%s", text)
+	}
+
+	entry := catalogEntry(t, q)
+	if !strings.Contains(entry, "- **Note:** "+note+"\n") || strings.Count(entry, "noise") != 1 {
+		t.Errorf("catalog entry does not list the note once:\n%s", entry)
+	}
+
+	// Front matter takes a list of note lines
+	fm, _ := parseTestQuery(t, "detection/execution/2-shell.sql", "-- ---\n-- description: Shell\n-- note:\n--   - Expect noise on CI runners,\n--   - which build as root.\n-- ---\nSELECT 1\n")
+	if fm.Note != note {
+		t.Errorf("front matter note = %q", fm.Note)
+	}
+	if entry := catalogEntry(t, Query{Name: "x", Source: "x.sql"}); strings.Contains(entry, "Note") {
+		t.Errorf("note listed without one:\n%s", entry)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+)
+
+// categoryKinds are the document kinds -category-map accepts
+var categoryKinds = []string{"query", "label", "policy"}
+
+// categoryLogging are the logging types -category-map accepts
+var categoryLogging = []string{"snapshot", "differential", "differential_ignore_removals"}
+
+// categoryNameRegex matches a top-level directory that can hold queries;
+// directories starting with "_", such as _includes, are not categories
+var categoryNameRegex = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// categoryMapping is how one category is emitted; empty fields keep the
+// converter's default
+type categoryMapping struct {
+	Kind    string `json:"kind,omitempty"`
+	Logging string `json:"logging,omitempty"`
+}
+
+// categoryMap is the -category-map file. As a metadataSource it fills in the
+// kind and logging of queries whose headers set neither.
+type categoryMap map[string]categoryMapping
+
+func (categoryMap) precedence() int { return precedenceDefault }
+
+func (m categoryMap) apply(q *Query) {
+	mapping, ok := m[q.Category]
+	if !ok {
+		return
+	}
+	if q.As == "" && mapping.Kind != "query" {
+		q.As = mapping.Kind
+	}
+	if q.Logging == "" {
+		q.Logging = mapping.Logging
+	}
+}
+
+// loadCategoryMap reads a JSON object of category to kind and logging, such
+// as {"hunting": {"kind": "query", "logging": "snapshot"}}
+func loadCategoryMap(path string) (categoryMap, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m categoryMap
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	for category, mapping := range m {
+		if !categoryNameRegex.MatchString(category) {
+			return nil, fmt.Errorf("%s: %q is not a category directory name", path, category)
+		}
+		if mapping.Kind != "" && !containsString(categoryKinds, mapping.Kind) {
+			return nil, fmt.Errorf("%s: kind for %s must be one of %v, got %q", path, category, categoryKinds, mapping.Kind)
+		}
+		if mapping.Logging != "" && !containsString(categoryLogging, mapping.Logging) {
+			return nil, fmt.Errorf("%s: logging for %s must be one of %v, got %q", path, category, categoryLogging, mapping.Logging)
+		}
+	}
+	return m, nil
+}
+
+// addCategories returns categories followed by the ones in m it lacks, in
+// name order, so that they are parsed and written like the upstream ones.
+// categories itself is left alone.
+func addCategories(categories []string, m categoryMap) []string {
+	var extra []string
+	for category := range m {
+		if !containsString(categories, category) {
+			extra = append(extra, category)
+		}
+	}
+	sort.Strings(extra)
+	return append(append([]string(nil), categories...), extra...)
+}
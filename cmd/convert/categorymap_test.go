@@ -0,0 +1,87 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCategoryMapResolve(t *testing.T) {
+	m := categoryMap{"policy": {Kind: "label"}, "hunting": {Logging: "snapshot"}}
+	tests := []struct {
+		category, as, logging string
+		wantAs, wantLogging   string
+	}{
+		{"policy", "", "", "label", "differential"},
+		// Headers win over the map
+		{"policy", "query", "", "query", "differential"},
+		{"hunting", "", "", "", "snapshot"},
+		{"hunting", "", "differential", "", "differential"},
+		{"detection", "", "", "", "differential"},
+	}
+	for _, tt := range tests {
+		q := resolveMetadata(Query{Category: tt.category, As: tt.as, Logging: tt.logging}, m)
+		if q.As != tt.wantAs || loggingFor(q) != tt.wantLogging {
+			t.Errorf("%s query with as %q, logging %q became %q, %q; want %q, %q", tt.category, tt.as, tt.logging, q.As, loggingFor(q), tt.wantAs, tt.wantLogging)
+		}
+	}
+}
+
+func TestAddCategories(t *testing.T) {
+	base := []string{"detection", "policy"}
+	got := addCategories(base, categoryMap{"policy": {}, "triage": {}, "hunting": {}})
+	if want := []string{"detection", "policy", "hunting", "triage"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("addCategories = %q, want %q", got, want)
+	}
+	if !reflect.DeepEqual(base, []string{"detection", "policy"}) {
+		t.Errorf("addCategories changed its argument to %q", base)
+	}
+}
+
+func TestCategoryMapFlag(t *testing.T) {
+	upstream := writeUpstream(t, map[string]string{
+		"detection/execution/2-shell.sql": fixtureQuery,
+		"hunting/execution/2-shell.sql":   fixtureQuery,
+	})
+	mapPath := filepath.Join(t.TempDir(), "categories.json")
+	if err := os.WriteFile(mapPath, []byte(`{"hunting": {"logging": "snapshot"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	saved := append([]string(nil), defaultCategories...)
+
+	output := t.TempDir()
+	if err := runConvert(t, "-upstream", upstream, "-output", output, "-category-map", mapPath, "-lock"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(output, "chainguard-hunting.yml"))
+	if err != nil {
+		t.Fatalf("mapped category not written: %v", err)
+	}
+	if !strings.Contains(string(data), "logging: snapshot") {
+		t.Errorf("mapped logging not applied:\n%s", data)
+	}
+	data, err = os.ReadFile(filepath.Join(output, lockFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"hunting/execution/2-shell.sql"`) {
+		t.Errorf("lock leaves out the mapped category:\n%s", data)
+	}
+
+	// The map applies to its own run only
+	if !reflect.DeepEqual(defaultCategories, saved) {
+		t.Errorf("-category-map changed the default categories to %q", defaultCategories)
+	}
+	output = t.TempDir()
+	if err := runConvert(t, "-upstream", upstream, "-output", output, "-lock"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(output, "chainguard-hunting.yml")); err == nil {
+		t.Errorf("unmapped category written")
+	}
+	if data, err := os.ReadFile(filepath.Join(output, lockFilename)); err != nil || strings.Contains(string(data), "hunting/") {
+		t.Errorf("lock without -category-map: %v\n%s", err, data)
+	}
+}
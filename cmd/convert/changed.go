@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// changedFiles lists the .sql files in the upstream checkout that differ
+// between ref and the working tree, as slash-separated paths relative to
+// upstreamDir. Renamed files count under their new path; deleted files are
+// returned separately since there is nothing left to convert.
+func changedFiles(upstreamDir, ref string) (changed map[string]bool, deleted []string, err error) {
+	out, err := exec.Command("git", "-C", upstreamDir, "diff", "--name-status", "-M", "--relative", ref, "--").Output()
+	if err != nil {
+		if exit, ok := err.(*exec.ExitError); ok {
+			return nil, nil, fmt.Errorf("git diff %s: %s", ref, strings.TrimSpace(string(exit.Stderr)))
+		}
+		return nil, nil, err
+	}
+
+	changed = map[string]bool{}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) < 2 {
+			continue
+		}
+		status, path := fields[0], fields[len(fields)-1]
+		switch {
+		case strings.HasPrefix(status, "R") && len(fields) == 3:
+			debugf("%s was renamed to %s\n", fields[1], path)
+		case status == "D":
+			if strings.HasSuffix(path, ".sql") {
+				deleted = append(deleted, path)
+			}
+			continue
+		}
+		if strings.HasSuffix(path, ".sql") {
+			changed[path] = true
+		}
+	}
+	return changed, deleted, scanner.Err()
+}
+
+// includesChanged reports whether any changed path is under includeDir,
+// whose snippets may be spliced into queries that did not change themselves
+func includesChanged(changed map[string]bool, upstreamDir, includeDir string) bool {
+	rel, err := filepath.Rel(upstreamDir, includeDir)
+	if err != nil {
+		return false
+	}
+	prefix := filepath.ToSlash(rel) + "/"
+	for path := range changed {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"time"
+)
+
+// writeChangelog writes a CHANGELOG.md fragment listing queries added,
+// removed, and modified since the catalog in dir. The fragment starts with a
+// dated heading so successive runs can be appended to one file. A missing
+// previous catalog lists every query as added.
+func writeChangelog(filename, dir string, queries []Query, categories []string) error {
+	previous, err := loadPreviousCatalog(dir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("loading previous catalog: %w", err)
+	}
+
+	d, err := diffCatalog(previous, queries)
+	if err != nil {
+		return fmt.Errorf("comparing catalogs: %w", err)
+	}
+
+	categoryOf := map[string]string{}
+	for _, q := range queries {
+		categoryOf[q.Name] = q.Category
+	}
+	for name := range previous {
+		if _, ok := categoryOf[name]; !ok {
+			categoryOf[name] = categoryFromName(name, categories)
+		}
+	}
+
+	return writeFileAtomic(filename, func(w io.Writer) error {
+		bw := bufio.NewWriter(w)
+		fmt.Fprintf(bw, "## %s\n", time.Now().UTC().Format("2006-01-02"))
+		if d.changed() == 0 {
+			fmt.Fprintf(bw, "\nNo query changes.\n")
+		}
+		writeChangelogSection(bw, "Added", d.Added, categoryOf)
+		writeChangelogSection(bw, "Removed", d.Removed, categoryOf)
+		writeChangelogSection(bw, "Modified", d.Modified, categoryOf)
+		fmt.Fprintln(bw)
+		return bw.Flush()
+	})
+}
+
+func writeChangelogSection(w io.Writer, title string, names []string, categories map[string]string) {
+	if len(names) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "\n### %s\n\n", title)
+	for _, name := range names {
+		if category := categories[name]; category != "" {
+			fmt.Fprintf(w, "- %s (%s)\n", name, category)
+		} else {
+			fmt.Fprintf(w, "- %s\n", name)
+		}
+	}
+}
+
+// categoryFromName recovers the category from a generated name such as
+// "[detection/c2] Dns Tunnel", given the known categories. Names set with
+// query_name: yield "".
+func categoryFromName(name string, categories []string) string {
+	if !strings.HasPrefix(name, "[") {
+		return ""
+	}
+	prefix, _, ok := strings.Cut(name[1:], "]")
+	if !ok {
+		return ""
+	}
+	category, _, _ := strings.Cut(prefix, "/")
+	if !containsString(categories, category) {
+		return ""
+	}
+	return category
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChangelogRemoved(t *testing.T) {
+	upstream := writeUpstream(t, map[string]string{
+		"detection/execution/2-shell.sql": fixtureQuery,
+		"policy/ssh-root.sql":             "-- Root login over SSH\nSELECT 1;\n",
+		"policy/custom.sql":               "-- Custom\n-- query_name: Custom check\nSELECT 2;\n",
+	})
+	output := t.TempDir()
+	if err := runConvert(t, "-upstream", upstream, "-output", output); err != nil {
+		t.Fatal(err)
+	}
+
+	// Both policies go away upstream; the detection stays as it was
+	for _, name := range []string{"policy/ssh-root.sql", "policy/custom.sql"} {
+		if err := os.Remove(filepath.Join(upstream, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	changelog := filepath.Join(t.TempDir(), "CHANGELOG.md")
+	if err := runConvert(t, "-upstream", upstream, "-output", output, "-changelog", changelog); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(changelog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A removed query's category comes from its generated name; a name set
+	// with query_name: has none
+	want := "## " + time.Now().UTC().Format(time.DateOnly) + "\n\n### Removed\n\n- Custom check\n- [policy] Ssh Root (policy)\n\n"
+	if string(data) != want {
+		t.Errorf("changelog = %q, want %q", data, want)
+	}
+	if strings.Contains(string(data), "Shell") {
+		t.Errorf("unchanged query listed:\n%s", data)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var techniqueIDRegex = regexp.MustCompile(`^T1\d{3}(\.\d{3})?$`)
+
+// coverageReport summarizes which baseline ATT&CK techniques the catalog
+// detects
+type coverageReport struct {
+	Baseline        int                 `json:"baseline"`
+	CoveragePct     float64             `json:"coverage_pct"`
+	Covered         []coveredTechnique  `json:"covered"`
+	Uncovered       []techniqueCoverage `json:"uncovered"`
+	OutsideBaseline []coveredTechnique  `json:"outside_baseline,omitempty"`
+}
+
+type techniqueCoverage struct {
+	Technique string   `json:"technique"`
+	Tactics   []string `json:"tactics,omitempty"`
+}
+
+type coveredTechnique struct {
+	techniqueCoverage
+	Count   int      `json:"count"`
+	Queries []string `json:"queries"`
+}
+
+// defaultCoverageBaseline returns the embedded parent techniques in ID order
+func defaultCoverageBaseline() []string {
+	baseline := make([]string, 0, len(techniqueTactics))
+	for id := range techniqueTactics {
+		baseline = append(baseline, id)
+	}
+	sort.Strings(baseline)
+	return baseline
+}
+
+// loadCoverageBaseline reads technique IDs, one per line. Blank lines and
+// lines starting with # are ignored.
+func loadCoverageBaseline(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var baseline []string
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !techniqueIDRegex.MatchString(line) {
+			return nil, fmt.Errorf("%s:%d: %q is not an ATT&CK technique ID", path, n, line)
+		}
+		if !containsString(baseline, line) {
+			baseline = append(baseline, line)
+		}
+	}
+	return baseline, scanner.Err()
+}
+
+// buildCoverage matches each query's techniques against the baseline. A
+// baseline technique is covered by itself or any of its sub-techniques.
+func buildCoverage(queries []Query, baseline []string) coverageReport {
+	matches := map[string][]string{}
+	outside := map[string][]string{}
+	for _, q := range queries {
+		for _, id := range q.Techniques {
+			matched := false
+			for _, entry := range baseline {
+				if id == entry || strings.HasPrefix(id, entry+".") {
+					matches[entry] = appendUnique(matches[entry], q.Name)
+					matched = true
+				}
+			}
+			if !matched {
+				outside[id] = appendUnique(outside[id], q.Name)
+			}
+		}
+	}
+
+	report := coverageReport{Baseline: len(baseline)}
+	for _, entry := range baseline {
+		tc := techniqueCoverage{Technique: entry, Tactics: tacticsFor([]string{entry})}
+		if names, ok := matches[entry]; ok {
+			report.Covered = append(report.Covered, coveredTechnique{tc, len(names), names})
+		} else {
+			report.Uncovered = append(report.Uncovered, tc)
+		}
+	}
+
+	ids := make([]string, 0, len(outside))
+	for id := range outside {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		tc := techniqueCoverage{Technique: id, Tactics: tacticsFor([]string{id})}
+		report.OutsideBaseline = append(report.OutsideBaseline, coveredTechnique{tc, len(outside[id]), outside[id]})
+	}
+
+	if len(baseline) > 0 {
+		report.CoveragePct = 100 * float64(len(report.Covered)) / float64(len(baseline))
+	}
+	return report
+}
+
+func appendUnique(list []string, s string) []string {
+	if containsString(list, s) {
+		return list
+	}
+	return append(list, s)
+}
+
+// writeCoverage writes the report as attack-coverage.json and
+// attack-coverage.md in outputDir
+func writeCoverage(report coverageReport, outputDir string) error {
+	if err := writeFileAtomic(filepath.Join(outputDir, "attack-coverage.json"), func(w io.Writer) error {
+		return writeJSON(w, report)
+	}); err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(outputDir, "attack-coverage.md"), func(w io.Writer) error {
+		return writeCoverageMarkdown(w, report)
+	})
+}
+
+func writeCoverageMarkdown(w io.Writer, report coverageReport) error {
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintf(bw, "# ATT&CK coverage\n\n")
+	fmt.Fprintf(bw, "%d of %d baseline techniques covered (%.1f%%).\n", len(report.Covered), report.Baseline, report.CoveragePct)
+
+	fmt.Fprintf(bw, "\n## Covered\n\n")
+	writeCoveredTable(bw, report.Covered)
+
+	fmt.Fprintf(bw, "\n## Uncovered\n\n")
+	fmt.Fprintf(bw, "| Technique | Tactics |\n|-----------|---------|\n")
+	for _, tc := range report.Uncovered {
+		fmt.Fprintf(bw, "| %s | %s |\n", tc.Technique, strings.Join(tc.Tactics, ", "))
+	}
+
+	if len(report.OutsideBaseline) > 0 {
+		fmt.Fprintf(bw, "\n## Outside the baseline\n\n")
+		writeCoveredTable(bw, report.OutsideBaseline)
+	}
+
+	return bw.Flush()
+}
+
+func writeCoveredTable(w io.Writer, rows []coveredTechnique) {
+	fmt.Fprintf(w, "| Technique | Tactics | Queries |\n|-----------|---------|---------|\n")
+	for _, tc := range rows {
+		fmt.Fprintf(w, "| %s | %s | %d: %s |\n", tc.Technique, strings.Join(tc.Tactics, ", "), tc.Count,
+			strings.ReplaceAll(strings.Join(tc.Queries, ", "), "|", `\|`))
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// catalogDiff lists query names that changed between two conversion runs
+type catalogDiff struct {
+	Added    []string
+	Removed  []string
+	Modified []string
+}
+
+func (d catalogDiff) changed() int {
+	return len(d.Added) + len(d.Removed) + len(d.Modified)
+}
+
+// loadPreviousCatalog reads the combined file of a previous output directory
+// and returns its documents keyed by query name. The file may be a document
+// stream or, from -single-document, one list of documents.
+func loadPreviousCatalog(dir string) (map[string]any, error) {
+	filename := filepath.Join(dir, "chainguard-all.yml")
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	docs := map[string]any{}
+	dec := yaml.NewDecoder(file)
+	for {
+		var value any
+		err := dec.Decode(&value)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", filename, err)
+		}
+
+		items, ok := value.([]any)
+		if !ok {
+			items = []any{value}
+		}
+		for _, item := range items {
+			doc, _ := item.(map[string]any)
+			spec, _ := doc["spec"].(map[string]any)
+			name, _ := spec["name"].(string)
+			if name == "" {
+				return nil, fmt.Errorf("%s: document without spec.name", filename)
+			}
+			docs[name] = doc
+		}
+	}
+	return docs, nil
+}
+
+// diffCatalog compares queries, as they would be emitted, against the
+// documents of a previous run. Comparison is on decoded documents, so
+// formatting-only differences are not reported.
+func diffCatalog(previous map[string]any, queries []Query) (catalogDiff, error) {
+	var d catalogDiff
+	seen := map[string]bool{}
+
+	for _, q := range queries {
+		doc, err := renderDocument(q)
+		if err != nil {
+			return d, fmt.Errorf("%s: %w", q.Name, err)
+		}
+		seen[q.Name] = true
+
+		old, ok := previous[q.Name]
+		switch {
+		case !ok:
+			d.Added = append(d.Added, q.Name)
+		case !reflect.DeepEqual(normalizeDoc(old), doc):
+			d.Modified = append(d.Modified, q.Name)
+		}
+	}
+
+	for name := range previous {
+		if !seen[name] {
+			d.Removed = append(d.Removed, name)
+		}
+	}
+
+	sort.Strings(d.Added)
+	sort.Strings(d.Removed)
+	sort.Strings(d.Modified)
+	return d, nil
+}
+
+// normalizeDoc converts a YAML-decoded document into the same generic form
+// renderDocument produces
+func normalizeDoc(doc any) any {
+	normalized, err := toJSONValue(doc)
+	if err != nil {
+		return doc
+	}
+	return normalized
+}
+
+// checkChangeRate fails when more than maxPct percent of the previous
+// catalog in dir was added, removed, or modified, unless force is set. A
+// missing previous catalog (first run) passes.
+func checkChangeRate(dir string, queries []Query, maxPct float64, force bool) error {
+	previous, err := loadPreviousCatalog(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		infof("No previous catalog in %s, skipping change-rate check\n", dir)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("loading previous catalog: %w", err)
+	}
+
+	d, err := diffCatalog(previous, queries)
+	if err != nil {
+		return fmt.Errorf("comparing catalogs: %w", err)
+	}
+
+	pct := changePercent(d, len(previous))
+	if pct <= maxPct {
+		return nil
+	}
+	if force {
+		warnf("%.1f%% of queries changed (%d of %d), above -max-change-pct %g; continuing due to -force\n", pct, d.changed(), len(previous), maxPct)
+		return nil
+	}
+	return fmt.Errorf("%.1f%% of queries changed (%d of %d), above -max-change-pct %g; rerun with -force to accept", pct, d.changed(), len(previous), maxPct)
+}
+
+// changePercent is the share of the previous catalog that changed. Against
+// an empty previous catalog any change counts as 100%.
+func changePercent(d catalogDiff, previousCount int) float64 {
+	if previousCount == 0 {
+		if d.changed() > 0 {
+			return 100
+		}
+		return 0
+	}
+	return float64(d.changed()) * 100 / float64(previousCount)
+}
+
+func printCatalogDiff(d catalogDiff, dir string) {
+	fmt.Printf("Changes against %s: %d added, %d removed, %d modified\n", dir, len(d.Added), len(d.Removed), len(d.Modified))
+	for _, name := range d.Added {
+		fmt.Printf("  + %s\n", name)
+	}
+	for _, name := range d.Removed {
+		fmt.Printf("  - %s\n", name)
+	}
+	for _, name := range d.Modified {
+		fmt.Printf("  ~ %s\n", name)
+	}
+}
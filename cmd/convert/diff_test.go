@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestLoadPreviousCatalogForms(t *testing.T) {
+	quietTest(t)
+	a, _ := parseTestQuery(t, "detection/execution/2-shell.sql", fixtureQuery)
+	b, _ := parseTestQuery(t, "policy/ssh-root.sql", "-- Root login over SSH\nSELECT 1;\n")
+	queries := []Query{a, b}
+
+	for _, single := range []bool{false, true} {
+		saved := singleDocument
+		singleDocument = single
+		dir := t.TempDir()
+		err := writeFleetYAML(queries, dir, "category")
+		singleDocument = saved
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		previous, err := loadPreviousCatalog(dir)
+		if err != nil {
+			t.Fatalf("single document %t: %v", single, err)
+		}
+		if len(previous) != 2 || previous[a.Name] == nil || previous[b.Name] == nil {
+			t.Fatalf("single document %t: loaded %v", single, previous)
+		}
+		d, err := diffCatalog(previous, queries)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d.changed() != 0 {
+			t.Errorf("single document %t: unchanged catalog diffs as %+v", single, d)
+		}
+
+		changed := []Query{a, b}
+		changed[1].Description = "Something else"
+		if d, _ := diffCatalog(previous, changed); len(d.Modified) != 1 || d.Modified[0] != b.Name {
+			t.Errorf("single document %t: modified query diffs as %+v", single, d)
+		}
+	}
+}
+
+func TestChangeRateSingleDocument(t *testing.T) {
+	upstream := writeUpstream(t, map[string]string{
+		"detection/execution/2-shell.sql": fixtureQuery,
+		"policy/ssh-root.sql":             "-- Root login over SSH\nSELECT 1;\n",
+	})
+	output, previous := t.TempDir(), t.TempDir()
+	if err := runConvert(t, "-upstream", upstream, "-output", previous, "-single-document"); err != nil {
+		t.Fatal(err)
+	}
+	// The baseline written as one list is read back for both comparisons
+	if err := runConvert(t, "-upstream", upstream, "-output", output, "-diff", previous, "-max-change-pct", "1"); err != nil {
+		t.Errorf("comparing against a -single-document catalog: %v", err)
+	}
+	if err := runConvert(t, "-upstream", upstream, "-output", previous, "-single-document", "-max-change-pct", "1"); err != nil {
+		t.Errorf("re-running over a -single-document catalog: %v", err)
+	}
+}
@@ -0,0 +1,494 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// headerRegex matches a "-- key: value" header line. Keys not in the
+// directive registry (e.g. "references:") are left to description handling.
+var headerRegex = regexp.MustCompile(`^--\s*([a-z_]+):\s*(.*)$`)
+
+// thousandsRegex matches integers with comma thousands separators, e.g. "3,600"
+var thousandsRegex = regexp.MustCompile(`^\d{1,3}(,\d{3})+$`)
+
+// versionConstraintRegex matches one comparator and version, e.g. ">=13.0"
+var versionConstraintRegex = regexp.MustCompile(`^(>=|<=|>|<|==|=|!=)?\s*\d+(\.\d+){0,3}$`)
+
+// assertionRegex matches a test assertion name, e.g. "expect_empty_on_clean_host"
+var assertionRegex = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// dateLayouts are the formats accepted for -- created: and -- updated:
+var dateLayouts = []string{
+	time.DateOnly,
+	"2006/01/02",
+	"2006.01.02",
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"Jan 2, 2006",
+	"January 2, 2006",
+	"2 Jan 2006",
+	"2 January 2006",
+	"2006-01",
+}
+
+// parseHeaderDate parses a date header in any of dateLayouts, warning and
+// returning the zero time when none matches
+func parseHeaderDate(src source, key, value string) time.Time {
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
+		}
+	}
+	src.warnf("%s must be a date like 2024-01-15, got %q\n", key, value)
+	return time.Time{}
+}
+
+// directive is a recognized header key
+type directive struct {
+	Key         string
+	Format      string // example value, shown by -list-directives
+	Description string
+	Separator   string         // joins a front-matter list into one value; "" = ", "
+	Repeatable  bool           // a front-matter list applies each item separately instead
+	Value       *regexp.Regexp // values must match, or they are reported and ignored; nil accepts any
+
+	// apply records value (already trimmed) on q; src is for warnings.
+	// nil for directives handled outside the header loop.
+	apply func(q *Query, value string, src source)
+}
+
+// directives is the registry of supported header keys, in -list-directives
+// order. Add to it with registerDirective.
+var directives []directive
+
+// directiveKeyRegex matches the keys headerRegex can capture
+var directiveKeyRegex = regexp.MustCompile(`^[a-z_]+$`)
+
+func init() {
+	for _, d := range builtinDirectives {
+		registerDirective(d)
+	}
+}
+
+// registerDirective adds d to the registry. Forks add organization-specific
+// headers by calling it from an init function in their own file, often with
+// a directive from annotationDirective. It panics on a malformed or
+// already registered key, since either is a programming error.
+func registerDirective(d directive) {
+	if !directiveKeyRegex.MatchString(d.Key) {
+		panic(fmt.Sprintf("directive key %q must be lowercase letters and underscores", d.Key))
+	}
+	if _, ok := lookupDirective(d.Key); ok {
+		panic(fmt.Sprintf("directive %q is already registered", d.Key))
+	}
+	directives = append(directives, d)
+}
+
+// annotationDirective returns a directive that records its value as the
+// annotation named key, for metadata with no Query field of its own.
+// Values must match value unless it is nil.
+func annotationDirective(key, format, description string, value *regexp.Regexp) directive {
+	return directive{
+		Key:         key,
+		Format:      format,
+		Description: description,
+		Value:       value,
+		apply: func(q *Query, v string, _ source) {
+			if q.Annotations == nil {
+				q.Annotations = map[string]string{}
+			}
+			q.Annotations[key] = v
+		},
+	}
+}
+
+// applyDirective records value on q with d, first checking it against
+// d.Value
+func applyDirective(d directive, q *Query, value string, src source) {
+	if d.Value != nil && !d.Value.MatchString(value) {
+		src.warnf("ignoring %s %q: want a value like %s\n", d.Key, value, d.Format)
+		return
+	}
+	d.apply(q, value, src)
+}
+
+// confidenceLevels are the values -- confidence: accepts
+var confidenceLevels = []string{"low", "medium", "high"}
+
+// confidenceTagPrefix starts the tag a query's confidence is emitted as,
+// e.g. confidence:medium
+const confidenceTagPrefix = "confidence:"
+
+// builtinDirectives are the directives the converter ships with
+var builtinDirectives = []directive{
+	{
+		Key:         "query_name",
+		Format:      "Suspicious SSH Tunnel",
+		Description: "Use this name instead of the one generated from the filename",
+		apply: func(q *Query, value string, _ source) {
+			q.Name = value
+		},
+	},
+	{
+		Key:         "summary",
+		Format:      "Shell spawned by a network daemon",
+		Description: "Short description for Fleet; the first comment block becomes the documentation annotation",
+		apply: func(q *Query, value string, _ source) {
+			q.Summary = value
+		},
+	},
+	{
+		Key:         "tags",
+		Format:      "persistent state process",
+		Description: "Space-separated tags",
+		Separator:   " ",
+		apply: func(q *Query, value string, _ source) {
+			q.Tags = normalizeList(strings.Fields(value))
+		},
+	},
+	{
+		Key:         "platform",
+		Format:      "comma-separated linux | darwin | windows | posix",
+		Description: "Target platforms; posix expands to darwin,linux",
+		apply: func(q *Query, value string, src source) {
+			q.Platform = normalizePlatform(src, value)
+		},
+	},
+	{
+		Key:         "enabled_platforms",
+		Format:      "comma-separated linux | darwin | windows | posix",
+		Description: "Narrows platform to these platforms, e.g. posix to linux",
+		apply: func(q *Query, value string, src source) {
+			q.EnabledOn = normalizePlatform(src, value)
+		},
+	},
+	{
+		Key:         "platform_version",
+		Format:      ">=13.0, <15",
+		Description: "Comma-separated OS version constraints, emitted as an annotation",
+		apply: func(q *Query, value string, src source) {
+			var constraints []string
+			for _, c := range strings.Split(value, ",") {
+				c = strings.Join(strings.Fields(c), "")
+				if c == "" {
+					continue
+				}
+				if !versionConstraintRegex.MatchString(c) {
+					src.warnf("platform_version constraint %q is not a comparator and version like >=13.0\n", c)
+					return
+				}
+				constraints = append(constraints, c)
+			}
+			q.PlatformVersion = strings.Join(constraints, ",")
+		},
+	},
+	{
+		Key:         "severity",
+		Format:      "low | medium | high",
+		Description: "Severity, emitted as an annotation; checked against the filename level",
+		apply: func(q *Query, value string, src source) {
+			severity := strings.ToLower(value)
+			if _, ok := severityLevels[severity]; !ok {
+				src.warnf("severity must be low, medium, or high, got %q\n", value)
+				return
+			}
+			q.Severity = severity
+		},
+	},
+	{
+		Key:         "confidence",
+		Format:      "low | medium | high",
+		Description: "How reliably a hit means malicious activity, emitted as a confidence: tag; distinct from severity",
+		apply: func(q *Query, value string, src source) {
+			confidence := strings.ToLower(value)
+			if !containsString(confidenceLevels, confidence) {
+				src.warnf("confidence must be low, medium, or high, got %q\n", value)
+				return
+			}
+			q.Confidence = confidence
+		},
+	},
+	{
+		Key:         "interval",
+		Format:      "300 | 3,600 | 0",
+		Description: "Interval in seconds; 0 emits interval: 0 so the query only runs on demand",
+		apply: func(q *Query, value string, src source) {
+			if thousandsRegex.MatchString(value) {
+				value = strings.ReplaceAll(value, ",", "")
+			}
+			interval, err := strconv.Atoi(value)
+			if err != nil || interval < 0 {
+				src.warnf("interval must be a non-negative integer, got %q\n", value)
+				return
+			}
+			q.Interval = interval
+			q.IntervalSet = true
+		},
+	},
+	{
+		Key:         "check_every",
+		Format:      "1h | 30m | 3600",
+		Description: "How often a policy is checked, as a duration or seconds; overrides interval for policies",
+		apply: func(q *Query, value string, src source) {
+			if q.Category != "policy" {
+				src.warnf("check_every only applies to policy queries, ignoring it\n")
+				return
+			}
+			seconds, err := strconv.Atoi(value)
+			if err != nil {
+				d, derr := time.ParseDuration(value)
+				seconds, err = int(d/time.Second), derr
+				if err == nil && d%time.Second != 0 {
+					err = fmt.Errorf("not a whole number of seconds")
+				}
+			}
+			if err != nil || seconds <= 0 {
+				src.warnf("check_every must be a positive duration like 1h or seconds, got %q\n", value)
+				return
+			}
+			q.CheckEvery = seconds
+		},
+	},
+	{
+		Key:         "interval_jitter",
+		Format:      "30",
+		Description: "Add a per-query offset of up to this many seconds to the interval",
+		apply: func(q *Query, value string, src source) {
+			jitter, err := strconv.Atoi(value)
+			if err != nil || jitter < 0 {
+				src.warnf("interval_jitter must be a non-negative integer, got %q\n", value)
+				return
+			}
+			q.IntervalJitter = jitter
+		},
+	},
+	{
+		Key:         "as",
+		Format:      "label | policy",
+		Description: "Emit a Fleet dynamic label (hosts where the SQL returns rows) or a policy (passing where it returns none) instead of a query",
+		Value:       regexp.MustCompile(`^(query|label|policy)$`),
+		apply: func(q *Query, value string, _ source) {
+			q.As = value
+		},
+	},
+	{
+		Key:         "logging",
+		Format:      "snapshot | differential | differential_ignore_removals",
+		Description: "Fleet logging type, overriding the category default and -auto-logging",
+		apply: func(q *Query, value string, src source) {
+			switch value = strings.ToLower(value); value {
+			case "snapshot", "differential", "differential_ignore_removals":
+				q.Logging = value
+			default:
+				src.warnf("logging must be snapshot, differential, or differential_ignore_removals, got %q\n", value)
+			}
+		},
+	},
+	{
+		Key:         "labels",
+		Format:      "production, linux-servers",
+		Description: "Comma-separated Fleet labels, emitted as labels_include_any",
+		apply: func(q *Query, value string, _ source) {
+			q.Labels = normalizeList(strings.Split(value, ","))
+		},
+	},
+	{
+		Key:         "team",
+		Format:      "Workstations",
+		Description: "Fleet team the query belongs to, overriding -team",
+		apply: func(q *Query, value string, _ source) {
+			q.Team = value
+		},
+	},
+	{
+		Key:         "observer_can_run",
+		Format:      "true | false",
+		Description: "Let Fleet observers run the query; omitted unless set",
+		apply: func(q *Query, value string, src source) {
+			q.ObserverCanRun = parseBoolHeader(src, "observer_can_run", value)
+		},
+	},
+	{
+		Key:         "denylist",
+		Format:      "true | false",
+		Description: "false exempts a long-running query from the watchdog denylist",
+		apply: func(q *Query, value string, src source) {
+			q.Denylist = parseBoolHeader(src, "denylist", value)
+		},
+	},
+	{
+		Key:         "discard_data",
+		Format:      "true | false",
+		Description: "true runs the query for automations only, without Fleet storing its results",
+		apply: func(q *Query, value string, src source) {
+			q.DiscardData = parseBoolHeader(src, "discard_data", value)
+		},
+	},
+	{
+		Key:         "requires_sudo",
+		Format:      "true | false",
+		Description: "Whether the query needs osquery running as root/admin, emitted as an annotation; acknowledges the privileged-table lint",
+		apply: func(q *Query, value string, src source) {
+			q.RequiresSudo = parseBoolHeader(src, "requires_sudo", value)
+		},
+	},
+	{
+		Key:         "osquery_flags",
+		Format:      "--disable_events=false",
+		Description: "Space-separated osqueryd flags the query depends on, emitted as an annotation",
+		Separator:   " ",
+		apply: func(q *Query, value string, src source) {
+			var flags []string
+			for _, f := range strings.Fields(value) {
+				if !strings.HasPrefix(f, "--") {
+					src.warnf("ignoring osquery flag %q: want a flag like --disable_events=false\n", f)
+					continue
+				}
+				flags = append(flags, f)
+			}
+			q.OsqueryFlags = normalizeList(flags)
+		},
+	},
+	{
+		Key:         "requires",
+		Format:      "network, edr",
+		Description: "Comma-separated host capabilities the query depends on, emitted as an annotation",
+		apply: func(q *Query, value string, _ source) {
+			q.Requires = normalizeList(strings.Split(strings.ToLower(value), ","))
+		},
+	},
+	{
+		Key:         "overlap",
+		Format:      "detection-c2-dns-tunnel",
+		Description: "Comma-separated names or slugs of related queries, emitted as the related annotation",
+		apply: func(q *Query, value string, _ source) {
+			q.RelatedTo = normalizeList(append(q.RelatedTo, strings.Split(value, ",")...))
+		},
+	},
+	{
+		Key:         "test",
+		Format:      "expect_empty_on_clean_host",
+		Description: "Expected-result assertion for a test harness; repeatable, written to -test-manifest",
+		Repeatable:  true,
+		apply: func(q *Query, value string, src source) {
+			if !assertionRegex.MatchString(value) {
+				src.warnf("ignoring test assertion %q: want a lowercase name such as expect_empty_on_clean_host\n", value)
+				return
+			}
+			if !containsString(q.TestAssertions, value) {
+				q.TestAssertions = append(q.TestAssertions, value)
+			}
+		},
+	},
+	{
+		Key:         "note",
+		Format:      "Expect noise on CI runners",
+		Description: "Operator-facing caveat, emitted as the note annotation; repeatable, lines are joined",
+		Repeatable:  true,
+		apply: func(q *Query, value string, _ source) {
+			if q.Note != "" {
+				value = q.Note + " " + value
+			}
+			q.Note = value
+		},
+	},
+	{
+		Key:         "maintenance_window",
+		Format:      "02:00-04:00",
+		Description: "Daily window, as 24-hour HH:MM-HH:MM, in which alerts from the query should be suppressed; emitted as an annotation",
+		apply: func(q *Query, value string, src source) {
+			start, end, ok := strings.Cut(strings.ReplaceAll(value, " ", ""), "-")
+			from, err1 := time.Parse("15:04", start)
+			to, err2 := time.Parse("15:04", end)
+			switch {
+			case !ok || err1 != nil || err2 != nil:
+				src.warnf("maintenance_window must be HH:MM-HH:MM in 24-hour time, got %q\n", value)
+			case from.Equal(to):
+				src.warnf("maintenance_window %q starts and ends at the same time\n", value)
+			default:
+				q.Maintenance = from.Format("15:04") + "-" + to.Format("15:04")
+			}
+		},
+	},
+	{
+		Key:         "triage",
+		Format:      "https://wiki.example.com/runbooks/dns-tunnel",
+		Description: "Runbook link for responders, emitted as an annotation",
+		apply: func(q *Query, value string, src source) {
+			u, err := url.Parse(value)
+			if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+				src.warnf("triage must be an http(s) URL, got %q\n", value)
+				return
+			}
+			q.Runbook = value
+		},
+	},
+	{
+		Key:         "created",
+		Format:      "2024-01-15",
+		Description: "Date the query was written, emitted as an annotation",
+		apply: func(q *Query, value string, src source) {
+			q.Created = parseHeaderDate(src, "created", value)
+		},
+	},
+	{
+		Key:         "updated",
+		Format:      "2024-06-01",
+		Description: "Date the query was last changed, emitted as an annotation; checked for staleness under -lint",
+		apply: func(q *Query, value string, src source) {
+			q.Updated = parseHeaderDate(src, "updated", value)
+		},
+	},
+	{
+		Key:         "enabled_from",
+		Format:      "2024-07-01",
+		Description: "Rollout date; the query is skipped before it unless -ignore-rollout is set",
+		apply: func(q *Query, value string, src source) {
+			q.EnabledFrom = parseHeaderDate(src, "enabled_from", value)
+		},
+	},
+	{
+		Key:         "deprecated",
+		Format:      "replaced by Unexpected Shell",
+		Description: "Mark the query as deprecated; skipped unless -include-deprecated is set",
+		apply: func(q *Query, value string, _ source) {
+			q.Deprecated = value
+			if q.Deprecated == "" {
+				q.Deprecated = "no reason given"
+			}
+		},
+	},
+	{
+		Key:         "include",
+		Format:      "common/users.sql",
+		Description: "Splice a shared snippet from the includes directory at this line",
+		// Expanded by expandInclude before header parsing, anywhere in the file
+	},
+}
+
+// lookupDirective returns the registered directive for key
+func lookupDirective(key string) (directive, bool) {
+	for _, d := range directives {
+		if d.Key == key {
+			return d, true
+		}
+	}
+	return directive{}, false
+}
+
+// printDirectives writes the directive registry as a reference table
+func printDirectives(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DIRECTIVE\tFORMAT\tDESCRIPTION")
+	for _, d := range directives {
+		fmt.Fprintf(tw, "-- %s:\t%s\t%s\n", d.Key, d.Format, d.Description)
+	}
+	return tw.Flush()
+}
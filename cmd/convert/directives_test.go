@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestRequires(t *testing.T) {
+	tests := []struct {
+		name, header, want string
+	}{
+		{"single", "-- requires: network", "network"},
+		{"multiple", "-- requires: network, EDR", "network,edr"},
+		{"duplicates and blanks", "-- requires: edr,, network , edr", "edr,network"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, _ := parseTestQuery(t, "detection/c2/2-dns.sql", "-- Long DNS names\n"+tt.header+"\nSELECT 1\n")
+			if got := strings.Join(q.Requires, ","); got != tt.want {
+				t.Errorf("requires = %q, want %q", got, tt.want)
+			}
+			doc, text := emitTestQuery(t, q)
+			if got := doc.Metadata.Annotations["requires"]; got != tt.want {
+				t.Errorf("requires annotation = %q, want %q in:\n%s", got, tt.want, text)
+			}
+		})
+	}
+
+	q, _ := parseTestQuery(t, "detection/c2/2-dns.sql", "-- Long DNS names\nSELECT 1\n")
+	if doc, _ := emitTestQuery(t, q); doc.Metadata.Annotations["requires"] != "" {
+		t.Errorf("requires annotation emitted without -- requires:")
+	}
+}
+
+func TestSortTags(t *testing.T) {
+	const content = "-- Unexpected shell\n-- tags: state process persistent process\nSELECT 1\n"
+	for _, tt := range []struct {
+		sort bool
+		want string
+	}{
+		{true, "persistent,process,state"},
+		{false, "state,process,persistent"},
+	} {
+		q, _ := parseTestQueryOpts(t, "detection/execution/2-shell.sql", content, parseOptions{SortTags: tt.sort})
+		if got := strings.Join(q.Tags, ","); got != tt.want {
+			t.Errorf("SortTags %v: tags = %q, want %q", tt.sort, got, tt.want)
+		}
+	}
+
+	// The emitted order is the same whichever order the source lists them in
+	a, _ := parseTestQuery(t, "detection/execution/2-shell.sql", "-- Shell\n-- tags: b a c\nSELECT 1\n")
+	b, _ := parseTestQuery(t, "detection/execution/2-shell.sql", "-- Shell\n-- tags: c b a\nSELECT 1\n")
+	docA, _ := emitTestQuery(t, a)
+	docB, _ := emitTestQuery(t, b)
+	if docA.Metadata.Annotations["tags"] != "a,b,c" || docB.Metadata.Annotations["tags"] != "a,b,c" {
+		t.Errorf("tags annotations = %q and %q, want a,b,c", docA.Metadata.Annotations["tags"], docB.Metadata.Annotations["tags"])
+	}
+}
+
+func TestConfidence(t *testing.T) {
+	for _, tt := range []struct {
+		value, want string
+		warns       bool
+	}{
+		{"low", "low", false},
+		{"medium", "medium", false},
+		{"High", "high", false},
+		{" MEDIUM ", "medium", false},
+		{"certain", "", true},
+		{"", "", true},
+	} {
+		// The tags line comes after confidence and must not drop its tag
+		content := "-- Shell\n-- confidence: " + tt.value + "\n-- tags: process\nSELECT 1\n"
+		q, warnings := parseTestQuery(t, "detection/execution/2-shell.sql", content)
+		if q.Confidence != tt.want || (len(warnings) > 0) != tt.warns {
+			t.Errorf("confidence %q parsed as %q with warnings %q", tt.value, q.Confidence, warnings)
+		}
+		want := "process"
+		if tt.want != "" {
+			want = "confidence:" + tt.want + ",process"
+		}
+		if got := strings.Join(q.Tags, ","); got != want {
+			t.Errorf("confidence %q gave tags %q, want %q", tt.value, got, want)
+		}
+	}
+}
+
+func TestIntervalAbsentVsZero(t *testing.T) {
+	tests := []struct {
+		name, header string
+		set          bool
+		interval     int
+		warnings     int
+	}{
+		{"absent", "", false, 0, 0},
+		{"explicit zero", "-- interval: 0\n", true, 0, 0},
+		{"positive", "-- interval: 300\n", true, 300, 0},
+		{"negative", "-- interval: -5\n", false, 0, 1},
+		{"not a number", "-- interval: hourly\n", false, 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, warnings := parseTestQuery(t, "detection/execution/2-shell.sql", "-- Shell\n"+tt.header+"SELECT 1\n")
+			if q.IntervalSet != tt.set || q.Interval != tt.interval || len(warnings) != tt.warnings {
+				t.Fatalf("interval %d, set %t, warnings %q; want %d, %t, %d warnings", q.Interval, q.IntervalSet, warnings, tt.interval, tt.set, tt.warnings)
+			}
+
+			// An absent interval leaves the field out; an explicit 0 emits it
+			doc, text := emitTestQuery(t, q)
+			interval, emitted := doc.Spec["interval"]
+			if emitted != tt.set || (emitted && interval != tt.interval) {
+				t.Errorf("emitted interval %v (present %t), want %d (present %t):\n%s", interval, emitted, tt.interval, tt.set, text)
+			}
+		})
+	}
+
+	// A policy default fills an absent interval but not an explicit 0
+	absent, _ := parseTestQuery(t, "policy/ssh.sql", "-- Ssh\nSELECT 1\n")
+	zero, _ := parseTestQuery(t, "policy/ssh.sql", "-- Ssh\n-- interval: 0\nSELECT 1\n")
+	if got := resolveMetadata(absent, policyInterval(3600)); got.Interval != 3600 {
+		t.Errorf("absent policy interval resolved to %d, want 3600", got.Interval)
+	}
+	if got := resolveMetadata(zero, policyInterval(3600)); got.Interval != 0 || !got.IntervalSet {
+		t.Errorf("explicit 0 resolved to %d, want to stay 0", got.Interval)
+	}
+}
+
+func TestCheckEvery(t *testing.T) {
+	tests := []struct {
+		name, path, header string
+		interval           int
+		warnings           []string
+	}{
+		{"duration", "policy/ssh.sql", "-- check_every: 1h\n", 3600, nil},
+		{"seconds", "policy/ssh.sql", "-- check_every: 600\n", 600, nil},
+		{"over interval", "policy/ssh.sql", "-- interval: 300\n-- check_every: 30m\n", 1800, nil},
+		{"default", "policy/ssh.sql", "", 900, nil},
+		// The -policy-interval default only reaches policies
+		{"not a policy", "detection/execution/2-shell.sql", "-- check_every: 1h\n", 0, []string{
+			"detection/execution/2-shell.sql:2: check_every only applies to policy queries, ignoring it",
+		}},
+		{"not a duration", "policy/ssh.sql", "-- check_every: hourly\n", 900, []string{
+			`policy/ssh.sql:2: check_every must be a positive duration like 1h or seconds, got "hourly"`,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, warnings := parseTestQuery(t, tt.path, "-- Ssh\n"+tt.header+"SELECT 1\n")
+			if !reflect.DeepEqual(warnings, tt.warnings) {
+				t.Errorf("warnings = %q, want %q", warnings, tt.warnings)
+			}
+			doc, text := emitTestQuery(t, resolveMetadata(q, policyInterval(900)))
+			if interval, _ := doc.Spec["interval"].(int); interval != tt.interval {
+				t.Errorf("emitted interval %v, want %d:\n%s", doc.Spec["interval"], tt.interval, text)
+			}
+		})
+	}
+}
+
+func TestPolicyIntervalFlag(t *testing.T) {
+	upstream := writeUpstream(t, map[string]string{
+		"policy/ssh.sql":  "-- Ssh\n-- check_every: 1h\nSELECT 1\n",
+		"policy/root.sql": "-- Root\nSELECT 1\n",
+	})
+	output := t.TempDir()
+	if err := runConvert(t, "-upstream", upstream, "-output", output, "-policy-interval", "900"); err != nil {
+		t.Fatal(err)
+	}
+	docs, err := loadCombinedDocs(filepath.Join(output, "chainguard-policy.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"[policy] Ssh": "interval: 3600\n", "[policy] Root": "interval: 900\n"}
+	for _, doc := range docs {
+		if !strings.Contains(doc.text, want[doc.name]) {
+			t.Errorf("%s: want %q in:\n%s", doc.name, want[doc.name], doc.text)
+		}
+	}
+	if len(docs) != len(want) {
+		t.Errorf("got %d policies, want %d", len(docs), len(want))
+	}
+}
+
+func TestDiscardData(t *testing.T) {
+	tests := []struct {
+		name, header string
+		want         any // nil = omitted
+		warnings     int
+	}{
+		{"absent", "", nil, 0},
+		{"true", "-- discard_data: true\n", true, 0},
+		{"false", "-- discard_data: false\n", false, 0},
+		{"invalid", "-- discard_data: sometimes\n", nil, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, warnings := parseTestQuery(t, "detection/execution/2-shell.sql", "-- Shell\n"+tt.header+"SELECT 1\n")
+			if len(warnings) != tt.warnings {
+				t.Errorf("warnings = %q, want %d", warnings, tt.warnings)
+			}
+			doc, text := emitTestQuery(t, q)
+			got, emitted := doc.Spec["discard_data"]
+			if emitted != (tt.want != nil) || got != tt.want {
+				t.Errorf("discard_data = %v (present %t), want %v:\n%s", got, emitted, tt.want, text)
+			}
+		})
+	}
+}
+
+// customDirectives lets a test register directives, restoring the built-in
+// registry afterwards
+func customDirectives(t *testing.T) {
+	saved := directives
+	directives = append([]directive(nil), saved...)
+	t.Cleanup(func() { directives = saved })
+}
+
+func TestCustomDirective(t *testing.T) {
+	customDirectives(t)
+	registerDirective(annotationDirective("owner", "secops@example.com", "Owning team", regexp.MustCompile(`^\S+@\S+$`)))
+	// A handler may set a Query field instead of an annotation
+	registerDirective(directive{
+		Key:         "escalate_to",
+		Format:      "Workstations",
+		Description: "Team that triages the query",
+		apply:       func(q *Query, value string, _ source) { q.Team = value },
+	})
+
+	tests := []struct {
+		name, header, owner, team string
+		warnings                  int
+	}{
+		{"valid", "-- owner: secops@example.com\n-- escalate_to: Servers\n", "secops@example.com", "Servers", 0},
+		{"value rejected", "-- owner: nobody\n", "", "", 1},
+		{"front matter", "-- ---\n-- owner: secops@example.com\n-- escalate_to: Servers\n-- ---\n", "secops@example.com", "Servers", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, warnings := parseTestQuery(t, "detection/execution/2-shell.sql", tt.header+fixtureQuery)
+			if len(warnings) != tt.warnings {
+				t.Errorf("warnings = %q, want %d", warnings, tt.warnings)
+			}
+			if got := queryAnnotations(q)["owner"]; got != tt.owner {
+				t.Errorf("owner annotation = %q, want %q", got, tt.owner)
+			}
+			if q.Team != tt.team {
+				t.Errorf("team = %q, want %q", q.Team, tt.team)
+			}
+		})
+	}
+
+	var buf bytes.Buffer
+	if err := printDirectives(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"-- owner:", "-- escalate_to:", "-- query_name:"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("-list-directives lacks %s:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestRegisterDirectiveRejects(t *testing.T) {
+	customDirectives(t)
+	for _, key := range []string{"query_name", "Owner", "owner-team", ""} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("registering %q did not panic", key)
+				}
+			}()
+			registerDirective(annotationDirective(key, "x", "x", nil))
+		}()
+	}
+}
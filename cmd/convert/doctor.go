@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// doctorFixture is parsed and re-serialized by the doctor's round-trip check
+const doctorFixture = `-- Detects a shell spawned by a network daemon
+-- platform: posix
+-- tags: process
+-- interval: 300
+-- references:
+--   * https://attack.mitre.org/techniques/T1059/004/
+SELECT p.pid, p.name
+FROM processes p
+WHERE p.name = 'sh';
+`
+
+type doctorCheck struct {
+	name string
+	run  func() error
+}
+
+// runDoctor checks that the binary is sane, by running its own parsing and
+// emitting on known input, and the environment it runs in: the tools it
+// calls, the upstream checkout's directories of categories, and the
+// -fleet-schema file when one is given. It prints PASS or FAIL for each check.
+func runDoctor(w io.Writer, upstreamDir string, categories []string, schemaPath string) error {
+	checks := []doctorCheck{
+		{"regular expressions match their examples", checkRegexps},
+		{"platform normalization round-trips", checkPlatforms},
+		{"embedded fixture parses and re-serializes", checkRoundTrip},
+		{"git is installed", checkGit},
+		{"upstream category directories present", func() error { return checkUpstream(upstreamDir, categories) }},
+	}
+
+	if schemaPath != "" {
+		checks = append(checks, doctorCheck{"-fleet-schema compiles", func() error {
+			_, err := compileFleetSchema(schemaPath)
+			return err
+		}})
+	}
+
+	failed := 0
+	for _, c := range checks {
+		if err := c.run(); err != nil {
+			fmt.Fprintf(w, "FAIL %s: %v\n", c.name, err)
+			failed++
+			continue
+		}
+		fmt.Fprintf(w, "PASS %s\n", c.name)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(checks))
+	}
+	return nil
+}
+
+// checkGit looks for the git binary, which -changed-since, -git-dates, and
+// provenance detection run
+func checkGit() error {
+	out, err := exec.Command("git", "--version").Output()
+	if err != nil {
+		return fmt.Errorf("running git: %w", err)
+	}
+	if !strings.HasPrefix(string(out), "git version") {
+		return fmt.Errorf("unexpected git --version output %q", strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func checkRegexps() error {
+	examples := []struct {
+		name  string
+		re    *regexp.Regexp
+		input string
+	}{
+		{"levelRegex", levelRegex, "1-unexpected-shell.sql"},
+		{"headerRegex", headerRegex, "-- tags: process"},
+		{"directiveKeyRegex", directiveKeyRegex, "check_every"},
+		{"includeRegex", includeRegex, "-- include: common/users.sql"},
+		{"categoryNameRegex", categoryNameRegex, "incident_response"},
+		{"techniqueRegex", techniqueRegex, "https://attack.mitre.org/techniques/T1059/004/"},
+		{"techniqueIDRegex", techniqueIDRegex, "T1059.004"},
+		{"versionConstraintRegex", versionConstraintRegex, ">=13.0"},
+		{"osqueryVersionRegex", osqueryVersionRegex, "5.2.0"},
+	}
+	for _, e := range examples {
+		if !e.re.MatchString(e.input) {
+			return fmt.Errorf("%s does not match %q", e.name, e.input)
+		}
+	}
+	return nil
+}
+
+func checkPlatforms() error {
+	var warnings []string
+	src := source{path: "doctor", warn: func(format string, args ...any) {
+		warnings = append(warnings, fmt.Sprintf(format, args...))
+	}}
+
+	cases := map[string]string{
+		"linux":           "linux",
+		"macos":           "darwin",
+		"posix":           strings.Join(posixPlatforms, ","),
+		"windows, darwin": "windows,darwin",
+		"all":             "",
+	}
+	for input, want := range cases {
+		got := normalizePlatform(src, input)
+		if got != want {
+			return fmt.Errorf("%q normalized to %q, want %q", input, got, want)
+		}
+		if again := normalizePlatform(src, got); again != got {
+			return fmt.Errorf("%q is not stable: %q normalized to %q", input, got, again)
+		}
+	}
+	if len(warnings) > 0 {
+		return fmt.Errorf("unexpected warning: %s", strings.TrimSpace(warnings[0]))
+	}
+	return nil
+}
+
+func checkRoundTrip() error {
+	var warnings []string
+	opts := parseOptions{EmptyDescription: "name", SortTags: true, Warn: func(format string, args ...any) {
+		warnings = append(warnings, fmt.Sprintf(format, args...))
+	}}
+
+	q, err := parseQueryReader(strings.NewReader(doctorFixture), "detection/execution/2-doctor.sql", "detection", "detection", opts)
+	if err != nil {
+		return err
+	}
+	if len(warnings) > 0 {
+		return fmt.Errorf("unexpected warning: %s", strings.TrimSpace(warnings[0]))
+	}
+	if q.Platform != strings.Join(posixPlatforms, ",") || q.Interval != 300 || q.Level != 2 || !containsString(q.Techniques, "T1059.004") {
+		return fmt.Errorf("fixture parsed incorrectly: %+v", q)
+	}
+
+	var buf bytes.Buffer
+	if err := writeQueryYAML(&buf, q); err != nil {
+		return err
+	}
+	if !bytes.HasPrefix(buf.Bytes(), []byte("apiVersion: "+apiVersion+"\nkind: query\n")) {
+		return fmt.Errorf("emitted YAML is not a query document:\n%s", buf.String())
+	}
+	if err := checkQueryValue(buf.Bytes(), blockScalarValue(q.Query)); err != nil {
+		return fmt.Errorf("emitted YAML does not match the parsed query: %w", err)
+	}
+	return nil
+}
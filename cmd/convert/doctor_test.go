@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDoctorEnvironment(t *testing.T) {
+	upstream := t.TempDir()
+	if err := os.Mkdir(filepath.Join(upstream, "detection"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	schema := filepath.Join(t.TempDir(), "fleet.schema.json")
+	if err := os.WriteFile(schema, []byte(`{"type": "object"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	broken := filepath.Join(t.TempDir(), "broken.schema.json")
+	if err := os.WriteFile(broken, []byte(`{"type": 1`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name             string
+		upstream, schema string
+		fails            []string // names of the checks expected to fail
+	}{
+		{"healthy", upstream, "", nil},
+		{"with schema", upstream, schema, nil},
+		{"missing upstream", filepath.Join(upstream, "missing"), "", []string{"upstream category directories present"}},
+		{"empty upstream", t.TempDir(), "", []string{"upstream category directories present"}},
+		{"broken schema", upstream, broken, []string{"-fleet-schema compiles"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := runDoctor(&out, tt.upstream, defaultCategories, tt.schema)
+			if (err != nil) != (len(tt.fails) > 0) {
+				t.Fatalf("runDoctor error = %v:\n%s", err, out.String())
+			}
+			var failed []string
+			for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+				if name, ok := strings.CutPrefix(line, "FAIL "); ok {
+					name, _, _ = strings.Cut(name, ": ")
+					failed = append(failed, name)
+				} else if !strings.HasPrefix(line, "PASS ") {
+					t.Errorf("line %q is neither PASS nor FAIL", line)
+				}
+			}
+			if strings.Join(failed, "|") != strings.Join(tt.fails, "|") {
+				t.Errorf("failed checks %q, want %q:\n%s", failed, tt.fails, out.String())
+			}
+			if tt.schema == "" && strings.Contains(out.String(), "-fleet-schema") {
+				t.Errorf("schema checked without -fleet-schema:\n%s", out.String())
+			}
+		})
+	}
+}
+
+func TestDoctorSelfChecks(t *testing.T) {
+	checks := map[string]func() error{"regexps": checkRegexps, "platforms": checkPlatforms, "round trip": checkRoundTrip}
+	for name, check := range checks {
+		if err := check(); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := runDoctor(&out, t.TempDir(), defaultCategories, ""); err == nil {
+		t.Fatalf("empty upstream passed:\n%s", out.String())
+	}
+	for _, line := range []string{"PASS regular expressions match their examples", "PASS platform normalization round-trips", "PASS embedded fixture parses and re-serializes"} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Errorf("doctor output lacks %q:\n%s", line, out.String())
+		}
+	}
+}
+
+func TestDoctorWithoutGit(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	if err := checkGit(); err == nil {
+		t.Error("checkGit passed with git off the PATH")
+	}
+}
+
+func TestDoctorCategoryMap(t *testing.T) {
+	// Only a mapped category holds queries
+	upstream := writeUpstream(t, map[string]string{"hunting/execution/2-shell.sql": fixtureQuery})
+	mapPath := filepath.Join(t.TempDir(), "categories.json")
+	if err := os.WriteFile(mapPath, []byte(`{"hunting": {"logging": "snapshot"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var err error
+	captureInfo(t, func() { err = runConvert(t, "-doctor", "-upstream", upstream) })
+	if err == nil {
+		t.Errorf("-doctor without -category-map passed an upstream of only hunting/")
+	}
+	out := captureInfo(t, func() { err = runConvert(t, "-doctor", "-upstream", upstream, "-category-map", mapPath) })
+	if err != nil {
+		t.Errorf("-doctor with -category-map: %v\n%s", err, strings.Join(out, "\n"))
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// stringList is a repeatable string flag
+type stringList []string
+
+func (s *stringList) String() string { return strings.Join(*s, ",") }
+
+func (s *stringList) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+// excludeSubcategories drops queries whose directory matches one of the
+// patterns and reports how many were dropped per pattern. A pattern matches a
+// directory relative to either the upstream root or the category, including
+// nested paths, so "execution", "detection/execution", and
+// "execution/shells" are all accepted.
+func excludeSubcategories(queries []Query, upstreamDir string, patterns []string) []Query {
+	if len(patterns) == 0 {
+		return queries
+	}
+
+	excluded := map[string]int{}
+	var kept []Query
+	for _, q := range queries {
+		if pattern, ok := matchSubcategory(q, upstreamDir, patterns); ok {
+			excluded[pattern]++
+			continue
+		}
+		kept = append(kept, q)
+	}
+
+	for _, pattern := range patterns {
+		infof("Excluded %d queries in subcategory %s\n", excluded[filepath.ToSlash(filepath.Clean(pattern))], pattern)
+	}
+	return kept
+}
+
+func matchSubcategory(q Query, upstreamDir string, patterns []string) (string, bool) {
+	rel, err := filepath.Rel(upstreamDir, filepath.Dir(q.Path))
+	if err != nil {
+		return "", false
+	}
+	dir := filepath.ToSlash(rel)
+	inCategory := strings.TrimPrefix(dir, q.Category+"/")
+
+	for _, pattern := range patterns {
+		p := filepath.ToSlash(filepath.Clean(pattern))
+		for _, candidate := range []string{dir, inCategory} {
+			if candidate == p || strings.HasPrefix(candidate, p+"/") {
+				return p, true
+			}
+		}
+	}
+	return "", false
+}
+
+// filterDeprecated drops deprecated queries unless include is set, and
+// lists the deprecated queries either way
+func filterDeprecated(queries []Query, include bool) []Query {
+	var kept []Query
+	var deprecated []Query
+	for _, q := range queries {
+		if q.Deprecated == "" {
+			kept = append(kept, q)
+			continue
+		}
+		deprecated = append(deprecated, q)
+		if include {
+			warnf("%s is deprecated: %s\n", q.Name, q.Deprecated)
+			kept = append(kept, q)
+		}
+	}
+
+	if len(deprecated) > 0 && !include {
+		infof("Skipped %d deprecated queries (use -include-deprecated to keep them):\n", len(deprecated))
+		for _, q := range deprecated {
+			infof("  %s: %s\n", q.Name, q.Deprecated)
+		}
+	}
+	return kept
+}
+
+// filterRollout drops queries whose -- enabled_from: date is still ahead of
+// now, unless ignore is set. Queries without a gate are always kept.
+func filterRollout(queries []Query, ignore bool, now time.Time) []Query {
+	var kept []Query
+	for _, q := range queries {
+		if q.EnabledFrom.IsZero() || !now.Before(q.EnabledFrom) {
+			kept = append(kept, q)
+			continue
+		}
+		if ignore {
+			infof("Including %s ahead of its rollout on %s\n", q.Name, q.EnabledFrom.Format(time.DateOnly))
+			kept = append(kept, q)
+			continue
+		}
+		infof("Skipping %s until its rollout on %s (use -ignore-rollout to include it)\n", q.Name, q.EnabledFrom.Format(time.DateOnly))
+	}
+	return kept
+}
+
+// searchExpr is a parsed -search expression: alternatives joined by OR, each
+// a list of lowercase phrases joined by AND
+type searchExpr [][]string
+
+// parseSearch parses "curl AND bash OR powershell". AND binds tighter than
+// OR, parentheses group, the operators must be uppercase, and the words
+// between two operators form one phrase. Parentheses a word balances
+// itself, as in count(*), are part of the phrase.
+func parseSearch(expr string) (searchExpr, error) {
+	p := &searchParser{tokens: searchTokens(expr)}
+	if len(p.tokens) == 0 {
+		return nil, fmt.Errorf("empty search")
+	}
+	parsed, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	switch p.peek() {
+	case "":
+		return parsed, nil
+	case ")":
+		return nil, fmt.Errorf("unbalanced parentheses: unexpected )")
+	default:
+		return nil, fmt.Errorf("a group needs AND or OR before it")
+	}
+}
+
+// searchTokens splits expr into words, operators, and the parentheses
+// opening and closing groups
+func searchTokens(expr string) []string {
+	var tokens []string
+	for _, word := range strings.Fields(expr) {
+		for strings.HasPrefix(word, "(") {
+			tokens = append(tokens, "(")
+			word = word[1:]
+		}
+		closing := 0
+		for strings.HasSuffix(word, ")") && strings.Count(word, ")") > strings.Count(word, "(") {
+			closing++
+			word = word[:len(word)-1]
+		}
+		if word != "" {
+			tokens = append(tokens, word)
+		}
+		for ; closing > 0; closing-- {
+			tokens = append(tokens, ")")
+		}
+	}
+	return tokens
+}
+
+// searchParser reads searchTokens by recursive descent, expanding each
+// group into alternatives as it goes
+type searchParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *searchParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+// or parses alternatives joined by OR
+func (p *searchParser) or() (searchExpr, error) {
+	parsed, err := p.and("")
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "OR" {
+		p.pos++
+		next, err := p.and("OR")
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, next...)
+	}
+	return parsed, nil
+}
+
+// and parses terms joined by AND; every alternative of one term is
+// combined with every alternative of the next. op is the operator before
+// the first term, for errors.
+func (p *searchParser) and(op string) (searchExpr, error) {
+	parsed, err := p.term(op)
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "AND" {
+		p.pos++
+		next, err := p.term("AND")
+		if err != nil {
+			return nil, err
+		}
+		var combined searchExpr
+		for _, left := range parsed {
+			for _, right := range next {
+				combined = append(combined, append(append([]string(nil), left...), right...))
+			}
+		}
+		parsed = combined
+	}
+	return parsed, nil
+}
+
+// term parses a phrase or a parenthesized group
+func (p *searchParser) term(op string) (searchExpr, error) {
+	if p.peek() == "(" {
+		p.pos++
+		if p.peek() == ")" {
+			return nil, fmt.Errorf("empty parentheses")
+		}
+		parsed, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("unbalanced parentheses: missing )")
+		}
+		p.pos++
+		return parsed, nil
+	}
+
+	var phrase []string
+	for ; p.pos < len(p.tokens); p.pos++ {
+		word := p.tokens[p.pos]
+		if word == "AND" || word == "OR" || word == "(" || word == ")" {
+			break
+		}
+		phrase = append(phrase, word)
+	}
+	if len(phrase) == 0 {
+		if op == "" {
+			op = p.peek()
+		}
+		if op == ")" {
+			return nil, fmt.Errorf("unbalanced parentheses: unexpected )")
+		}
+		return nil, fmt.Errorf("%s needs a search term on both sides", op)
+	}
+	return searchExpr{{strings.ToLower(strings.Join(phrase, " "))}}, nil
+}
+
+// matches reports whether the query's name, description, or SQL contains
+// every phrase of at least one alternative, ignoring case
+func (e searchExpr) matches(q Query) bool {
+	text := strings.ToLower(strings.Join([]string{q.Name, q.Description, q.LongDescription, q.Query}, "\n"))
+	for _, all := range e {
+		matched := true
+		for _, phrase := range all {
+			if !strings.Contains(text, phrase) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// filterSearch keeps the queries matching expr and reports how many did
+func filterSearch(queries []Query, expr searchExpr, text string) []Query {
+	if expr == nil {
+		return queries
+	}
+	var kept []Query
+	for _, q := range queries {
+		if expr.matches(q) {
+			kept = append(kept, q)
+		}
+	}
+	infof("Search %q matched %d of %d queries\n", text, len(kept), len(queries))
+	return kept
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDeprecated(t *testing.T) {
+	deprecated, warnings := parseTestQuery(t, "detection/execution/2-old-shell.sql", `-- Shell spawned by a daemon
+-- deprecated: replaced by Unexpected Shell
+SELECT pid FROM processes
+`)
+	if len(warnings) > 0 || deprecated.Deprecated != "replaced by Unexpected Shell" {
+		t.Fatalf("deprecated = %q with warnings %q", deprecated.Deprecated, warnings)
+	}
+	bare, _ := parseTestQuery(t, "detection/execution/2-older-shell.sql", "-- Older shell\n-- deprecated:\nSELECT 1\n")
+	if bare.Deprecated != "no reason given" {
+		t.Errorf("bare -- deprecated: gave %q", bare.Deprecated)
+	}
+	current, _ := parseTestQuery(t, "detection/execution/3-unexpected-shell.sql", "-- Shell\nSELECT pid FROM processes\n")
+	queries := []Query{deprecated, current, bare}
+
+	// Skipped by default, with each one listed in the summary
+	var kept []Query
+	summary := captureInfo(t, func() { kept = filterDeprecated(queries, false) })
+	if len(kept) != 1 || kept[0].Name != current.Name {
+		t.Errorf("kept %d queries, want only %s", len(kept), current.Name)
+	}
+	if len(summary) != 3 || !strings.Contains(summary[0], "Skipped 2 deprecated") ||
+		!strings.Contains(summary[1], deprecated.Name+": replaced by Unexpected Shell") || !strings.Contains(summary[2], bare.Name) {
+		t.Errorf("summary = %q", summary)
+	}
+
+	// Kept with a warning each under -include-deprecated, and tagged
+	warned := captureWarnings(t, func() { kept = filterDeprecated(queries, true) })
+	if len(kept) != 3 {
+		t.Errorf("kept %d queries with -include-deprecated, want 3", len(kept))
+	}
+	if len(warned) != 2 || !strings.Contains(warned[0], deprecated.Name+" is deprecated: replaced by Unexpected Shell") {
+		t.Errorf("warnings = %q", warned)
+	}
+	doc, text := emitTestQuery(t, deprecated)
+	if doc.Metadata.Annotations["deprecated"] != "replaced by Unexpected Shell" {
+		t.Errorf("deprecated annotation missing:\n%s", text)
+	}
+	if doc, _ := emitTestQuery(t, current); doc.Metadata.Annotations["deprecated"] != "" {
+		t.Errorf("current query annotated deprecated")
+	}
+}
+
+func TestParseSearch(t *testing.T) {
+	tests := []struct {
+		expr string
+		want searchExpr
+		err  string
+	}{
+		{"powershell", searchExpr{{"powershell"}}, ""},
+		{"Reverse  Shell", searchExpr{{"reverse shell"}}, ""},
+		{"curl AND bash", searchExpr{{"curl", "bash"}}, ""},
+		// AND binds tighter than OR
+		{"curl AND bash OR wget AND sh", searchExpr{{"curl", "bash"}, {"wget", "sh"}}, ""},
+		{"curl OR wget AND sh", searchExpr{{"curl"}, {"wget", "sh"}}, ""},
+		// Lowercase operators are words of the phrase
+		{"curl and bash", searchExpr{{"curl and bash"}}, ""},
+		{"(curl OR wget) AND sh", searchExpr{{"curl", "sh"}, {"wget", "sh"}}, ""},
+		{"sh AND ( curl OR wget )", searchExpr{{"sh", "curl"}, {"sh", "wget"}}, ""},
+		{"(curl OR wget) AND (bash OR sh)", searchExpr{{"curl", "bash"}, {"curl", "sh"}, {"wget", "bash"}, {"wget", "sh"}}, ""},
+		{"((curl))", searchExpr{{"curl"}}, ""},
+		// Parentheses a word balances are part of it
+		{"count(*) AND processes", searchExpr{{"count(*)", "processes"}}, ""},
+		{"(count(*) OR max(uid))", searchExpr{{"count(*)"}, {"max(uid)"}}, ""},
+
+		{"", nil, "empty search"},
+		{"AND curl", nil, "AND needs a search term on both sides"},
+		{"curl OR", nil, "OR needs a search term on both sides"},
+		{"curl AND OR bash", nil, "AND needs a search term on both sides"},
+		{"(curl OR wget", nil, "unbalanced parentheses: missing )"},
+		{"curl OR wget)", nil, "unbalanced parentheses: unexpected )"},
+		{") curl", nil, "unbalanced parentheses: unexpected )"},
+		{"()", nil, "empty parentheses"},
+		{"curl (bash)", nil, "a group needs AND or OR before it"},
+	}
+	for _, tt := range tests {
+		got, err := parseSearch(tt.expr)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("parseSearch(%q) error = %v, want %q", tt.expr, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseSearch(%q) = %q, %v; want %q", tt.expr, got, err, tt.want)
+		}
+	}
+}
+
+func TestSearchMatches(t *testing.T) {
+	queries := []Query{
+		{Name: "curl", Query: "SELECT * FROM processes WHERE name = 'curl' AND parent = 'bash'"},
+		{Name: "wget", Query: "SELECT * FROM processes WHERE name = 'wget' AND parent = 'sh'"},
+		{Name: "count", Query: "SELECT count(*) FROM users"},
+	}
+	for _, tt := range []struct {
+		expr string
+		want []string
+	}{
+		{"(curl OR wget) AND bash", []string{"curl"}},
+		{"curl OR wget AND sh", []string{"curl", "wget"}},
+		{"COUNT(*)", []string{"count"}},
+	} {
+		expr, err := parseSearch(tt.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, q := range queries {
+			if expr.matches(q) {
+				got = append(got, q.Name)
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q matched %q, want %q", tt.expr, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"gopkg.in/yaml.v3"
+)
+
+// validateFleetSchema renders every query as it would be emitted and checks
+// the resulting document against a Fleet spec JSON schema. All failures are
+// reported before returning an error.
+func validateFleetSchema(queries []Query, schemaPath string) error {
+	schema, err := compileFleetSchema(schemaPath)
+	if err != nil {
+		return err
+	}
+
+	failures := 0
+	for _, q := range queries {
+		doc, err := renderDocument(q)
+		if err != nil {
+			return fmt.Errorf("%s: %w", q.Name, err)
+		}
+
+		err = schema.Validate(doc)
+		if err == nil {
+			continue
+		}
+
+		verr, ok := err.(*jsonschema.ValidationError)
+		if !ok {
+			return fmt.Errorf("%s: %w", q.Name, err)
+		}
+		for _, unit := range verr.BasicOutput().Errors {
+			if unit.Error == nil {
+				continue
+			}
+			field := unit.InstanceLocation
+			if field == "" {
+				field = "/"
+			}
+			fmt.Fprintf(os.Stderr, "Schema: %s: %s: %s\n", q.Name, field, unit.Error)
+			failures++
+		}
+	}
+
+	if failures > 0 {
+		return fmt.Errorf("%d schema violation(s)", failures)
+	}
+	return nil
+}
+
+// compileFleetSchema loads and compiles the JSON schema at schemaPath
+func compileFleetSchema(schemaPath string) (*jsonschema.Schema, error) {
+	absPath, err := filepath.Abs(schemaPath)
+	if err != nil {
+		return nil, err
+	}
+	schema, err := jsonschema.NewCompiler().Compile(absPath)
+	if err != nil {
+		return nil, fmt.Errorf("compiling schema %s: %w", schemaPath, err)
+	}
+	return schema, nil
+}
+
+// renderDocument emits q as YAML and decodes it back into a generic value
+// suitable for JSON schema validation
+func renderDocument(q Query) (any, error) {
+	var buf bytes.Buffer
+	if err := writeQueryYAML(&buf, q); err != nil {
+		return nil, err
+	}
+
+	var doc any
+	if err := yaml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		return nil, fmt.Errorf("emitted YAML does not parse: %w", err)
+	}
+
+	return toJSONValue(doc)
+}
+
+// toJSONValue round-trips v through JSON so numbers and maps have the types
+// the schema validator expects
+func toJSONValue(v any) (any, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return jsonschema.UnmarshalJSON(bytes.NewReader(data))
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// frontMatterFence opens and closes a YAML front-matter block, which may
+// replace the "-- key: value" header lines at the top of a query file
+const frontMatterFence = "-- ---"
+
+// frontMatterTechniqueKeys hold ATT&CK IDs or reference URLs
+var frontMatterTechniqueKeys = []string{"attack", "techniques", "references"}
+
+// applyFrontMatter records the YAML between the fences on q. Directive keys
+// take the same values as their header lines, with lists joined by the
+// directive's separator. fence is the line number of the opening fence, for
+// warnings. It returns the description lines, if the block has any.
+func applyFrontMatter(q *Query, lines []string, src source, fence int) ([]string, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(strings.Join(lines, "\n")), &doc); err != nil {
+		return nil, fmt.Errorf("front matter opened on line %d: %w", fence, err)
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("line %d: front matter must be a mapping of keys to values", fence+root.Line)
+	}
+
+	var description []string
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i].Value, root.Content[i+1]
+		src.line = fence + root.Content[i].Line
+
+		values, ok := frontMatterValues(value)
+		if !ok {
+			src.warnf("front matter key %s must be a value or a list of values\n", key)
+			continue
+		}
+
+		switch {
+		case key == "description":
+			for _, line := range strings.Split(strings.Join(values, "\n"), "\n") {
+				if line = strings.TrimSpace(line); line != "" {
+					description = append(description, line)
+				}
+			}
+		case key == "level":
+			level, err := strconv.Atoi(strings.Join(values, ""))
+			if err != nil || level < 1 || level > 3 {
+				src.warnf("level must be 1, 2, or 3, got %q\n", strings.Join(values, ", "))
+				continue
+			}
+			q.Level = level
+		case containsString(frontMatterTechniqueKeys, key):
+			for _, v := range values {
+				q.Techniques = appendTechniques(q.Techniques, v)
+			}
+		default:
+			d, ok := lookupDirective(key)
+			if !ok || d.apply == nil {
+				src.warnf("unknown front matter key %s\n", key)
+				continue
+			}
+			if d.Repeatable {
+				for _, v := range values {
+					applyDirective(d, q, strings.TrimSpace(v), src)
+				}
+				continue
+			}
+			sep := d.Separator
+			if sep == "" {
+				sep = ", "
+			}
+			applyDirective(d, q, strings.TrimSpace(strings.Join(values, sep)), src)
+		}
+	}
+	return description, nil
+}
+
+// frontMatterValues returns the scalar or list of scalars in n
+func frontMatterValues(n *yaml.Node) ([]string, bool) {
+	switch n.Kind {
+	case yaml.ScalarNode:
+		return []string{n.Value}, true
+	case yaml.SequenceNode:
+		values := make([]string, 0, len(n.Content))
+		for _, item := range n.Content {
+			if item.Kind != yaml.ScalarNode {
+				return nil, false
+			}
+			values = append(values, item.Value)
+		}
+		return values, true
+	}
+	return nil, false
+}
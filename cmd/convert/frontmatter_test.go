@@ -0,0 +1,104 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// fixtureFrontMatter is fixtureQuery with its headers as YAML front matter
+const fixtureFrontMatter = `-- ---
+-- description: Detects a shell spawned by a network daemon
+-- platform: posix
+-- tags: [process]
+-- interval: 300
+-- references:
+--   - https://attack.mitre.org/techniques/T1059/004/
+-- ---
+SELECT p.pid, p.name
+FROM processes p
+WHERE p.name = 'sh';
+`
+
+func TestFrontMatterMatchesHeaderLines(t *testing.T) {
+	lines, warnings := parseTestQuery(t, "detection/execution/2-shell.sql", fixtureQuery)
+	fm, fmWarnings := parseTestQuery(t, "detection/execution/2-shell.sql", fixtureFrontMatter)
+	if len(warnings)+len(fmWarnings) > 0 {
+		t.Errorf("unexpected warnings: %q %q", warnings, fmWarnings)
+	}
+	if fm.Description != lines.Description || fm.Platform != lines.Platform || fm.Interval != lines.Interval ||
+		strings.Join(fm.Tags, ",") != strings.Join(lines.Tags, ",") || strings.Join(fm.Techniques, ",") != strings.Join(lines.Techniques, ",") ||
+		fm.Query != lines.Query {
+		t.Errorf("front matter parsed as %+v,\nheader lines as %+v", fm, lines)
+	}
+}
+
+func TestFrontMatter(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		check    func(Query) bool
+		warnings []string // substrings, one per expected warning
+	}{
+		{
+			"lists and level",
+			"-- ---\n-- description: |\n--   Shell from a daemon.\n--   Web servers never need one.\n-- level: 3\n-- tags: [process, execution]\n-- attack: [T1059.004, T1071]\n-- platform: [linux, darwin]\n-- ---\nSELECT 1\n",
+			func(q Query) bool {
+				return q.Description == "Shell from a daemon." && q.Level == 3 && strings.Join(q.Tags, ",") == "execution,process" &&
+					strings.Join(q.Techniques, ",") == "T1059.004,T1071" && q.Platform == "linux,darwin"
+			},
+			nil,
+		},
+		{
+			"bad values are reported",
+			"-- ---\n-- description: Shell\n-- level: 5\n-- colour: blue\n-- tags: {a: b}\n-- ---\nSELECT 1\n",
+			func(q Query) bool { return q.Level == 2 && len(q.Tags) == 0 },
+			[]string{":3: level must be 1, 2, or 3", ":4: unknown front matter key colour", ":5: front matter key tags must be"},
+		},
+		{
+			"blank lines before the fence",
+			"\n-- ---\n-- interval: 60\n-- ---\n-- Shell\nSELECT 1\n",
+			func(q Query) bool { return q.Interval == 60 && q.Description == "Shell" },
+			nil,
+		},
+		{
+			// A fence after other content is an ordinary comment
+			"fence after the header",
+			"-- Shell\n-- ---\n-- interval: 60\nSELECT 1\n",
+			func(q Query) bool { return q.Interval == 60 && q.Description == "Shell" },
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, warnings := parseTestQuery(t, "detection/execution/2-shell.sql", tt.content)
+			if !tt.check(q) {
+				t.Errorf("parsed as %+v", q)
+			}
+			if len(warnings) != len(tt.warnings) {
+				t.Fatalf("warnings = %q, want %d", warnings, len(tt.warnings))
+			}
+			for i, want := range tt.warnings {
+				if !strings.Contains(warnings[i], want) {
+					t.Errorf("warning %q lacks %q", warnings[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestFrontMatterErrors(t *testing.T) {
+	tests := []struct{ name, content, want string }{
+		{"unclosed", "-- ---\n-- interval: 60\n", `line 1: front matter is not closed`},
+		{"SQL inside", "-- ---\n-- interval: 60\nSELECT 1\n", "line 3: front matter lines must start with --"},
+		{"invalid YAML", "-- ---\n-- tags: [process\n-- ---\nSELECT 1\n", "front matter opened on line 1"},
+		{"not a mapping", "-- ---\n-- - process\n-- ---\nSELECT 1\n", "front matter must be a mapping"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseQueryReader(strings.NewReader(tt.content), "detection/execution/2-shell.sql", "detection", "detection", parseOptions{Warn: func(string, ...any) {}})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// gitCommitDates returns the date of the last commit that touched each file
+// under upstreamDir, keyed by slash-separated path relative to it. One pass
+// over the log serves every file, which is much faster than a git log per
+// file on a large checkout.
+func gitCommitDates(upstreamDir string) (map[string]time.Time, error) {
+	cmd := exec.Command("git", "-C", upstreamDir, "log", "--format=%x00%cI", "--name-only", "--relative", "--no-renames", "--")
+	out, err := cmd.Output()
+	if err != nil {
+		if exit, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("git log: %s", strings.TrimSpace(string(exit.Stderr)))
+		}
+		return nil, err
+	}
+
+	// Newest commits come first, so the first date seen for a path is its
+	// last change
+	dates := map[string]time.Time{}
+	var date time.Time
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if stamp, ok := strings.CutPrefix(line, "\x00"); ok {
+			if date, err = time.Parse(time.RFC3339, stamp); err != nil {
+				return nil, fmt.Errorf("git log: commit date %q: %w", stamp, err)
+			}
+			continue
+		}
+		if _, seen := dates[line]; line != "" && !seen {
+			dates[line] = date
+		}
+	}
+	return dates, scanner.Err()
+}
+
+// addGitDates sets Committed on each query from the upstream git history.
+// Without git or a checkout it warns once and leaves the dates unset.
+func addGitDates(queries []Query, upstreamDir string) {
+	dates, err := gitCommitDates(upstreamDir)
+	if err != nil {
+		warnf("-git-dates: %v; leaving commit dates unset\n", err)
+		return
+	}
+	for i, q := range queries {
+		rel, err := filepath.Rel(upstreamDir, q.Path)
+		if err != nil {
+			continue
+		}
+		if date, ok := dates[filepath.ToSlash(rel)]; ok {
+			queries[i].Committed = date
+		} else {
+			debugf("%s has no commit history\n", q.Path)
+		}
+	}
+}
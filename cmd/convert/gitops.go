@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// writeGitOpsQueries writes each query to its gitopsFilename in dir as a
+// one-item query list, the layout Fleet GitOps references with "- path:"
+// entries. Generated files for queries that no longer exist are removed.
+func writeGitOpsQueries(queries []Query, dir string, stableFilenames bool) error {
+	if err := checkGitOpsFilenames(queries, stableFilenames); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	current := map[string]bool{}
+	for _, q := range queries {
+		// GitOps assigns a query to a team by the file that references it
+		q.Team = ""
+		filename := gitopsFilename(q, stableFilenames)
+		current[filename] = true
+		err := writeFileAtomic(filepath.Join(dir, filename), func(w io.Writer) error {
+			io.WriteString(w, headerComment)
+			return writeCheckedYAML(w, q, func(w io.Writer) error { return writeQueryFields(w, q, "- ") })
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".yml") || current[entry.Name()] {
+			continue
+		}
+		debugf("Removing stale %s\n", entry.Name())
+		if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// gitopsFilename names the GitOps file of q after its slug, or with
+// stableFilenames after its whole source path: each element slugified, the
+// level prefix kept, and the elements joined by "--". Slugified elements
+// never hold "--", so paths only share a name when two elements slugify
+// alike, such as dns_tunnel.sql and dns-tunnel.sql.
+func gitopsFilename(q Query, stableFilenames bool) string {
+	if !stableFilenames {
+		return q.Slug + ".yml"
+	}
+	elements := strings.Split(strings.TrimSuffix(q.Source, ".sql"), "/")
+	for i, element := range elements {
+		elements[i] = slugify(element)
+	}
+	return strings.Join(elements, "--") + ".yml"
+}
+
+// checkGitOpsFilenames rejects two queries that would be written to the same
+// GitOps file
+func checkGitOpsFilenames(queries []Query, stableFilenames bool) error {
+	first := map[string]string{}
+	for _, q := range queries {
+		filename := gitopsFilename(q, stableFilenames)
+		if source, ok := first[filename]; ok {
+			return fmt.Errorf("%s and %s would both be written to %s; rename one of them", source, q.Source, filename)
+		}
+		first[filename] = q.Source
+	}
+	return nil
+}
+
+// gitopsDefaultHeader opens the generated default.yml. The org settings are
+// environment references, which fleetctl gitops substitutes when applying.
+const gitopsDefaultHeader = `# Generated by convert -emit-controls; edits are overwritten on the next run.
+# Replace the placeholders below, or set FLEET_URL and FLEET_ORG_NAME in the
+# environment fleetctl gitops runs in.
+org_settings:
+  server_settings:
+    server_url: $FLEET_URL
+  org_info:
+    org_name: $FLEET_ORG_NAME
+agent_options:
+  config:
+    options: {}
+controls: {}
+policies: []
+`
+
+// writeGitOpsDefault writes default.yml in dir with baseline controls and a
+// "- path:" reference to every query file written by writeGitOpsQueries
+func writeGitOpsDefault(queries []Query, dir string, stableFilenames bool) error {
+	filename := filepath.Join(dir, "default.yml")
+	err := writeFileAtomic(filename, func(w io.Writer) error {
+		io.WriteString(w, gitopsDefaultHeader)
+		if len(queries) == 0 {
+			io.WriteString(w, "queries: []\n")
+			return nil
+		}
+		io.WriteString(w, "queries:\n")
+		for _, q := range queries {
+			fmt.Fprintf(w, "  - path: ./queries/%s\n", gitopsFilename(q, stableFilenames))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	infof("Wrote %s\n", filename)
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGitOpsFilename(t *testing.T) {
+	tests := []struct {
+		source, slug, stable string
+	}{
+		{"detection/c2/1-dns-tunnel.sql", "detection-c2-dns-tunnel.yml", "detection--c2--1-dns-tunnel.yml"},
+		{"policy/ssh_root.sql", "policy-ssh-root.yml", "policy--ssh-root.yml"},
+		{"incident_response/Users.SQL.sql", "incident-response-users-sql.yml", "incident-response--users-sql.yml"},
+	}
+	for _, tt := range tests {
+		q, _ := parseTestQuery(t, tt.source, fixtureQuery)
+		if got := gitopsFilename(q, false); got != tt.slug {
+			t.Errorf("%s: file %s, want %s", tt.source, got, tt.slug)
+		}
+		if got := gitopsFilename(q, true); got != tt.stable {
+			t.Errorf("%s: stable file %s, want %s", tt.source, got, tt.stable)
+		}
+
+		// Renaming the query does not move its file
+		q.Name = "Something else entirely"
+		if got := gitopsFilename(q, true); got != tt.stable {
+			t.Errorf("%s: renamed query moved to %s", tt.source, got)
+		}
+	}
+}
+
+func TestGitOpsFilenameCollisions(t *testing.T) {
+	// Paths that share a slug are still told apart by their stable names
+	distinct := []string{
+		"detection/c2/1-dns-tunnel.sql",
+		"detection/c2/2-dns-tunnel.sql",
+		"detection/c2-dns/tunnel.sql",
+		"detection/c2/dns/tunnel.sql",
+		"detection/c2/dns-tunnel.sql",
+		"detection/c2--dns-tunnel.sql",
+	}
+	var queries []Query
+	for _, source := range distinct {
+		q, _ := parseTestQuery(t, source, fixtureQuery)
+		queries = append(queries, q)
+	}
+	if err := checkGitOpsFilenames(queries, true); err != nil {
+		t.Errorf("distinct paths collide: %v", err)
+	}
+	if err := checkGitOpsFilenames(queries, false); err == nil {
+		t.Errorf("shared slugs did not collide")
+	}
+
+	// Only elements that slugify alike share a file
+	a, _ := parseTestQuery(t, "detection/c2/dns_tunnel.sql", fixtureQuery)
+	b, _ := parseTestQuery(t, "detection/c2/dns-tunnel.sql", fixtureQuery)
+	err := writeGitOpsQueries([]Query{a, b}, t.TempDir(), true)
+	if err == nil || !strings.Contains(err.Error(), "detection/c2/dns_tunnel.sql and detection/c2/dns-tunnel.sql would both be written to detection--c2--dns-tunnel.yml") {
+		t.Errorf("collision gave %v", err)
+	}
+}
+
+func TestStableFilenamesFlag(t *testing.T) {
+	upstream := writeUpstream(t, map[string]string{
+		"detection/execution/2-shell.sql": fixtureQuery,
+		"policy/ssh-root.sql":             "-- Root login over SSH\n-- query_name: Root SSH\nSELECT 1;\n",
+	})
+	output := t.TempDir()
+	if err := runConvert(t, "-upstream", upstream, "-output", output, "-format", "gitops", "-emit-controls", "-stable-filenames"); err != nil {
+		t.Fatal(err)
+	}
+	files := strings.Join(listFiles(t, output), " ")
+	if want := "default.yml queries/detection--execution--2-shell.yml queries/policy--ssh-root.yml"; files != want {
+		t.Errorf("files = %s, want %s", files, want)
+	}
+	data, err := os.ReadFile(filepath.Join(output, "default.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "  - path: ./queries/policy--ssh-root.yml\n") {
+		t.Errorf("default.yml does not reference the stable file:\n%s", data)
+	}
+	data, err = os.ReadFile(filepath.Join(output, "queries", "policy--ssh-root.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), "- name: Root SSH\n") {
+		t.Errorf("file does not hold the display name:\n%s", data)
+	}
+
+	if err := runConvert(t, "-upstream", upstream, "-output", t.TempDir(), "-stable-filenames"); err == nil {
+		t.Errorf("-stable-filenames accepted without -format gitops")
+	}
+}
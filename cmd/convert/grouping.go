@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// grouper splits detections into files by a metadata key. A query appears
+// in the file of every key it returns; queries without one go to fallback.
+type grouper struct {
+	keys     func(q Query) []string
+	filename func(key string) string
+	order    []string // optional fixed key order; other keys sort after it
+	fallback string
+}
+
+// groupers holds the -group-by modes besides the default "category"
+var groupers = map[string]grouper{
+	"tactic": {
+		keys:     func(q Query) []string { return tacticsFor(q.Techniques) },
+		filename: func(key string) string { return "chainguard-" + key + ".yml" },
+		order:    tacticOrder,
+		fallback: "uncategorized",
+	},
+	"subcategory": {
+		keys:     func(q Query) []string { return nonEmpty(q.Subcategory) },
+		filename: detectionGroupFile("subcategory"),
+		fallback: "none",
+	},
+	"platform": {
+		keys:     func(q Query) []string { return nonEmpty(strings.Split(q.Platform, ",")...) },
+		filename: detectionGroupFile("platform"),
+		fallback: "common",
+	},
+	"level": {
+		keys: func(q Query) []string {
+			if q.Level == 0 {
+				return nil
+			}
+			return []string{strconv.Itoa(q.Level)}
+		},
+		filename: detectionGroupFile("level"),
+		fallback: "none",
+	},
+	"tag": {
+		keys:     func(q Query) []string { return q.Tags },
+		filename: detectionGroupFile("tag"),
+		fallback: "untagged",
+	},
+}
+
+// groupByKeys lists the accepted -group-by values
+func groupByKeys() []string {
+	keys := []string{"category", "platform-category"}
+	for key := range groupers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// detectionGroupFile names files chainguard-detection-<mode>-<key>.yml, so
+// keys cannot collide with the fixed output files
+func detectionGroupFile(mode string) func(key string) string {
+	return func(key string) string {
+		return fmt.Sprintf("chainguard-detection-%s.yml", generateSlug(mode, nil, key))
+	}
+}
+
+func nonEmpty(values ...string) []string {
+	var out []string
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// writeGroupedFiles writes one detection file per key of the named grouper
+func writeGroupedFiles(queries []Query, outputDir, groupBy string) error {
+	g := groupers[groupBy]
+
+	byKey := map[string][]Query{}
+	for _, q := range queries {
+		keys := g.keys(q)
+		if len(keys) == 0 {
+			keys = []string{g.fallback}
+		}
+		for _, key := range keys {
+			byKey[key] = append(byKey[key], q)
+		}
+	}
+
+	var extra []string
+	for key := range byKey {
+		if !containsString(g.order, key) {
+			extra = append(extra, key)
+		}
+	}
+	sort.Strings(extra)
+
+	for _, key := range append(append([]string{}, g.order...), extra...) {
+		keyQueries := byKey[key]
+		if len(keyQueries) == 0 {
+			continue
+		}
+
+		filename := filepath.Join(outputDir, g.filename(key))
+		if err := writeQueryFile(filename, keyQueries); err != nil {
+			return err
+		}
+		infof("Wrote %s (%d queries)\n", filename, len(keyQueries))
+	}
+
+	return nil
+}
+
+// writePlatformCategoryFiles writes one file per platform and category, e.g.
+// chainguard-darwin-detection.yml, with its fixed-interval variant such as
+// chainguard-darwin-detection-5min.yml, plus the combined file.
+// Multi-platform queries appear in each of their platforms' files; queries
+// without a platform go to the common variant.
+func writePlatformCategoryFiles(queries []Query, outputDir string) error {
+	type group struct{ platform, category string }
+	byGroup := map[group][]Query{}
+	var groups []group
+	for _, q := range queries {
+		platforms := []string{"common"}
+		if q.Platform != "" {
+			platforms = strings.Split(q.Platform, ",")
+		}
+		for _, platform := range platforms {
+			g := group{platform, q.Category}
+			if _, ok := byGroup[g]; !ok {
+				groups = append(groups, g)
+			}
+			byGroup[g] = append(byGroup[g], q)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].platform != groups[j].platform {
+			return groups[i].platform < groups[j].platform
+		}
+		return groups[i].category < groups[j].category
+	})
+
+	for _, g := range groups {
+		base := fmt.Sprintf("chainguard-%s-%s", g.platform, strings.ReplaceAll(g.category, "_", "-"))
+		groupQueries := byGroup[g]
+		filename := filepath.Join(outputDir, base+".yml")
+		if err := writeQueryFile(filename, groupQueries); err != nil {
+			return err
+		}
+		infof("Wrote %s (%d queries)\n", filename, len(groupQueries))
+
+		for _, variant := range fixedIntervalVariants {
+			if variant.category != g.category {
+				continue
+			}
+			scheduledFile := filepath.Join(outputDir, base+"-"+variant.suffix()+".yml")
+			if err := writeQueryFile(scheduledFile, groupQueries, fixedInterval(variant.interval)); err != nil {
+				return err
+			}
+			infof("Wrote %s (%d queries, %d-min interval)\n", scheduledFile, len(groupQueries), variant.interval/60)
+		}
+	}
+
+	combinedFile := filepath.Join(outputDir, "chainguard-all.yml")
+	if err := writeQueryDocumentFile(combinedFile, queries); err != nil {
+		return fmt.Errorf("writing combined file: %w", err)
+	}
+	infof("Wrote %s (%d queries)\n", combinedFile, len(queries))
+	return nil
+}
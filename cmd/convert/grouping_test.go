@@ -0,0 +1,161 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// groupingQueries covers every grouping key, including queries missing
+// each one
+func groupingQueries() []Query {
+	return []Query{
+		{Name: "A", Query: "SELECT 1", Category: "detection", Subcategory: "execution", Platform: "darwin,linux", Level: 3,
+			Tags: []string{"process", "state"}, Techniques: []string{"T1059.004"}},
+		{Name: "B", Query: "SELECT 2", Category: "detection", Subcategory: "c2", Platform: "linux", Level: 1,
+			Tags: []string{"network", "process"}, Techniques: []string{"T1071", "T1059"}},
+		{Name: "C", Query: "SELECT 3", Category: "detection"},
+		{Name: "P", Query: "SELECT 4", Category: "policy", Tags: []string{"process"}},
+	}
+}
+
+func TestGroupBy(t *testing.T) {
+	// Files other than the detection files are the same for every key
+	common := map[string]string{
+		"chainguard-all.yml":            "A B C P",
+		"chainguard-detection-5min.yml": "A B C",
+		"chainguard-policy.yml":         "P",
+	}
+	tests := []struct {
+		groupBy string
+		files   map[string]string // detection file -> query names
+	}{
+		{"category", map[string]string{"chainguard-detection.yml": "A B C"}},
+		{"subcategory", map[string]string{
+			"chainguard-detection-subcategory-c2.yml":        "B",
+			"chainguard-detection-subcategory-execution.yml": "A",
+			"chainguard-detection-subcategory-none.yml":      "C",
+		}},
+		{"platform", map[string]string{
+			"chainguard-detection-platform-common.yml": "C",
+			"chainguard-detection-platform-darwin.yml": "A",
+			"chainguard-detection-platform-linux.yml":  "A B",
+		}},
+		{"level", map[string]string{
+			"chainguard-detection-level-1.yml":    "B",
+			"chainguard-detection-level-3.yml":    "A",
+			"chainguard-detection-level-none.yml": "C",
+		}},
+		// A query is written to the file of each of its tags, and only
+		// detections are split: the policy's tag makes no file
+		{"tag", map[string]string{
+			"chainguard-detection-tag-network.yml":  "B",
+			"chainguard-detection-tag-process.yml":  "A B",
+			"chainguard-detection-tag-state.yml":    "A",
+			"chainguard-detection-tag-untagged.yml": "C",
+		}},
+		{"tactic", map[string]string{
+			"chainguard-execution.yml":           "A B",
+			"chainguard-command-and-control.yml": "B",
+			"chainguard-uncategorized.yml":       "C",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.groupBy, func(t *testing.T) {
+			quietTest(t)
+			dir := t.TempDir()
+			if err := writeFleetYAML(groupingQueries(), dir, tt.groupBy); err != nil {
+				t.Fatal(err)
+			}
+
+			want := map[string]string{}
+			for file, names := range common {
+				want[file] = names
+			}
+			for file, names := range tt.files {
+				want[file] = names
+			}
+			files := listFiles(t, dir)
+			if len(files) != len(want) {
+				t.Errorf("files = %q, want %d files", files, len(want))
+			}
+			for _, file := range files {
+				names, ok := want[file]
+				if !ok {
+					t.Errorf("unexpected file %s", file)
+					continue
+				}
+				if got := strings.Join(docNames(t, filepath.Join(dir, file)), " "); got != names {
+					t.Errorf("%s holds %s, want %s", file, got, names)
+				}
+			}
+		})
+	}
+}
+
+func TestGroupByKeys(t *testing.T) {
+	want := "category level platform platform-category subcategory tactic tag"
+	if got := strings.Join(groupByKeys(), " "); got != want {
+		t.Errorf("groupByKeys() = %s, want %s", got, want)
+	}
+}
+
+func TestGroupByPlatformCategory(t *testing.T) {
+	quietTest(t)
+	queries := append(groupingQueries(),
+		Query{Name: "I", Query: "SELECT 5", Category: "incident_response", Platform: "windows"},
+		Query{Name: "W", Query: "SELECT 6", Category: "policy", Platform: "windows,darwin"},
+	)
+	dir := t.TempDir()
+	if err := writePlatformCategoryFiles(queries, dir); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each file has its fixed-interval variant where the category has one
+	want := map[string]string{
+		"chainguard-all.yml":                             "A B C P I W",
+		"chainguard-common-detection.yml":                "C",
+		"chainguard-common-detection-5min.yml":           "C",
+		"chainguard-common-policy.yml":                   "P",
+		"chainguard-darwin-detection.yml":                "A",
+		"chainguard-darwin-detection-5min.yml":           "A",
+		"chainguard-darwin-policy.yml":                   "W",
+		"chainguard-linux-detection.yml":                 "A B",
+		"chainguard-linux-detection-5min.yml":            "A B",
+		"chainguard-windows-incident-response.yml":       "I",
+		"chainguard-windows-incident-response-10min.yml": "I",
+		"chainguard-windows-policy.yml":                  "W",
+	}
+	files := listFiles(t, dir)
+	if len(files) != len(want) {
+		t.Errorf("files = %q, want %d files", files, len(want))
+	}
+	for _, file := range files {
+		names, ok := want[file]
+		if !ok {
+			t.Errorf("unexpected file %s", file)
+			continue
+		}
+		if got := strings.Join(docNames(t, filepath.Join(dir, file)), " "); got != names {
+			t.Errorf("%s holds %s, want %s", file, got, names)
+		}
+	}
+
+	for file, interval := range map[string]int{"chainguard-linux-detection-5min.yml": 300, "chainguard-windows-incident-response-10min.yml": 600} {
+		docs, err := loadCombinedDocs(filepath.Join(dir, file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, d := range docs {
+			var doc emittedDoc
+			if err := yaml.Unmarshal([]byte(d.text), &doc); err != nil {
+				t.Fatal(err)
+			}
+			if got := doc.Spec["interval"]; got != interval {
+				t.Errorf("%s: %s has interval %v, want %d", file, d.name, got, interval)
+			}
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import "strings"
+
+// generatedMarker opens the -header-comment block. An existing combined
+// file's block is recognized by it and replaced rather than kept by -append.
+const generatedMarker = "# Generated by convert from osquery-defense-kit; do not edit."
+
+// headerComment is prepended to every query YAML file, set from
+// -header-comment; "" writes none
+var headerComment string
+
+// buildHeaderComment returns the comment block recording where the output
+// came from. timestamp is empty with -no-timestamp, for byte-stable output.
+func buildHeaderComment(tool, commit, timestamp string) string {
+	lines := []string{generatedMarker, "# Regenerate it from the upstream queries instead.", "# Tool: " + tool}
+	if commit != "" {
+		lines = append(lines, "# Upstream commit: "+commit)
+	}
+	if timestamp != "" {
+		lines = append(lines, "# Generated at: "+timestamp)
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// stripHeaderComment removes a generated comment block from the start of
+// text, leaving any other comments alone
+func stripHeaderComment(text string) string {
+	if !strings.HasPrefix(text, generatedMarker+"\n") {
+		return text
+	}
+	for strings.HasPrefix(text, "#") {
+		_, rest, _ := strings.Cut(text, "\n")
+		text = rest
+	}
+	return text
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestBuildHeaderComment(t *testing.T) {
+	tests := []struct {
+		commit, timestamp string
+		want              []string
+	}{
+		{"0123abc", "2026-01-02T03:04:05Z", []string{"# Tool: v1.2.3", "# Upstream commit: 0123abc", "# Generated at: 2026-01-02T03:04:05Z"}},
+		{"0123abc", "", []string{"# Tool: v1.2.3", "# Upstream commit: 0123abc"}},
+		{"", "", []string{"# Tool: v1.2.3"}},
+	}
+	for _, tt := range tests {
+		header := buildHeaderComment("v1.2.3", tt.commit, tt.timestamp)
+		lines := strings.Split(strings.TrimSuffix(header, "\n"), "\n")
+		if lines[0] != generatedMarker || strings.Join(lines[2:], "\n") != strings.Join(tt.want, "\n") {
+			t.Errorf("commit %q, timestamp %q gave:\n%s", tt.commit, tt.timestamp, header)
+		}
+	}
+}
+
+func TestHeaderCommentDocuments(t *testing.T) {
+	plain, _ := parseTestQuery(t, "detection/execution/2-shell.sql", fixtureQuery)
+	framed, _ := parseTestQuery(t, "detection/execution/3-shell.sql", fixtureFrontMatter)
+	queries := []Query{plain, framed}
+
+	saved := headerComment
+	t.Cleanup(func() { headerComment = saved })
+	headerComment = buildHeaderComment("v1.2.3", "0123abc", "2026-01-02T03:04:05Z")
+	var buf bytes.Buffer
+	io.WriteString(&buf, headerComment)
+	if err := writeQueryDocuments(&buf, queries); err != nil {
+		t.Fatal(err)
+	}
+
+	// The comment adds no document of its own
+	dec := yaml.NewDecoder(bytes.NewReader(buf.Bytes()))
+	docs := 0
+	for {
+		var doc emittedDoc
+		err := dec.Decode(&doc)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("file with header comment is not valid YAML: %v\n%s", err, buf.Bytes())
+		}
+		if doc.Kind != "query" {
+			t.Errorf("document %d has kind %q", docs+1, doc.Kind)
+		}
+		docs++
+	}
+	if docs != len(queries) {
+		t.Errorf("header comment file has %d documents, want %d", docs, len(queries))
+	}
+	if first := splitDocuments(stripHeaderComment(buf.String()))[0]; strings.HasPrefix(first, "#") {
+		t.Errorf("stripping left the header comment in:\n%s", first)
+	}
+}
+
+func TestStripHeaderComment(t *testing.T) {
+	header := buildHeaderComment("v1.2.3", "", "")
+	if got := stripHeaderComment(header + "apiVersion: v1\n"); got != "apiVersion: v1\n" {
+		t.Errorf("generated header stripped to %q", got)
+	}
+	// Comments that are not ours are kept
+	for _, text := range []string{"# Maintained by hand\napiVersion: v1\n", "apiVersion: v1\n" + header} {
+		if got := stripHeaderComment(text); got != text {
+			t.Errorf("%q stripped to %q", text, got)
+		}
+	}
+}
+
+func TestHeaderCommentFlag(t *testing.T) {
+	upstream := writeUpstream(t, map[string]string{"detection/execution/2-shell.sql": fixtureQuery})
+	output := t.TempDir()
+	for range 2 {
+		// -append rewrites the header rather than stacking a second one
+		if err := runConvert(t, "-upstream", upstream, "-output", output, "-header-comment", "-no-timestamp", "-append", "-overwrite"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := os.ReadFile(filepath.Join(output, "chainguard-all.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := string(data)
+	if !strings.HasPrefix(text, generatedMarker+"\n") || strings.Count(text, generatedMarker) != 1 {
+		t.Errorf("chainguard-all.yml does not open with one header comment:\n%s", text)
+	}
+	if strings.Contains(text, "# Generated at:") {
+		t.Errorf("-no-timestamp wrote a timestamp:\n%s", text)
+	}
+
+	err = runConvert(t, "-upstream", upstream, "-output", t.TempDir(), "-no-timestamp")
+	if err == nil || !strings.Contains(err.Error(), "-no-timestamp only applies with -header-comment") {
+		t.Errorf("-no-timestamp alone gave %v", err)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// runPostHook runs the command template once per written file with {}
+// replaced by the file, or, if the template has no {}, once with {dir}
+// replaced by the output directory. The template is split into arguments
+// like a shell would, honoring quotes, but run directly rather than through
+// a shell, so file names can't inject commands. A failing command aborts the
+// run.
+func runPostHook(template, outputDir string, files []string) error {
+	args, err := splitHookArgs(template)
+	if err != nil {
+		return fmt.Errorf("-post-hook: %w", err)
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("-post-hook is empty")
+	}
+
+	if !strings.Contains(template, "{}") {
+		return runHookCommand(expandHookArgs(args, "{dir}", outputDir))
+	}
+	for _, file := range files {
+		if err := runHookCommand(expandHookArgs(args, "{}", file)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitHookArgs splits a template on whitespace outside quotes. Single
+// quotes keep their contents as is; inside double quotes, and outside any
+// quotes, a backslash escapes the next character.
+func splitHookArgs(template string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	inArg := false
+	var quote rune
+	escaped := false
+	for _, r := range template {
+		switch {
+		case escaped:
+			arg.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				arg.WriteRune(r)
+			}
+		case r == '\\':
+			escaped, inArg = true, true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				arg.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote, inArg = r, true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+	switch {
+	case quote != 0:
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	case escaped:
+		return nil, fmt.Errorf("trailing backslash")
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
+
+func expandHookArgs(args []string, placeholder, value string) []string {
+	expanded := make([]string, len(args))
+	for i, arg := range args {
+		expanded[i] = strings.ReplaceAll(arg, placeholder, value)
+	}
+	return expanded
+}
+
+func runHookCommand(argv []string) error {
+	debugf("Running %s\n", strings.Join(argv, " "))
+	out, err := exec.Command(argv[0], argv[1:]...).CombinedOutput()
+	if err != nil {
+		if output := strings.TrimRight(string(out), "\n"); output != "" {
+			return fmt.Errorf("post-hook %s: %w\n%s", strings.Join(argv, " "), err, output)
+		}
+		return fmt.Errorf("post-hook %s: %w", strings.Join(argv, " "), err)
+	}
+	if len(out) > 0 {
+		infof("%s", out)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitHookArgs(t *testing.T) {
+	tests := []struct {
+		template string
+		want     []string
+		err      string
+	}{
+		{"fleetctl apply --dry-run -f {}", []string{"fleetctl", "apply", "--dry-run", "-f", "{}"}, ""},
+		{"  yamllint\t{}  ", []string{"yamllint", "{}"}, ""},
+		{`yamllint -d '{extends: relaxed}' {}`, []string{"yamllint", "-d", "{extends: relaxed}", "{}"}, ""},
+		{`notify "queries written" {dir}`, []string{"notify", "queries written", "{dir}"}, ""},
+		{`tag --label=prod" "fleet {}`, []string{"tag", "--label=prod fleet", "{}"}, ""},
+		{`echo "say \"hi\"" 'it''s' a\ b`, []string{"echo", `say "hi"`, "its", "a b"}, ""},
+		{`echo '\n' "" x`, []string{"echo", `\n`, "", "x"}, ""},
+		{"", nil, ""},
+		{`echo 'open`, nil, "unterminated ' quote"},
+		{`echo "open`, nil, `unterminated " quote`},
+		{`echo \`, nil, "trailing backslash"},
+	}
+	for _, tt := range tests {
+		got, err := splitHookArgs(tt.template)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("splitHookArgs(%q) error = %v, want %q", tt.template, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitHookArgs(%q) = %q, %v; want %q", tt.template, got, err, tt.want)
+		}
+	}
+}
+
+func TestPostHookQuotedArgument(t *testing.T) {
+	quietTest(t)
+	dir := t.TempDir()
+	if err := runPostHook("touch '{dir}/a file' {dir}/plain", dir, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(listFiles(t, dir), ","); got != "a file,plain" {
+		t.Errorf("hook created %s, want the quoted argument kept whole", got)
+	}
+}
+
+func TestPostHookFailure(t *testing.T) {
+	quietTest(t)
+	dir := t.TempDir()
+	files := []string{filepath.Join(dir, "a.yml"), filepath.Join(dir, "b.yml")}
+	log := filepath.Join(dir, "ran")
+
+	// The first failure aborts the run with the exit status and output
+	err := runPostHook(`sh -c 'echo "$1" >> "$0"; echo "rejected $1" >&2; exit 3' `+log+" {}", dir, files)
+	if err == nil {
+		t.Fatal("failing hook did not fail the run")
+	}
+	for _, part := range []string{"post-hook sh -c", "exit status 3", "rejected " + files[0]} {
+		if !strings.Contains(err.Error(), part) {
+			t.Errorf("error %q does not mention %q", err, part)
+		}
+	}
+	if data, _ := os.ReadFile(log); string(data) != files[0]+"\n" {
+		t.Errorf("hook ran on %q, want only the first file", data)
+	}
+
+	if err := runPostHook(`echo 'open`, dir, files); err == nil || err.Error() != "-post-hook: unterminated ' quote" {
+		t.Errorf("unterminated quote: %v", err)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var includeRegex = regexp.MustCompile(`^\s*--\s*include:\s*(\S+)\s*$`)
+
+// maxIncludeDepth bounds nested includes independently of cycle detection
+const maxIncludeDepth = 8
+
+// expandInclude returns the lines of snippet name from dir with nested
+// includes expanded. src is the including file, for warnings; stack holds
+// the snippets currently being expanded. A missing snippet only warns and
+// leaves the directive in place, since the query may still be usable.
+func expandInclude(src source, dir, name string, stack []string) ([]string, error) {
+	if !filepath.IsLocal(name) {
+		return nil, fmt.Errorf("include %s escapes the includes directory", name)
+	}
+	if containsString(stack, name) {
+		return nil, fmt.Errorf("include cycle: %s -> %s", strings.Join(stack, " -> "), name)
+	}
+	if len(stack) >= maxIncludeDepth {
+		return nil, fmt.Errorf("include %s exceeds max depth %d", name, maxIncludeDepth)
+	}
+
+	file, err := os.Open(filepath.Join(dir, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		src.warnf("include %s not found in %s\n", name, dir)
+		return []string{"-- include: " + name}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	stack = append(stack, name)
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if matches := includeRegex.FindStringSubmatch(line); matches != nil {
+			nested, err := expandInclude(source{path: name, warn: src.warn}, dir, matches[1], stack)
+			if err != nil {
+				return nil, err
+			}
+			lines = append(lines, nested...)
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	// Drop trailing blank lines so snippets splice cleanly mid-query
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines, scanner.Err()
+}
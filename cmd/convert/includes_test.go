@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestNestedInclude(t *testing.T) {
+	includes := writeUpstream(t, map[string]string{
+		"columns.sql":       "  pid,\n  name\n\n",
+		"filters/shell.sql": "WHERE\n-- include: filters/name.sql\n",
+		"filters/name.sql":  "  name IN ('bash', 'zsh')\n",
+	})
+	q, warnings := parseTestQueryOpts(t, "detection/execution/2-shell.sql", `-- Unexpected shell
+-- platform: linux
+SELECT
+-- include: columns.sql
+FROM processes
+-- include: filters/shell.sql
+;`, parseOptions{IncludeDir: includes})
+	if len(warnings) > 0 {
+		t.Fatalf("unexpected warnings: %v", warnings)
+	}
+	// Nested names resolve from the includes directory, not the snippet's
+	want := "SELECT\n  pid,\n  name\nFROM processes\nWHERE\n  name IN ('bash', 'zsh')"
+	if q.Query != want {
+		t.Errorf("expanded query = %q, want %q", q.Query, want)
+	}
+}
+
+func TestIncludeDepth(t *testing.T) {
+	// chain[i] includes chain[i+1]; the last holds the SQL
+	chain := map[string]string{}
+	for i := 0; i <= maxIncludeDepth; i++ {
+		chain[fmt.Sprintf("d%d", i)] = fmt.Sprintf("-- include: d%d\n", i+1)
+	}
+	chain[fmt.Sprintf("d%d", maxIncludeDepth)] = "SELECT 1"
+	includes := writeUpstream(t, chain)
+
+	var warnings []string
+	lines, err := expandInclude(testSource(&warnings), includes, "d1", nil)
+	if err != nil || strings.Join(lines, "\n") != "SELECT 1" {
+		t.Errorf("%d levels of includes = %q, %v; want them expanded", maxIncludeDepth, lines, err)
+	}
+
+	_, err = expandInclude(testSource(&warnings), includes, "d0", nil)
+	if want := fmt.Sprintf("include d%d exceeds max depth %d", maxIncludeDepth, maxIncludeDepth); err == nil || err.Error() != want {
+		t.Errorf("%d levels of includes: error %v, want %q", maxIncludeDepth+1, err, want)
+	}
+	if len(warnings) > 0 {
+		t.Errorf("unexpected warnings: %v", warnings)
+	}
+}
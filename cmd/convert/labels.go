@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// writeLabelYAML writes a query marked -- as: label as a Fleet dynamic
+// label, whose members are the hosts on which the SQL returns any rows
+func writeLabelYAML(w io.Writer, q Query) error {
+	fmt.Fprintf(w, "apiVersion: %s\n", apiVersion)
+	io.WriteString(w, "kind: label\n")
+	writeAnnotations(w, queryAnnotations(q))
+	io.WriteString(w, "spec:\n")
+	fmt.Fprintf(w, "  name: %s\n", escapeYAML(q.Name))
+	fmt.Fprintf(w, "  description: %s\n", escapeYAML(q.Description))
+
+	writeBlockScalar(w, "  query", "    ", q.Query)
+
+	// A label targets one platform or all of them
+	if platform := q.Platform; platform != "" && !strings.Contains(platform, ",") {
+		fmt.Fprintf(w, "  platform: %s\n", platform)
+	}
+	io.WriteString(w, "  label_membership_type: dynamic\n")
+	return nil
+}
+
+// queriesOnly drops queries marked -- as: label or -- as: policy, for
+// outputs that have no label or policy documents, and reports how many were
+// dropped
+func queriesOnly(queries []Query, format string) []Query {
+	var kept []Query
+	for _, q := range queries {
+		if q.As != "label" && q.As != "policy" {
+			kept = append(kept, q)
+		}
+	}
+	if dropped := len(queries) - len(kept); dropped > 0 {
+		infof("Skipping %d label and policy queries, which -format %s has no place for\n", dropped, format)
+	}
+	return kept
+}
+
+// matchesEveryHost reports whether a label query returns a row on every
+// host, making every host a member: it reads no table, or aggregates to a
+// single row
+func matchesEveryHost(q Query) bool {
+	return len(referencedTables(q.Query)) == 0 || singleRow(tokenizeSQL(q.Query))
+}
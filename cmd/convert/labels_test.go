@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestLabelDocument(t *testing.T) {
+	q, warnings := parseTestQuery(t, "incident_response/affected-hosts.sql", "-- as: label\n"+fixtureQuery)
+	if len(warnings) > 0 {
+		t.Errorf("unexpected warnings: %q", warnings)
+	}
+	doc, text := emitTestQuery(t, q)
+	if doc.Kind != "label" || doc.APIVersion != "v1" {
+		t.Fatalf("emitted %s %s document:\n%s", doc.APIVersion, doc.Kind, text)
+	}
+	if doc.Spec["label_membership_type"] != "dynamic" || strings.TrimSpace(doc.Spec["query"].(string)) != strings.TrimSpace(q.Query) {
+		t.Errorf("not a dynamic label with the query as membership SQL:\n%s", text)
+	}
+	// Query-only fields have no place in a label
+	var keys []string
+	for key := range doc.Spec {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if got := strings.Join(keys, " "); got != "description label_membership_type name query" {
+		t.Errorf("spec keys = %s in:\n%s", got, text)
+	}
+
+	// A label targets one platform or all of them
+	q.Platform = "linux"
+	if doc, text := emitTestQuery(t, q); doc.Spec["platform"] != "linux" {
+		t.Errorf("single platform not kept:\n%s", text)
+	}
+}
+
+func TestMatchesEveryHost(t *testing.T) {
+	tests := []struct {
+		query string
+		want  bool
+	}{
+		{"SELECT 1", true},
+		{"SELECT count(*) FROM processes", true},
+		{"SELECT 1 FROM processes WHERE name = 'sshd'", false},
+		{"SELECT name, count(*) FROM processes GROUP BY name", false},
+		{"SELECT 1 FROM users WHERE uid IN (SELECT max(uid) FROM users)", false},
+		{"SELECT count(*) FROM users UNION SELECT count(*) FROM groups", true},
+		{"SELECT count(*) FROM users UNION SELECT 1 FROM processes WHERE name = 'sshd'", false},
+	}
+	for _, tt := range tests {
+		if got := matchesEveryHost(Query{Query: tt.query}); got != tt.want {
+			t.Errorf("matchesEveryHost(%q) = %t, want %t", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestLabelsOnlyInYAML(t *testing.T) {
+	upstream := writeUpstream(t, map[string]string{
+		"incident_response/affected-hosts.sql": "-- Hosts running the implant\n-- as: label\nSELECT 1 FROM processes WHERE name = 'implant';\n",
+		"incident_response/users.sql":          "-- Local users\nSELECT * FROM users;\n",
+	})
+	output := t.TempDir()
+	if err := runConvert(t, "-upstream", upstream, "-output", output, "-format", "yaml,osquery-pack"); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(docNames(t, filepath.Join(output, "chainguard-incident-response.yml")), ","); got != "[incident_response] Affected Hosts,[incident_response] Users" {
+		t.Errorf("YAML holds %s", got)
+	}
+	pack, err := os.ReadFile(filepath.Join(output, "chainguard-pack.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(pack), "implant") || !strings.Contains(string(pack), "FROM users") {
+		t.Errorf("pack should hold only the query:\n%s", pack)
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// volatileColumns change between runs without the underlying state changing,
+// so a differential query selecting them reports every row on every run.
+// Event tables' "time" is fixed per event row, so it is not listed.
+var volatileColumns = map[string]bool{
+	"uptime":             true,
+	"total_seconds":      true,
+	"unix_time":          true,
+	"timestamp":          true,
+	"datetime":           true,
+	"user_time":          true,
+	"system_time":        true,
+	"resident_size":      true,
+	"total_size":         true,
+	"disk_bytes_read":    true,
+	"disk_bytes_written": true,
+}
+
+// volatileFunctions produce a different value on each evaluation
+var volatileFunctions = map[string]bool{
+	"random":     true,
+	"randomblob": true,
+}
+
+// severityLevels maps -- severity: values to filename level prefixes
+var severityLevels = map[string]int{
+	"low":    1,
+	"medium": 2,
+	"high":   3,
+}
+
+// privilegedTables return nothing, or only the current user's rows, unless
+// osquery runs as root: they read protected files, other users' processes,
+// or kernel event sources.
+var privilegedTables = map[string]bool{
+	"shadow":                 true,
+	"authorized_keys":        true,
+	"user_ssh_keys":          true,
+	"process_memory_map":     true,
+	"process_open_files":     true,
+	"process_open_sockets":   true,
+	"process_events":         true,
+	"socket_events":          true,
+	"user_events":            true,
+	"bpf_process_events":     true,
+	"bpf_socket_events":      true,
+	"es_process_events":      true,
+	"es_process_file_events": true,
+}
+
+// eventFlags are the osqueryd flags event tables need beyond
+// --disable_events=false, by table-name prefix
+var eventFlags = map[string]string{
+	"bpf_": "--enable_bpf_events=true",
+	"es_":  "--disable_endpointsecurity=false",
+}
+
+// lintOptions holds the thresholds for lints that take one. A zero value
+// disables the corresponding check.
+type lintOptions struct {
+	MaxBytes int
+	MaxLines int
+
+	// Opt-in lints, enabled with -lint
+	RemovedTables map[string]string // table -> osquery version that removed it
+	StaleAfter    time.Duration     // age of -- updated: (or the last commit, or -- created:) reported as stale
+	Privileged    bool              // report privileged tables read without -- requires_sudo: true
+	Strict        bool              // fail the run on undeclared privileged tables (-strict)
+	Similarity    float64           // report query pairs at least this similar; 0 skips the check
+	CostFloors    bool              // report expensive queries scheduled more often than their cost class allows
+	EventFlags    bool              // report event tables read without the osqueryd flags they need
+	MinPathDepth  int               // report file and hash path patterns anchored fewer directories deep
+
+	// Set by -osquery-version
+	OsqueryVersion string            // oldest osquery release the catalog must run on
+	TableVersions  map[string]string // table or table.column -> osquery version that added it
+}
+
+// staleAfter is the StaleAfter used by -lint
+const staleAfter = 365 * 24 * time.Hour
+
+// lintQueries prints warnings for queries that convert fine but are likely
+// to misbehave once scheduled. Only -strict checks make it return an error.
+func lintQueries(queries []Query, opts lintOptions) error {
+	undeclared := 0
+	for _, q := range queries {
+		size, lines := len(q.Query), strings.Count(q.Query, "\n")+1
+		if (opts.MaxBytes > 0 && size > opts.MaxBytes) || (opts.MaxLines > 0 && lines > opts.MaxLines) {
+			warnf("%s: query is %d bytes over %d lines; consider splitting it into smaller queries\n", q.Name, size, lines)
+		}
+		if level, ok := severityLevels[q.Severity]; ok && q.Level > 0 && level != q.Level {
+			warnf("%s: filename level %d does not match severity %q (level %d)\n", q.Name, q.Level, q.Severity, level)
+		}
+		if q.As == "label" && matchesEveryHost(q) {
+			warnf("%s: label query returns a row on every host, so every host would be a member; filter it with FROM and WHERE\n", q.Name)
+		}
+		for _, p := range checkBalance(q.Query) {
+			warnf("%s: %s at offset %d (line %d of query)\n", q.Name, p.message, p.offset, lineOf(q.Query, p.offset))
+		}
+		for _, table := range referencedTables(q.Query) {
+			if version, ok := opts.RemovedTables[table]; ok {
+				warnf("%s: reads table %s, which was removed in osquery %s\n", q.Name, table, version)
+			}
+		}
+		if opts.OsqueryVersion != "" {
+			for _, key := range unavailableIn(q, opts.TableVersions, opts.OsqueryVersion) {
+				warnf("%s: reads %s, which osquery %s does not have (added in %s)\n", q.Name, key, opts.OsqueryVersion, opts.TableVersions[key])
+			}
+		}
+		if !q.Created.IsZero() && !q.Updated.IsZero() && q.Updated.Before(q.Created) {
+			warnf("%s: updated %s is before created %s\n", q.Name, q.Updated.Format(time.DateOnly), q.Created.Format(time.DateOnly))
+		}
+		if changed := lastChanged(q); opts.StaleAfter > 0 && !changed.IsZero() && time.Since(changed) > opts.StaleAfter {
+			warnf("%s: not updated since %s\n", q.Name, changed.Format(time.DateOnly))
+		}
+		if tables := privilegedSelections(q); opts.Privileged && len(tables) > 0 {
+			switch {
+			case q.RequiresSudo == nil:
+				warnf("%s: reads %s, which needs osquery running as root; declare -- requires_sudo: true\n", q.Name, strings.Join(tables, ", "))
+				undeclared++
+			case !*q.RequiresSudo:
+				warnf("%s: declares requires_sudo: false but reads %s, which needs osquery running as root\n", q.Name, strings.Join(tables, ", "))
+				undeclared++
+			}
+		}
+		if class, floor := costClass(q); opts.CostFloors && q.Interval > 0 && q.Interval < floor {
+			warnf("%s: %s query runs every %ds; schedule it no more often than every %ds\n", q.Name, class, q.Interval, floor)
+		}
+		if missing := missingEventFlags(q); opts.EventFlags && len(missing) > 0 {
+			warnf("%s: reads event tables, which need osqueryd started with %s; declare them with -- osquery_flags:\n", q.Name, strings.Join(missing, " "))
+		}
+		for _, constraint := range broadPathConstraints(q, opts.MinPathDepth) {
+			warnf("%s: %s is too broad a filesystem scan; -min-path-depth wants %d directories before the first wildcard\n", q.Name, constraint, opts.MinPathDepth)
+		}
+		for _, column := range volatileSelections(q) {
+			warnf("%s: selects volatile %s with differential logging; results will churn every run, consider snapshot logging\n", q.Name, column)
+		}
+	}
+
+	if opts.Similarity > 0 {
+		warnSimilarQueries(queries, opts.Similarity)
+	}
+
+	if opts.Strict && undeclared > 0 {
+		return fmt.Errorf("%d queries read privileged tables without declaring -- requires_sudo: true", undeclared)
+	}
+	return nil
+}
+
+// privilegedSelections returns the privileged tables q reads
+func privilegedSelections(q Query) []string {
+	var found []string
+	for _, table := range referencedTables(q.Query) {
+		if privilegedTables[table] {
+			found = append(found, table)
+		}
+	}
+	return found
+}
+
+// missingEventFlags returns the flags q's event tables need that its
+// -- osquery_flags: header does not declare. A bare boolean flag counts as
+// =true.
+func missingEventFlags(q Query) []string {
+	declared := map[string]bool{}
+	for _, f := range q.OsqueryFlags {
+		if !strings.Contains(f, "=") {
+			f += "=true"
+		}
+		declared[strings.ToLower(f)] = true
+	}
+
+	var missing []string
+	for _, table := range referencedTables(q.Query) {
+		if !strings.HasSuffix(table, "_events") {
+			continue
+		}
+		needed := []string{"--disable_events=false"}
+		for prefix, flag := range eventFlags {
+			if strings.HasPrefix(table, prefix) {
+				needed = append(needed, flag)
+			}
+		}
+		for _, flag := range needed {
+			if !declared[flag] && !containsString(missing, flag) {
+				missing = append(missing, flag)
+			}
+		}
+	}
+	return missing
+}
+
+// pathScanTables walk the filesystem for the paths their path and directory
+// constraints match
+var pathScanTables = []string{"file", "hash"}
+
+// broadPathConstraints returns the path and directory patterns of q's file
+// or hash table that fix fewer than minDepth directories before the first
+// wildcard, such as path LIKE '/%'. A minDepth of 0 reports nothing.
+func broadPathConstraints(q Query, minDepth int) []string {
+	if minDepth <= 0 {
+		return nil
+	}
+	scans := false
+	for _, table := range referencedTables(q.Query) {
+		scans = scans || containsString(pathScanTables, table)
+	}
+	if !scans {
+		return nil
+	}
+
+	var broad []string
+	tokens := tokenizeSQL(q.Query)
+	for i := 0; i+2 < len(tokens); i++ {
+		column, op, pattern := tokens[i], tokens[i+1], tokens[i+2]
+		if !column.is("path") && !column.is("directory") || pattern.kind != tokString {
+			continue
+		}
+		var wildcards string
+		switch {
+		case op.is("LIKE"):
+			wildcards = "%"
+		case op.is("GLOB"):
+			wildcards = "*?["
+		default:
+			continue
+		}
+		if depth, ok := fixedDepth(pattern.text, wildcards); ok && depth < minDepth {
+			broad = append(broad, fmt.Sprintf("%s %s '%s'", strings.ToLower(column.text), strings.ToUpper(op.text), pattern.text))
+		}
+	}
+	return broad
+}
+
+// fixedDepth counts the directories of pattern before the first one holding
+// a wildcard. A drive letter such as C: is not counted. ok is false when the
+// pattern has no wildcard and so names a single path.
+func fixedDepth(pattern, wildcards string) (depth int, ok bool) {
+	if !strings.ContainsAny(pattern, wildcards) {
+		return 0, false
+	}
+	parts := strings.Split(strings.ReplaceAll(pattern, `\`, "/"), "/")
+	for i, part := range parts {
+		switch {
+		case part == "" || (i == 0 && len(part) == 2 && part[1] == ':'):
+			continue
+		case strings.ContainsAny(part, wildcards):
+			return depth, true
+		}
+		depth++
+	}
+	return depth, true
+}
+
+// lastChanged returns the -- updated: date, or without one the last commit
+// date from -git-dates, or else the -- created: date
+func lastChanged(q Query) time.Time {
+	switch {
+	case !q.Updated.IsZero():
+		return q.Updated
+	case !q.Committed.IsZero():
+		return q.Committed
+	}
+	return q.Created
+}
+
+// volatileSelections returns the volatile columns and functions a
+// differential query selects
+func volatileSelections(q Query) []string {
+	if loggingFor(q) != "differential" {
+		return nil
+	}
+
+	var found []string
+	for _, expr := range selectList(tokenizeSQL(q.Query)) {
+		for i, t := range expr {
+			isCall := i+1 < len(expr) && expr[i+1].text == "("
+			name := strings.ToLower(t.text)
+			switch {
+			case t.kind == tokIdent && isCall && volatileFunctions[name]:
+				found = append(found, fmt.Sprintf("function %s()", name))
+			case t.kind == tokIdent && !isCall && volatileColumns[name]:
+				found = append(found, fmt.Sprintf("column %q", name))
+			case t.kind == tokString && strings.EqualFold(t.text, "now"):
+				found = append(found, "'now' timestamp")
+			}
+		}
+	}
+	return normalizeList(found)
+}
+
+// similarityTokens returns the set of normalized tokens of a query, with
+// string and number literals folded to placeholders so queries differing
+// only in a hardcoded value compare as equal
+func similarityTokens(query string) map[string]bool {
+	set := map[string]bool{}
+	for _, t := range tokenizeSQL(query) {
+		switch t.kind {
+		case tokString:
+			set["'?'"] = true
+		case tokNumber:
+			set["0"] = true
+		default:
+			set[strings.ToLower(t.text)] = true
+		}
+	}
+	return set
+}
+
+// jaccard returns the Jaccard similarity of two token sets
+func jaccard(a, b map[string]bool) float64 {
+	if len(a) == 0 && len(b) == 0 {
+		return 0
+	}
+	shared := 0
+	for t := range a {
+		if b[t] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(a)+len(b)-shared)
+}
+
+// minSimilarityTokens keeps trivial queries such as "SELECT 1" out of the
+// similarity check, where any two of them would match
+const minSimilarityTokens = 6
+
+// warnSimilarQueries reports pairs of queries whose token sets are at least
+// threshold similar, as candidates for one parameterized query
+func warnSimilarQueries(queries []Query, threshold float64) {
+	sets := make([]map[string]bool, len(queries))
+	for i, q := range queries {
+		sets[i] = similarityTokens(q.Query)
+	}
+	for i := range queries {
+		for j := i + 1; j < len(queries); j++ {
+			if len(sets[i]) < minSimilarityTokens || len(sets[j]) < minSimilarityTokens {
+				continue
+			}
+			if score := jaccard(sets[i], sets[j]); score >= threshold {
+				warnf("%s and %s are %.0f%% similar; consider merging or parameterizing them\n", queries[i].Name, queries[j].Name, 100*score)
+			}
+		}
+	}
+}
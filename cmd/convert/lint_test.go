@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLintOversizedQuery(t *testing.T) {
+	var b strings.Builder
+	b.WriteString("SELECT pid FROM processes WHERE name IN (\n")
+	for i := 0; i < 3000; i++ {
+		b.WriteString("  'generated-process-name',\n")
+	}
+	b.WriteString("  'last'\n)")
+	oversized := Query{Name: "[detection/execution] Generated", Query: b.String(), Category: "detection", Logging: "snapshot"}
+	small := Query{Name: "[detection/execution] Small", Query: "SELECT pid FROM processes", Category: "detection", Logging: "snapshot"}
+	size, lines := len(oversized.Query), strings.Count(oversized.Query, "\n")+1
+
+	tests := []struct {
+		name string
+		opts lintOptions
+		want int
+	}{
+		{"defaults", lintOptions{MaxBytes: 65536, MaxLines: 1000}, 1},
+		{"byte limit only", lintOptions{MaxBytes: size - 1}, 1},
+		{"line limit only", lintOptions{MaxLines: lines - 1}, 1},
+		{"at the limits", lintOptions{MaxBytes: size, MaxLines: lines}, 0},
+		{"disabled", lintOptions{}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			warnings := captureWarnings(t, func() {
+				if err := lintQueries([]Query{small, oversized}, tt.opts); err != nil {
+					t.Fatal(err)
+				}
+			})
+			if len(warnings) != tt.want {
+				t.Fatalf("warnings = %q, want %d", warnings, tt.want)
+			}
+			if tt.want == 0 {
+				return
+			}
+			// The warning names the query and its size
+			for _, part := range []string{oversized.Name, fmt.Sprintf("%d bytes", size), fmt.Sprintf("%d lines", lines), "splitting"} {
+				if !strings.Contains(warnings[0], part) {
+					t.Errorf("warning %q does not mention %q", warnings[0], part)
+				}
+			}
+		})
+	}
+}
+
+func TestLintSeverityLevel(t *testing.T) {
+	tests := []struct {
+		name, path, severity string
+		want                 []string
+	}{
+		{"matching", "detection/execution/3-shell.sql", "high", nil},
+		{"matching, any case", "detection/execution/1-shell.sql", "Low", nil},
+		{"conflicting", "detection/execution/1-shell.sql", "high", []string{
+			`Warning: [detection/execution] Shell: filename level 1 does not match severity "high" (level 3)`,
+		}},
+		// Without a level prefix there is nothing to compare
+		{"no level", "detection/execution/shell.sql", "medium", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, warnings := parseTestQuery(t, tt.path, "-- severity: "+tt.severity+"\n"+fixtureQuery)
+			if len(warnings) > 0 {
+				t.Fatalf("parse warnings: %v", warnings)
+			}
+			lint := captureWarnings(t, func() {
+				if err := lintQueries([]Query{q}, lintOptions{}); err != nil {
+					t.Fatal(err)
+				}
+			})
+			if !reflect.DeepEqual(lint, tt.want) {
+				t.Errorf("warnings = %q, want %q", lint, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strings"
+)
+
+// lockFilename is written to the output directory by -lock
+const lockFilename = "defensekit.lock"
+
+// lockFile records what a conversion was made from, so that an audit can
+// show the exact inputs behind a deployed catalog
+type lockFile struct {
+	Tool           string            `json:"tool"`
+	UpstreamCommit string            `json:"upstream_commit,omitempty"`
+	Flags          []string          `json:"flags"`
+	InputsSHA256   string            `json:"inputs_sha256"`
+	Inputs         map[string]string `json:"inputs"`
+}
+
+// lockFlags are left out of the recorded flags, since they control the
+// lock itself
+var lockFlags = []string{"lock", "verify-lock"}
+
+// setFlags returns the flags given on the command line as -name=value, in
+// name order. Call it before run rewrites any flag, such as -upstream for
+// an archive.
+func setFlags() []string {
+	var flags []string
+	flag.Visit(func(f *flag.Flag) {
+		if !containsString(lockFlags, f.Name) {
+			flags = append(flags, fmt.Sprintf("-%s=%s", f.Name, f.Value))
+		}
+	})
+	return flags
+}
+
+// buildLock hashes every query file under upstreamDir and every snippet in
+// includeDir, keyed by slash-separated path relative to upstreamDir. Only the
+// directories of categories are walked.
+func buildLock(upstreamDir, includeDir string, categories, flags []string) (lockFile, error) {
+	lock := lockFile{Tool: toolVersion(), UpstreamCommit: upstreamCommit(upstreamDir), Flags: flags, Inputs: map[string]string{}}
+
+	roots := []string{includeDir}
+	for _, category := range categories {
+		roots = append(roots, filepath.Join(upstreamDir, category))
+	}
+	for _, root := range roots {
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if os.IsNotExist(err) && path == root {
+				return filepath.SkipDir
+			}
+			if err != nil || d.IsDir() || (root != includeDir && !strings.HasSuffix(path, ".sql")) {
+				return err
+			}
+			sum, err := hashFile(path)
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(upstreamDir, path)
+			if err != nil {
+				rel = path
+			}
+			lock.Inputs[filepath.ToSlash(rel)] = sum
+			return nil
+		})
+		if err != nil {
+			return lock, err
+		}
+	}
+
+	// The combined hash covers the sorted path and hash of every input
+	paths := make([]string, 0, len(lock.Inputs))
+	for path := range lock.Inputs {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	h := sha256.New()
+	for _, path := range paths {
+		fmt.Fprintf(h, "%s %s\n", lock.Inputs[path], path)
+	}
+	lock.InputsSHA256 = hex.EncodeToString(h.Sum(nil))
+	return lock, nil
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// toolVersion describes the running binary from its build information
+func toolVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	version := info.Main.Path + "@" + info.Main.Version
+	for _, s := range info.Settings {
+		if s.Key == "vcs.revision" {
+			version += " (" + s.Value + ")"
+		}
+	}
+	return version
+}
+
+// upstreamCommit returns the checked-out commit of upstreamDir, or "" when
+// it is not a git checkout
+func upstreamCommit(upstreamDir string) string {
+	out, err := exec.Command("git", "-C", upstreamDir, "rev-parse", "HEAD").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// writeLock writes lock as defensekit.lock in outputDir
+func writeLock(lock lockFile, outputDir string) error {
+	return writeFileAtomic(filepath.Join(outputDir, lockFilename), func(w io.Writer) error {
+		return writeJSON(w, lock)
+	})
+}
+
+func readLock(r io.Reader) (lockFile, error) {
+	var lock lockFile
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&lock); err != nil {
+		return lock, err
+	}
+	return lock, nil
+}
+
+// verifyLock compares the current inputs with the lock in outputDir. Added,
+// removed, or changed inputs are listed and fail the check; a different
+// tool, commit, or set of flags is only reported.
+func verifyLock(current lockFile, outputDir string) error {
+	f, err := os.Open(filepath.Join(outputDir, lockFilename))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	locked, err := readLock(f)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", lockFilename, err)
+	}
+
+	if locked.Tool != current.Tool {
+		warnf("lock was written by %s, this is %s\n", locked.Tool, current.Tool)
+	}
+	if locked.UpstreamCommit != current.UpstreamCommit {
+		warnf("lock was written from upstream commit %q, this is %q\n", locked.UpstreamCommit, current.UpstreamCommit)
+	}
+	if strings.Join(locked.Flags, " ") != strings.Join(current.Flags, " ") {
+		warnf("lock was written with flags %q, this run has %q\n", strings.Join(locked.Flags, " "), strings.Join(current.Flags, " "))
+	}
+	if locked.InputsSHA256 == current.InputsSHA256 {
+		return nil
+	}
+
+	var drift []string
+	for path, sum := range current.Inputs {
+		switch lockedSum, ok := locked.Inputs[path]; {
+		case !ok:
+			drift = append(drift, "added "+path)
+		case lockedSum != sum:
+			drift = append(drift, "changed "+path)
+		}
+	}
+	for path := range locked.Inputs {
+		if _, ok := current.Inputs[path]; !ok {
+			drift = append(drift, "removed "+path)
+		}
+	}
+	sort.Strings(drift)
+	for _, d := range drift {
+		warnf("input %s since the lock was written\n", d)
+	}
+	return fmt.Errorf("%d input files differ from %s", len(drift), lockFilename)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestLockRoundTrip(t *testing.T) {
+	lock := lockFile{
+		Tool:           toolVersion(),
+		UpstreamCommit: "0123456789abcdef0123456789abcdef01234567",
+		Flags:          []string{"-upstream=upstream"},
+		InputsSHA256:   "00",
+		Inputs:         map[string]string{"detection/c2/1-dns-tunnel.sql": "00", "_includes/processes.sql": "11"},
+	}
+	var buf bytes.Buffer
+	if err := writeJSON(&buf, lock); err != nil {
+		t.Fatal(err)
+	}
+	again, err := readLock(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(lock, again) {
+		t.Errorf("read back %+v, wrote %+v", again, lock)
+	}
+
+	// A lock from a newer format is not half-read
+	if _, err := readLock(strings.NewReader(`{"tool": "x", "inputs": {}, "signature": "?"}`)); err == nil {
+		t.Errorf("unknown field accepted")
+	}
+}
+
+func TestBuildLock(t *testing.T) {
+	upstream := writeUpstream(t, map[string]string{
+		"detection/execution/2-shell.sql": fixtureQuery,
+		"policy/ssh-root.sql":             "-- Root SSH\nSELECT 1;\n",
+		"_includes/processes":             "SELECT pid FROM processes",
+		"detection/README.md":             "not an input",
+	})
+	lock, err := buildLock(upstream, filepath.Join(upstream, "_includes"), defaultCategories, []string{"-team=Servers"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for path := range lock.Inputs {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	if got := strings.Join(paths, " "); got != "_includes/processes detection/execution/2-shell.sql policy/ssh-root.sql" {
+		t.Errorf("inputs = %s", got)
+	}
+	if lock.UpstreamCommit != "" || len(lock.InputsSHA256) != 64 {
+		t.Errorf("commit %q, combined hash %q", lock.UpstreamCommit, lock.InputsSHA256)
+	}
+
+	// The combined hash only depends on the inputs
+	again, err := buildLock(upstream, filepath.Join(upstream, "_includes"), defaultCategories, nil)
+	if err != nil || again.InputsSHA256 != lock.InputsSHA256 {
+		t.Errorf("rebuilt hash %q, was %q (%v)", again.InputsSHA256, lock.InputsSHA256, err)
+	}
+}
+
+func TestVerifyLock(t *testing.T) {
+	upstream := writeUpstream(t, map[string]string{
+		"detection/execution/2-shell.sql": fixtureQuery,
+		"policy/ssh-root.sql":             "-- Root SSH\nSELECT 1;\n",
+	})
+	output := t.TempDir()
+	if err := runConvert(t, "-upstream", upstream, "-output", output, "-lock"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(output, lockFilename)); err != nil {
+		t.Fatal(err)
+	}
+	// Other flags are reported but do not fail the check
+	if err := runConvert(t, "-upstream", upstream, "-output", output, "-verify-lock", "-team", "Servers"); err != nil {
+		t.Errorf("unchanged inputs: %v", err)
+	}
+
+	// One input changed, one added, and one removed
+	edits := map[string]string{
+		"policy/ssh-root.sql":    "-- Root SSH\nSELECT 2;\n",
+		"detection/c2/1-dns.sql": "-- DNS\nSELECT 3;\n",
+	}
+	for name, content := range edits {
+		path := filepath.Join(upstream, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Remove(filepath.Join(upstream, "detection", "execution", "2-shell.sql")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := runConvert(t, "-upstream", upstream, "-output", output, "-verify-lock"); err == nil || !strings.Contains(err.Error(), "3 input files differ") {
+		t.Errorf("drifted inputs gave %v", err)
+	}
+	if err := runConvert(t, "-upstream", upstream, "-output", t.TempDir(), "-verify-lock"); err == nil {
+		t.Errorf("-verify-lock passed without a lock")
+	}
+
+	// Each drifted input is listed
+	current, err := buildLock(upstream, filepath.Join(upstream, "_includes"), defaultCategories, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var drift []string
+	for _, warning := range captureWarnings(t, func() { verifyLock(current, output) }) {
+		if strings.HasPrefix(warning, "Warning: input ") {
+			drift = append(drift, warning)
+		}
+	}
+	want := []string{
+		"Warning: input added detection/c2/1-dns.sql since the lock was written",
+		"Warning: input changed policy/ssh-root.sql since the lock was written",
+		"Warning: input removed detection/execution/2-shell.sql since the lock was written",
+	}
+	if strings.Join(drift, "\n") != strings.Join(want, "\n") {
+		t.Errorf("warnings =\n%s\nwant\n%s", strings.Join(drift, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import "strings"
+
+// timeColumns are columns whose use in a WHERE clause means a query looks at
+// recent activity rather than current state
+var timeColumns = map[string]bool{
+	"time": true, "mtime": true, "ctime": true, "atime": true, "btime": true,
+	"start_time": true, "timestamp": true, "last_opened_time": true, "last_run_time": true,
+}
+
+// timeFunctions compute the current time in SQLite
+var timeFunctions = map[string]bool{
+	"datetime": true, "strftime": true, "unixepoch": true, "julianday": true,
+}
+
+// autoLogging fills in the logging type of detections without a
+// -- logging: header from inferLogging
+type autoLogging struct{}
+
+func (autoLogging) precedence() int { return precedenceDefault }
+
+func (autoLogging) apply(q *Query) {
+	if q.Logging == "" && q.Category == "detection" {
+		q.Logging = inferLogging(*q)
+	}
+}
+
+// inferLogging guesses a logging type from the shape of the query. Queries
+// reading an *_events table or filtering on a timestamp report activity, so
+// differential logging keeps only new rows. Anything else is treated as a
+// point-in-time inventory, where a snapshot of the full result is clearer.
+func inferLogging(q Query) string {
+	for _, table := range referencedTables(q.Query) {
+		if strings.HasSuffix(table, "_events") {
+			return "differential"
+		}
+	}
+
+	tokens := tokenizeSQL(q.Query)
+	inWhere := false
+	for i, t := range tokens {
+		switch {
+		case t.is("WHERE"):
+			inWhere = true
+			continue
+		case t.is("GROUP") || t.is("ORDER") || t.is("LIMIT") || t.is("UNION"):
+			inWhere = false
+			continue
+		}
+		if !inWhere {
+			continue
+		}
+
+		isCall := i+1 < len(tokens) && tokens[i+1].text == "("
+		name := strings.ToLower(t.text)
+		switch {
+		case t.kind == tokIdent && isCall && timeFunctions[name]:
+			return "differential"
+		case t.kind == tokIdent && !isCall && timeColumns[name]:
+			return "differential"
+		case t.kind == tokString && strings.EqualFold(t.text, "now"):
+			return "differential"
+		}
+	}
+	return "snapshot"
+}
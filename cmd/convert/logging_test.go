@@ -0,0 +1,56 @@
+package main
+
+import "testing"
+
+func TestInferLogging(t *testing.T) {
+	tests := []struct {
+		name, query, want string
+	}{
+		// Event-like: activity since the last run
+		{"events table", "SELECT pid, path FROM process_events", "differential"},
+		{"events table in a join", "SELECT p.name FROM processes p JOIN socket_events s ON s.pid = p.pid", "differential"},
+		{"time column filter", "SELECT path FROM file WHERE path LIKE '/tmp/%' AND mtime > 1700000000", "differential"},
+		{"qualified time column", "SELECT p.pid FROM processes p WHERE p.start_time > 0", "differential"},
+		{"time function filter", "SELECT name FROM users WHERE last_login > strftime('%s', 'now') - 3600", "differential"},
+		{"now literal", "SELECT * FROM logged_in_users WHERE time > datetime('now', '-1 hour')", "differential"},
+		// Inventory-like: current state
+		{"plain inventory", "SELECT name, version FROM deb_packages", "snapshot"},
+		{"filtered inventory", "SELECT pid, name FROM processes WHERE name = 'sshd'", "snapshot"},
+		{"time column only selected", "SELECT path, mtime FROM file WHERE path = '/etc/passwd'", "snapshot"},
+		{"time column only ordered by", "SELECT path FROM file WHERE directory = '/tmp' ORDER BY mtime", "snapshot"},
+		{"time in a string", "SELECT name FROM processes WHERE name = 'mtime'", "snapshot"},
+		{"events in a string", "SELECT name FROM processes WHERE cmdline LIKE '%_events%'", "snapshot"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := inferLogging(Query{Query: tt.query, Category: "detection"}); got != tt.want {
+				t.Errorf("inferLogging = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAutoLogging(t *testing.T) {
+	inventory := "SELECT name FROM deb_packages"
+	tests := []struct {
+		name  string
+		query Query
+		want  string
+	}{
+		{"inferred for detections", Query{Category: "detection", Query: inventory}, "snapshot"},
+		{"header wins", Query{Category: "detection", Query: inventory, Logging: "differential"}, "differential"},
+		{"other categories keep their default", Query{Category: "policy", Query: inventory}, "differential"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := loggingFor(resolveMetadata(tt.query, autoLogging{})); got != tt.want {
+				t.Errorf("logging = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	// Without -auto-logging detections stay differential
+	if got := loggingFor(Query{Category: "detection", Query: inventory}); got != "differential" {
+		t.Errorf("default detection logging = %s", got)
+	}
+}
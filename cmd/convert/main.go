@@ -3,65 +3,776 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"flag"
 	"fmt"
+	"hash/fnv"
+	"io"
 	"io/fs"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"unicode"
 )
 
 type Query struct {
-	Name        string
-	Description string
-	Query       string
-	Platform    string
-	Tags        []string
-	Interval    int    // execution interval in seconds (0 = not specified)
-	Level       int    // 1, 2, 3 for detection queries; 0 for others
-	Category    string // detection, policy, incident_response
-	Subcategory string // e.g., execution, persistence, c2
-}
-
-var (
-	tagsRegex     = regexp.MustCompile(`^--\s*tags:\s*(.+)$`)
-	platformRegex = regexp.MustCompile(`^--\s*platform:\s*(.+)$`)
-	intervalRegex = regexp.MustCompile(`^--\s*interval:\s*(\d+)$`)
-	levelRegex    = regexp.MustCompile(`^(\d)-(.+)\.sql$`)
-)
+	Name            string
+	Slug            string // stable ID from the source path, e.g., detection-c2-dns-tunnel
+	Description     string
+	Described       bool   // Description came from the file rather than -empty-description
+	Summary         string // short description from -- summary:, replaces Description
+	LongDescription string // full first comment block, when longer than Description
+	Query           string
+	Platform        string
+	EnabledOn       string // platforms from -- enabled_platforms:, narrowing Platform
+	PlatformVersion string // OS version constraints, e.g., >=13.0,<15
+	Tags            []string
+	Interval        int       // execution interval in seconds
+	IntervalSet     bool      // Interval was given; an explicit 0 means on demand only
+	CheckEvery      int       // policy check cadence in seconds from -- check_every:; replaces Interval
+	IntervalJitter  int       // max seconds added to Interval, derived from the name
+	Level           int       // 1, 2, 3 for detection queries; 0 for others
+	Severity        string    // low, medium, high from -- severity:
+	Confidence      string    // low, medium, high from -- confidence:, also emitted as a confidence: tag
+	Category        string    // detection, policy, incident_response
+	Subcategory     string    // e.g., execution, persistence, c2
+	Techniques      []string  // ATT&CK technique IDs, e.g., T1059.004
+	Labels          []string  // Fleet labels that scope which hosts run the query
+	Team            string    // Fleet team from -- team: or -team; "" = global
+	ObserverCanRun  *bool     // nil = not specified (Fleet default)
+	Denylist        *bool     // nil = not specified; false exempts the query from the watchdog denylist
+	DiscardData     *bool     // nil = not specified; true runs the query for automations without storing results
+	Requires        []string  // host capabilities the query depends on, e.g., network, edr
+	RequiresSudo    *bool     // nil = not declared; from -- requires_sudo:
+	OsqueryFlags    []string  // osqueryd flags the query depends on, e.g., --disable_events=false
+	Path            string    // source file the query was parsed from
+	Source          string    // Path relative to the upstream root with forward slashes, for output
+	Origin          string    // upstream repository, from -source-name or the origin remote of the file's checkout
+	License         string    // SPDX license, from -source-license or the nearest LICENSE file above the file
+	Deprecated      string    // reason from -- deprecated:; "" = not deprecated
+	Runbook         string    // triage runbook URL from -- triage:
+	Note            string    // operational caveats from -- note:, kept apart from the description
+	Maintenance     string    // daily HH:MM-HH:MM suppression window from -- maintenance_window:
+	TestAssertions  []string  // expected-result assertions from -- test:, e.g., expect_empty_on_clean_host
+	RelatedTo       []string  // overlapping queries from -- overlap:, as slugs once resolved
+	As              string    // label to emit a Fleet dynamic label instead of a query; "" = -category-map or query
+	Logging         string    // snapshot, differential, or differential_ignore_removals; "" = category default
+	Created         time.Time // from -- created:; zero = unknown
+	Updated         time.Time // from -- updated:; zero = unknown
+	Committed       time.Time // last commit touching the file, from -git-dates; zero = unknown
+	EnabledFrom     time.Time // from -- enabled_from:; the query is skipped before this date
+
+	// Annotations set by custom directives; built-in annotations win on a clash
+	Annotations map[string]string
+}
+
+var levelRegex = regexp.MustCompile(`^(\d)-(.+)\.sql$`)
+
+// defaultCategories are the upstream top-level directories that hold queries;
+// -category-map can add more
+var defaultCategories = []string{"detection", "policy", "incident_response"}
+
+// placeholderDescription is used for queries without a description under
+// -empty-description=placeholder
+const placeholderDescription = "No description provided"
+
+// parseOptions controls how query files are interpreted
+type parseOptions struct {
+	EmptyDescription string // name, sql, placeholder, or warn
+	IncludeDir       string // directory -- include: paths are resolved against
+	SortTags         bool   // sort tags alphabetically for stable output
+
+	// Boilerplate removed from the start and end of every query body; nil
+	// skips stripping. Compiled with stripPattern.
+	StripPrefix *regexp.Regexp
+	StripSuffix *regexp.Regexp
+
+	// Categories are the top-level directories parsed; nil means
+	// defaultCategories
+	Categories []string
+
+	// Only, when not nil, limits parsing to these slash-separated paths
+	// relative to the upstream directory (-changed-since)
+	Only map[string]bool
+
+	// Warn receives warnings about the parsed file; nil prints them directly
+	Warn func(format string, args ...any)
+}
 
 func main() {
-	upstreamDir := flag.String("upstream", "upstream", "Path to osquery-defense-kit submodule")
+	if err := run(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	start := time.Now()
+	upstreamDir := flag.String("upstream", "upstream", "Path to osquery-defense-kit submodule, or a .tar.gz, .tgz, or .zip release archive")
 	outputDir := flag.String("output", "output", "Output directory for FleetDM YAML files")
+	team := flag.String("team", "", "Fleet team to assign queries to that have no -- team: header (default: global)")
+	tagsLabels := flag.Bool("tags-as-labels", false, "Also scope each query to Fleet labels named after its tags")
+	apiVersionFlag := flag.String("api-version", "v1", "Fleet spec apiVersion to emit in query and label documents ("+strings.Join(fleetAPIVersions, ", ")+")")
+	posixFlag := flag.String("posix-platforms", "darwin,linux", "Platforms the posix platform alias expands to ("+strings.Join(posixTargets, ", ")+"; posix keeps it literal)")
+	autoLoggingFlag := flag.Bool("auto-logging", false, "Use snapshot logging for detections that look like point-in-time inventories instead of always differential")
+	categoryMapPath := flag.String("category-map", "", "JSON file setting the kind (query or label) and logging of each category; unlisted categories keep the defaults, and new ones are parsed too")
+	categoryLimitFlag := flag.String("category-limit", "", "Row cap per category appended as LIMIT to queries without one, e.g. incident_response=10000")
+	tierIntervalsFlag := flag.String("tier-intervals", "", "Interval in seconds per detection level, e.g. 3=300,2=900,1=3600; overrides -- interval: headers")
+	policyIntervalFlag := flag.Int("policy-interval", 0, "Default check interval in seconds for policies without -- check_every: or -- interval: (0 = none)")
+	schedulePath := flag.String("schedule", "", "Path to a JSON scheduling policy mapping query names or tags to intervals")
+	allowEmpty := flag.Bool("allow-empty", false, "Skip the check that the upstream directory contains a category directory")
+	groupBy := flag.String("group-by", "category", "How to split detection files: "+strings.Join(groupByKeys(), ", "))
+	emptyDescription := flag.String("empty-description", "name", "Fallback for queries without a description: name, sql, placeholder, or warn")
+	fleetSchema := flag.String("fleet-schema", "", "Path to a Fleet spec JSON schema to validate emitted documents against")
+	includeDir := flag.String("includes", "", "Directory of shared SQL snippets for -- include: (default <upstream>/_includes)")
+	sanitizeTextFlag := flag.Bool("sanitize-text", false, "Strip invalid UTF-8 and control characters from names and descriptions instead of only warning")
+	asciiDescriptions := flag.Bool("ascii-descriptions", false, "Replace curly quotes, dashes, ellipses, and non-breaking spaces in names and descriptions with ASCII instead of warning about them")
+	splitByPlatform := flag.Bool("split-by-platform", false, "Write output into one subdirectory per platform (common/ for queries without one)")
+	diffDir := flag.String("diff", "", "Previous output directory to compare the new catalog against")
+	maxChangePct := flag.Float64("max-change-pct", 0, "Abort if more than this percentage of queries changed since the previous output (0 = no limit)")
+	force := flag.Bool("force", false, "Write output even when -max-change-pct is exceeded")
+	postHook := flag.String("post-hook", "", "Command to run on each written file ({} is the file) or once on the output directory ({dir}); not run through a shell")
+	doctor := flag.Bool("doctor", false, "Run internal consistency checks against this binary and -upstream, then exit")
+	listDirectives := flag.Bool("list-directives", false, "Print the supported -- header directives and exit")
+	sortTags := flag.Bool("sort-tags", true, "Sort tags alphabetically instead of keeping source order")
+	var excludedSubcategories stringList
+	flag.Var(&excludedSubcategories, "exclude-subcategory", "Skip queries in this subcategory, e.g. execution or detection/execution (repeatable)")
+	flag.BoolVar(&quiet, "quiet", false, "Suppress progress and warnings; only errors are printed")
+	flag.BoolVar(&verbose, "verbose", false, "Print each parsed file")
+	format := flag.String("format", "yaml", "Output format: yaml (FleetDM specs), sqlite (catalog database), osquery-pack (osquery pack JSON), terraform (fleetdm_query resources), gitops (one file per query slug), siem (Elastic rule skeletons), or rego (OPA rule skeletons for policies); a comma-separated list writes each from one parse")
+	minPathDepth := flag.Int("min-path-depth", 1, "With -lint, report file and hash table path patterns that fix fewer directories than this before a wildcard, e.g. path LIKE '/%'; 0 disables the check")
+	similarity := flag.Float64("similarity-threshold", 0.9, "With -lint, report query pairs whose normalized SQL tokens are at least this similar (0-1, 0 = off)")
+	strict := flag.Bool("strict", false, "Fail when a query reads a privileged table without declaring -- requires_sudo: true (implies the privileged-table lint), or when a subcategory directory has no valid queries")
+	extraLints := flag.Bool("lint", false, "Also run opt-in lints, such as references to tables removed from osquery")
+	removedTablesPath := flag.String("removed-tables", "", "JSON file mapping removed osquery tables to the version that removed them, replacing the built-in list")
+	osqueryVersion := flag.String("osquery-version", "", "Warn about tables and columns the given osquery version does not have yet, e.g. 5.2.0")
+	tableVersionsPath := flag.String("table-versions", "", "JSON file mapping osquery tables (or table.column) to the version that added them, replacing the built-in list used by -osquery-version")
+	maxQueryBytes := flag.Int("max-query-bytes", 65536, "Warn about query bodies larger than this many bytes (0 = no limit)")
+	maxQueryLines := flag.Int("max-query-lines", 1000, "Warn about query bodies longer than this many lines (0 = no limit)")
+	wrapWidth := flag.Int("wrap-sql", 0, "Wrap query lines longer than this many columns after commas and before clause keywords (0 = no wrapping)")
+	coverage := flag.Bool("coverage", false, "Also write an ATT&CK coverage report (attack-coverage.json and attack-coverage.md)")
+	stripPrefix := flag.String("strip-prefix", "", "Regular expression removed from the start of every query body when it matches there")
+	stripSuffix := flag.String("strip-suffix", "", "Regular expression removed from the end of every query body when it matches there")
+	requireMetadata := flag.String("require-metadata", "", "Fail if a detection lacks any of these comma-separated fields: "+strings.Join(metadataFieldNames(), ", "))
+	appendMode := flag.Bool("append", false, "Add new queries to the existing chainguard-all.yml instead of replacing it; queries already in it by name are skipped")
+	headerCommentFlag := flag.Bool("header-comment", false, "Start each query YAML file with a comment recording the tool version, upstream commit, and generation time, marking it as generated")
+	noTimestamp := flag.Bool("no-timestamp", false, "Leave the generation time out of -header-comment, for byte-stable output")
+	maxDocs := flag.Int("max-docs-per-file", 0, "Split category and grouped YAML files with more queries than this into numbered parts, e.g. chainguard-detection-001.yml; chainguard-all.yml is never split")
+	singleDoc := flag.Bool("single-document", false, "Write each YAML file as one document holding a list of query specs instead of a --- separated stream")
+	overwrite := flag.Bool("overwrite", false, "With -append, replace queries already in chainguard-all.yml instead of skipping them")
+	stableFilenames := flag.Bool("stable-filenames", false, "With -format gitops, name each query file after its full source path, level prefix included, instead of its slug")
+	emitControls := flag.Bool("emit-controls", false, "With -format gitops, also write a default.yml referencing the query files, with placeholder org settings and agent options")
+	lock := flag.Bool("lock", false, "Also write defensekit.lock, recording the tool version, upstream commit, flags, and a hash of every input file")
+	verifyLockFlag := flag.Bool("verify-lock", false, "Fail before converting if the input files differ from the output directory's defensekit.lock")
+	metrics := flag.String("metrics", "", "Write Prometheus text-format metrics about the run to this file")
+	emitSchema := flag.Bool("emit-schema", false, "Also write query.schema.json, a JSON Schema of the Query record generated from its Go definition")
+	genTests := flag.Bool("gen-tests", false, "Also write a test stub per query under tests/ in the output directory, mirroring the source layout; existing stubs are kept")
+	testFormat := flag.String("test-format", "yaml", "Format of -gen-tests stubs ("+strings.Join(testStubFormats, ", ")+")")
+	testManifest := flag.Bool("test-manifest", false, "Also write test-manifest.json listing the -- test: assertions of each query")
+	catalog := flag.Bool("catalog", false, "Also write catalog.md, a Markdown page describing every query")
+	tableIndex := flag.Bool("table-index", false, "Also write table-index.json, mapping each osquery table to the queries that read it")
+	volume := flag.Bool("volume", false, "Also write an estimate of each detection's daily log volume per host (log-volume.json and log-volume.md)")
+	maxDailyEvents := flag.Int("max-daily-events", 0, "Warn about detections estimated to log more than this many events per host per day (0 = no limit)")
+	coverageBaseline := flag.String("coverage-baseline", "", "File of technique IDs to measure coverage against, one per line (default: all enterprise techniques)")
+	ignoreRollout := flag.Bool("ignore-rollout", false, "Include queries whose -- enabled_from: date has not been reached yet")
+	includeDeprecated := flag.Bool("include-deprecated", false, "Keep queries marked with -- deprecated: instead of skipping them")
+	push := flag.Bool("push", false, "Apply the queries to the Fleet server at -fleet-url instead of writing files")
+	fleetURL := flag.String("fleet-url", "", "Fleet server URL for -push, e.g. https://fleet.example.com")
+	fleetToken := flag.String("fleet-token", "", "Fleet API token for -push (default $FLEET_API_TOKEN)")
+	dryRun := flag.Bool("dry-run", false, "With -push, print the requests instead of sending them")
+	changelog := flag.String("changelog", "", "Write a CHANGELOG.md fragment of query changes since the previous output (-diff or -output) to this file")
+	sourceName := flag.String("source-name", "", "Upstream repository recorded as each query's source_repository annotation (default: the origin remote of the checkout holding the query)")
+	sourceLicense := flag.String("source-license", "", "SPDX license recorded as each query's source_license annotation (default: detected from the nearest LICENSE file above the query)")
+	gitDates := flag.Bool("git-dates", false, "Record each query file's last commit date from the upstream git history as the last_commit annotation; -lint uses it for staleness when there is no -- updated: header")
+	changedSince := flag.String("changed-since", "", "Only convert query files that git diff reports changed in -upstream since this ref, e.g. origin/main")
+	search := flag.String("search", "", "Only emit queries whose name, description, or SQL contains this text, case-insensitively; combine terms with AND and OR")
+	interactive := flag.Bool("interactive", false, "Choose which queries to emit from a checklist (ignored when not on a terminal)")
+	cpuProfile := flag.String("cpuprofile", "", "Write a CPU profile to this file")
+	memProfile := flag.String("memprofile", "", "Write a heap profile to this file on exit")
 	flag.Parse()
 
-	queries, err := parseAllQueries(*upstreamDir)
+	if *listDirectives {
+		return printDirectives(os.Stdout)
+	}
+	givenFlags := setFlags()
+	if isArchive(*upstreamDir) {
+		dir, cleanup, err := extractUpstream(*upstreamDir)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+		*upstreamDir = dir
+	}
+
+	// -doctor looks for the mapped category directories too
+	categories := defaultCategories
+	var categoryMapping categoryMap
+	if *categoryMapPath != "" {
+		var err error
+		if categoryMapping, err = loadCategoryMap(*categoryMapPath); err != nil {
+			return fmt.Errorf("loading category map: %w", err)
+		}
+		categories = addCategories(categories, categoryMapping)
+	}
+	if *doctor {
+		return runDoctor(os.Stdout, *upstreamDir, categories, *fleetSchema)
+	}
+
+	if quiet && verbose {
+		return fmt.Errorf("-quiet and -verbose cannot be used together")
+	}
+	if !containsString(fleetAPIVersions, *apiVersionFlag) {
+		return fmt.Errorf("unknown -api-version %q (want %s)", *apiVersionFlag, strings.Join(fleetAPIVersions, ", "))
+	}
+	apiVersion = *apiVersionFlag
+	var err error
+	if posixPlatforms, err = parsePosixPlatforms(*posixFlag); err != nil {
+		return fmt.Errorf("invalid -posix-platforms: %w", err)
+	}
+	if *osqueryVersion != "" && !osqueryVersionRegex.MatchString(*osqueryVersion) {
+		return fmt.Errorf("invalid -osquery-version %q (want a version like 5.2.0)", *osqueryVersion)
+	}
+
+	switch *emptyDescription {
+	case "name", "sql", "placeholder", "warn":
+	default:
+		return fmt.Errorf("unknown -empty-description %q (want name, sql, placeholder, or warn)", *emptyDescription)
+	}
+	opts := parseOptions{EmptyDescription: *emptyDescription, IncludeDir: *includeDir, SortTags: *sortTags}
+	if opts.IncludeDir == "" {
+		opts.IncludeDir = filepath.Join(*upstreamDir, "_includes")
+	}
+	if opts.StripPrefix, err = stripPattern(*stripPrefix, false); err != nil {
+		return fmt.Errorf("invalid -strip-prefix: %w", err)
+	}
+	if opts.StripSuffix, err = stripPattern(*stripSuffix, true); err != nil {
+		return fmt.Errorf("invalid -strip-suffix: %w", err)
+	}
+
+	if !containsString(groupByKeys(), *groupBy) {
+		return fmt.Errorf("unknown -group-by %q (want %s)", *groupBy, strings.Join(groupByKeys(), ", "))
+	}
+
+	formats := normalizeList(strings.Split(*format, ","))
+	if len(formats) == 0 {
+		return fmt.Errorf("-format needs at least one of %s", strings.Join(outputFormats, ", "))
+	}
+	for _, f := range formats {
+		if !containsString(outputFormats, f) {
+			return fmt.Errorf("unknown -format %q (want %s, or several separated by commas)", f, strings.Join(outputFormats, ", "))
+		}
+	}
+
+	if *minPathDepth < 0 {
+		return fmt.Errorf("-min-path-depth must not be negative")
+	}
+	if *similarity < 0 || *similarity > 1 {
+		return fmt.Errorf("-similarity-threshold must be between 0 and 1")
+	}
+	var searchFilter searchExpr
+	if *search != "" {
+		if searchFilter, err = parseSearch(*search); err != nil {
+			return fmt.Errorf("invalid -search: %w", err)
+		}
+	}
+	opts.Categories = categories
+	if !containsString(testStubFormats, *testFormat) {
+		return fmt.Errorf("unknown -test-format %q (want %s)", *testFormat, strings.Join(testStubFormats, ", "))
+	}
+	if *maxDailyEvents < 0 {
+		return fmt.Errorf("-max-daily-events must not be negative")
+	}
+	if *groupBy == "platform-category" && *splitByPlatform {
+		return fmt.Errorf("-group-by platform-category already splits by platform; drop -split-by-platform")
+	}
+	if *appendMode && (!containsString(formats, "yaml") || *splitByPlatform) {
+		return fmt.Errorf("-append only applies to the combined file of -format yaml without -split-by-platform")
+	}
+	if *overwrite && !*appendMode {
+		return fmt.Errorf("-overwrite only applies with -append")
+	}
+	if *singleDoc && *appendMode {
+		return fmt.Errorf("-append reads chainguard-all.yml as a document stream; drop -single-document")
+	}
+	singleDocument = *singleDoc
+	if *maxDocs < 0 {
+		return fmt.Errorf("-max-docs-per-file must not be negative")
+	}
+	maxDocsPerFile = *maxDocs
+	if *noTimestamp && !*headerCommentFlag {
+		return fmt.Errorf("-no-timestamp only applies with -header-comment")
+	}
+	var requiredFields []string
+	if *requireMetadata != "" {
+		if requiredFields, err = parseRequiredFields(*requireMetadata); err != nil {
+			return fmt.Errorf("invalid -require-metadata: %w", err)
+		}
+	}
+	if *emitControls && !containsString(formats, "gitops") {
+		return fmt.Errorf("-emit-controls requires -format gitops")
+	}
+	if *stableFilenames && !containsString(formats, "gitops") {
+		return fmt.Errorf("-stable-filenames requires -format gitops")
+	}
+
+	if *cpuProfile != "" {
+		stop, err := startCPUProfile(*cpuProfile)
+		if err != nil {
+			return err
+		}
+		defer stop()
+	}
+	if *memProfile != "" {
+		defer writeHeapProfile(*memProfile)
+	}
+
+	if !*allowEmpty {
+		if err := checkUpstream(*upstreamDir, categories); err != nil {
+			return err
+		}
+	}
+
+	if *changedSince != "" {
+		if _, err := exec.LookPath("git"); err != nil {
+			warnf("-changed-since needs git, converting all queries\n")
+		} else if changed, deleted, err := changedFiles(*upstreamDir, *changedSince); err != nil {
+			warnf("finding changes since %s failed, converting all queries: %v\n", *changedSince, err)
+		} else if includesChanged(changed, *upstreamDir, opts.IncludeDir) {
+			infof("Snippets in %s changed since %s, converting all queries\n", opts.IncludeDir, *changedSince)
+		} else {
+			for _, path := range deleted {
+				infof("Deleted since %s: %s\n", *changedSince, path)
+			}
+			infof("%d query files changed since %s\n", len(changed), *changedSince)
+			opts.Only = changed
+		}
+	}
+
+	var inputs lockFile
+	if *lock || *verifyLockFlag {
+		if inputs, err = buildLock(*upstreamDir, opts.IncludeDir, categories, givenFlags); err != nil {
+			return fmt.Errorf("hashing inputs: %w", err)
+		}
+	}
+	if *verifyLockFlag {
+		if err := verifyLock(inputs, *outputDir); err != nil {
+			return fmt.Errorf("verifying lock: %w", err)
+		}
+		infof("Inputs match %s\n", filepath.Join(*outputDir, lockFilename))
+	}
+
+	if *headerCommentFlag {
+		timestamp := ""
+		if !*noTimestamp {
+			timestamp = start.UTC().Format(time.RFC3339)
+		}
+		headerComment = buildHeaderComment(toolVersion(), upstreamCommit(*upstreamDir), timestamp)
+	}
+
+	queries, err := parseAllQueries(*upstreamDir, opts)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error parsing queries: %v\n", err)
-		os.Exit(1)
+		return fmt.Errorf("parsing queries: %w", err)
+	}
+
+	infof("Parsed %d queries\n", len(queries))
+
+	if *gitDates {
+		addGitDates(queries, *upstreamDir)
+	}
+
+	addProvenance(queries, *upstreamDir, *sourceName, *sourceLicense)
+
+	// A partial -changed-since parse leaves most directories without queries
+	if opts.Only == nil {
+		orphans, err := orphanedSubcategories(*upstreamDir, categories, queries)
+		if err != nil {
+			return fmt.Errorf("listing subcategories: %w", err)
+		}
+		for _, dir := range orphans {
+			warnf("%s has no valid queries; check for misplaced or malformed files\n", dir)
+		}
+		if *strict && len(orphans) > 0 {
+			return fmt.Errorf("%d subcategory directories have no valid queries (-strict)", len(orphans))
+		}
+	}
+
+	queries = excludeSubcategories(queries, *upstreamDir, excludedSubcategories)
+	queries = filterDeprecated(queries, *includeDeprecated)
+	queries = filterRollout(queries, *ignoreRollout, start)
+	queries = filterSearch(queries, searchFilter, *search)
+
+	if *interactive {
+		if isTerminal(os.Stdin) && isTerminal(os.Stdout) {
+			if queries, err = pickQueries(queries, os.Stdin, os.Stdout); err != nil {
+				return err
+			}
+			infof("Selected %d queries\n", len(queries))
+		} else {
+			warnf("-interactive needs a terminal, converting all queries\n")
+		}
+	}
+
+	checkText(queries, *sanitizeTextFlag)
+	checkTypography(queries, *asciiDescriptions)
+	warnDuplicateNames(queries)
+	if err := checkSlugs(queries); err != nil {
+		return err
+	}
+	resolveRelated(queries)
+	if len(requiredFields) > 0 {
+		if err := checkRequiredMetadata(queries, requiredFields); err != nil {
+			return err
+		}
+	}
+
+	var sources []metadataSource
+	if *autoLoggingFlag {
+		sources = append(sources, autoLogging{})
+	}
+	if categoryMapping != nil {
+		sources = append(sources, categoryMapping)
+	}
+	if *policyIntervalFlag > 0 {
+		sources = append(sources, policyInterval(*policyIntervalFlag))
+	}
+	if *team != "" {
+		sources = append(sources, defaultTeam(*team))
+	}
+	if *tagsLabels {
+		sources = append(sources, tagsAsLabels{})
+	}
+	if *categoryLimitFlag != "" {
+		limits, err := parseCategoryLimits(*categoryLimitFlag, categories)
+		if err != nil {
+			return fmt.Errorf("invalid -category-limit: %w", err)
+		}
+		sources = append(sources, limits)
+	}
+	if *tierIntervalsFlag != "" {
+		tiers, err := parseTierIntervals(*tierIntervalsFlag)
+		if err != nil {
+			return fmt.Errorf("invalid -tier-intervals: %w", err)
+		}
+		sources = append(sources, tiers)
+	}
+	if *schedulePath != "" {
+		schedule, err := loadSchedule(*schedulePath)
+		if err != nil {
+			return fmt.Errorf("loading schedule: %w", err)
+		}
+		warnUnmatchedSchedule(queries, schedule)
+		sources = append(sources, schedule)
+	}
+
+	for i := range queries {
+		queries[i] = resolveMetadata(queries[i], sources...)
+	}
+
+	// Lint what will be emitted, but before wrapping moves line numbers
+	lintOpts := lintOptions{MaxBytes: *maxQueryBytes, MaxLines: *maxQueryLines}
+	if *extraLints || *strict {
+		lintOpts.Privileged = true
+		lintOpts.Strict = *strict
+	}
+	if *extraLints {
+		lintOpts.Similarity = *similarity
+		lintOpts.StaleAfter = staleAfter
+		lintOpts.CostFloors = true
+		lintOpts.EventFlags = true
+		lintOpts.MinPathDepth = *minPathDepth
+		lintOpts.RemovedTables = removedTables
+		if *removedTablesPath != "" {
+			if lintOpts.RemovedTables, err = loadVersionMap(*removedTablesPath); err != nil {
+				return fmt.Errorf("loading removed tables: %w", err)
+			}
+		}
+	}
+	if *osqueryVersion != "" {
+		lintOpts.OsqueryVersion = *osqueryVersion
+		lintOpts.TableVersions = tableVersions
+		if *tableVersionsPath != "" {
+			if lintOpts.TableVersions, err = loadVersionMap(*tableVersionsPath); err != nil {
+				return fmt.Errorf("loading table versions: %w", err)
+			}
+		}
+	}
+	if err := lintQueries(queries, lintOpts); err != nil {
+		return err
+	}
+
+	for i := range queries {
+		queries[i].Query = wrapSQL(queries[i].Query, *wrapWidth)
+	}
+
+	if *fleetSchema != "" {
+		if err := validateFleetSchema(queries, *fleetSchema); err != nil {
+			return fmt.Errorf("validating against Fleet schema: %w", err)
+		}
+	}
+
+	if *push {
+		token := *fleetToken
+		if token == "" {
+			token = os.Getenv("FLEET_API_TOKEN")
+		}
+		client, err := newFleetClient(*fleetURL, token, *dryRun)
+		if err != nil {
+			return err
+		}
+		if err := pushQueries(client, queries); err != nil {
+			return err
+		}
+		infof("Pushed %d queries to %s\n", len(queries), *fleetURL)
+		return nil
 	}
 
-	fmt.Printf("Parsed %d queries\n", len(queries))
+	if *diffDir != "" {
+		previous, err := loadPreviousCatalog(*diffDir)
+		if err != nil {
+			return fmt.Errorf("loading previous catalog: %w", err)
+		}
+		d, err := diffCatalog(previous, queries)
+		if err != nil {
+			return fmt.Errorf("comparing catalogs: %w", err)
+		}
+		printCatalogDiff(d, *diffDir)
+	}
+
+	// Guard against a bad upstream bump rewriting most of the catalog
+	if *maxChangePct > 0 {
+		baseline := *diffDir
+		if baseline == "" {
+			baseline = *outputDir
+		}
+		if err := checkChangeRate(baseline, queries, *maxChangePct, *force); err != nil {
+			return err
+		}
+	}
+
+	if *changelog != "" {
+		baseline := *diffDir
+		if baseline == "" {
+			baseline = *outputDir
+		}
+		if err := writeChangelog(*changelog, baseline, queries, categories); err != nil {
+			return fmt.Errorf("writing changelog: %w", err)
+		}
+		infof("Wrote %s\n", *changelog)
+	}
 
 	if err := os.MkdirAll(*outputDir, 0755); err != nil {
-		fmt.Fprintf(os.Stderr, "Error creating output directory: %v\n", err)
-		os.Exit(1)
+		return fmt.Errorf("creating output directory: %w", err)
 	}
 
-	if err := writeFleetYAML(queries, *outputDir); err != nil {
-		fmt.Fprintf(os.Stderr, "Error writing YAML: %v\n", err)
-		os.Exit(1)
+	if *coverage {
+		baseline := defaultCoverageBaseline()
+		if *coverageBaseline != "" {
+			if baseline, err = loadCoverageBaseline(*coverageBaseline); err != nil {
+				return fmt.Errorf("loading coverage baseline: %w", err)
+			}
+		}
+		report := buildCoverage(queries, baseline)
+		if err := writeCoverage(report, *outputDir); err != nil {
+			return fmt.Errorf("writing coverage report: %w", err)
+		}
+		infof("ATT&CK coverage: %d of %d techniques (%.1f%%)\n", len(report.Covered), report.Baseline, report.CoveragePct)
+	}
+
+	if *catalog {
+		if err := writeCatalog(queries, *outputDir); err != nil {
+			return fmt.Errorf("writing catalog: %w", err)
+		}
+	}
+
+	if *tableIndex {
+		if err := writeTableIndex(queries, *outputDir); err != nil {
+			return fmt.Errorf("writing table index: %w", err)
+		}
+	}
+
+	if *volume || *maxDailyEvents > 0 {
+		report := buildVolume(queries, *maxDailyEvents)
+		warnVolume(report)
+		if *volume {
+			if err := writeVolume(report, *outputDir); err != nil {
+				return fmt.Errorf("writing log volume report: %w", err)
+			}
+			infof("Estimated log volume: %d events per host per day\n", report.EventsPerDay)
+		}
+	}
+
+	if *emitSchema {
+		if err := writeQuerySchema(*outputDir); err != nil {
+			return fmt.Errorf("writing query schema: %w", err)
+		}
 	}
 
-	fmt.Println("Successfully generated FleetDM YAML files")
+	if *testManifest {
+		if err := writeTestManifest(queries, *outputDir); err != nil {
+			return fmt.Errorf("writing test manifest: %w", err)
+		}
+	}
+
+	if *genTests {
+		if err := writeTestStubs(queries, *outputDir, *testFormat); err != nil {
+			return fmt.Errorf("writing test stubs: %w", err)
+		}
+	}
+
+	combinedFile := filepath.Join(*outputDir, "chainguard-all.yml")
+	var existing []combinedDoc
+	if *appendMode {
+		if existing, err = loadCombinedDocs(combinedFile); err != nil {
+			return fmt.Errorf("reading %s for -append: %w", combinedFile, err)
+		}
+	}
+
+	// Every format shares the one parse; each writes its own files
+	for _, f := range formats {
+		if err := writeOutput(queries, f, *outputDir, *splitByPlatform, *groupBy, *stableFilenames); err != nil {
+			return err
+		}
+	}
+	if *appendMode {
+		if err := writeAppendedCombined(combinedFile, existing, queries, *overwrite); err != nil {
+			return err
+		}
+	}
+	if *emitControls {
+		if err := writeGitOpsDefault(queriesOnly(queries, "gitops"), *outputDir, *stableFilenames); err != nil {
+			return fmt.Errorf("writing GitOps default.yml: %w", err)
+		}
+	}
+
+	if *postHook != "" {
+		if err := runPostHook(*postHook, *outputDir, writtenFiles()); err != nil {
+			return err
+		}
+	}
+
+	if *lock {
+		if err := writeLock(inputs, *outputDir); err != nil {
+			return fmt.Errorf("writing lock: %w", err)
+		}
+	}
+
+	if *metrics != "" {
+		if err := writeMetrics(*metrics, queries, categories, time.Since(start)); err != nil {
+			return fmt.Errorf("writing metrics: %w", err)
+		}
+	}
+	return nil
 }
 
-func parseAllQueries(upstreamDir string) ([]Query, error) {
-	var queries []Query
+// outputFormats are the values -format accepts
+var outputFormats = []string{"yaml", "sqlite", "osquery-pack", "terraform", "gitops", "siem", "rego"}
+
+// writeOutput writes queries to outputDir in the given -format.
+// stableFilenames names GitOps files by source path instead of slug.
+func writeOutput(queries []Query, format, outputDir string, splitByPlatform bool, groupBy string, stableFilenames bool) error {
+	if format != "yaml" {
+		queries = queriesOnly(queries, format)
+	}
+	switch format {
+	case "sqlite":
+		catalogFile := filepath.Join(outputDir, "chainguard-catalog.db")
+		if err := writeSQLiteCatalog(queries, catalogFile); err != nil {
+			return fmt.Errorf("writing SQLite catalog: %w", err)
+		}
+		infof("Wrote %s (%d queries)\n", catalogFile, len(queries))
+		return nil
+	case "osquery-pack":
+		packFile := filepath.Join(outputDir, "chainguard-pack.json")
+		n, err := writeOsqueryPack(queries, packFile)
+		if err != nil {
+			return fmt.Errorf("writing osquery pack: %w", err)
+		}
+		infof("Wrote %s (%d queries)\n", packFile, n)
+		return nil
+	case "terraform":
+		tfFile := filepath.Join(outputDir, "chainguard-queries.tf")
+		if err := writeTerraform(queries, tfFile); err != nil {
+			return fmt.Errorf("writing Terraform: %w", err)
+		}
+		infof("Wrote %s (%d queries)\n", tfFile, len(queries))
+		return nil
+	case "gitops":
+		gitopsDir := filepath.Join(outputDir, "queries")
+		if err := writeGitOpsQueries(queries, gitopsDir, stableFilenames); err != nil {
+			return fmt.Errorf("writing GitOps queries: %w", err)
+		}
+		infof("Wrote %d query files to %s\n", len(queries), gitopsDir)
+		return nil
+	case "siem":
+		rulesFile := filepath.Join(outputDir, "chainguard-siem-rules.ndjson")
+		if err := writeSIEMRules(queries, rulesFile); err != nil {
+			return fmt.Errorf("writing SIEM rules: %w", err)
+		}
+		infof("Wrote %s (%d rules)\n", rulesFile, len(queries))
+		return nil
+	case "rego":
+		bundleDir := filepath.Join(outputDir, "rego")
+		n, err := writeRegoBundle(queries, bundleDir)
+		if err != nil {
+			return fmt.Errorf("writing Rego bundle: %w", err)
+		}
+		infof("Wrote %d policy rules to %s\n", n, bundleDir)
+		return nil
+	}
 
-	categories := []string{"detection", "policy", "incident_response"}
+	if groupBy == "platform-category" {
+		if err := writePlatformCategoryFiles(queries, outputDir); err != nil {
+			return fmt.Errorf("writing YAML: %w", err)
+		}
+	} else if splitByPlatform {
+		if err := writePlatformDirs(queries, outputDir, groupBy); err != nil {
+			return fmt.Errorf("writing YAML: %w", err)
+		}
+	} else if err := writeFleetYAML(queries, outputDir, groupBy); err != nil {
+		return fmt.Errorf("writing YAML: %w", err)
+	}
+
+	infof("Successfully generated FleetDM YAML files\n")
+	return nil
+}
 
+// checkUpstream fails fast when the upstream directory is missing or holds
+// none of the category directories, which would otherwise produce empty output
+func checkUpstream(upstreamDir string, categories []string) error {
+	info, err := os.Stat(upstreamDir)
+	if err != nil {
+		return fmt.Errorf("upstream directory: %w (is the submodule checked out?)", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("upstream path %s is not a directory", upstreamDir)
+	}
+
+	for _, category := range categories {
+		if info, err := os.Stat(filepath.Join(upstreamDir, category)); err == nil && info.IsDir() {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("upstream directory %s contains none of: %s (use -allow-empty to skip this check)", upstreamDir, strings.Join(categories, ", "))
+}
+
+func parseAllQueries(upstreamDir string, opts parseOptions) ([]Query, error) {
+	type job struct {
+		path, category, catPath string
+	}
+	var jobs []job
+
+	categories := opts.Categories
+	if categories == nil {
+		categories = defaultCategories
+	}
 	for _, category := range categories {
 		catPath := filepath.Join(upstreamDir, category)
 		if _, err := os.Stat(catPath); os.IsNotExist(err) {
@@ -75,14 +786,10 @@ func parseAllQueries(upstreamDir string) ([]Query, error) {
 			if d.IsDir() || !strings.HasSuffix(path, ".sql") {
 				return nil
 			}
-
-			query, err := parseQuery(path, category, catPath)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Warning: failed to parse %s: %v\n", path, err)
+			if rel, err := filepath.Rel(upstreamDir, path); opts.Only != nil && (err != nil || !opts.Only[filepath.ToSlash(rel)]) {
 				return nil
 			}
-
-			queries = append(queries, query)
+			jobs = append(jobs, job{path, category, catPath})
 			return nil
 		})
 		if err != nil {
@@ -90,18 +797,66 @@ func parseAllQueries(upstreamDir string) ([]Query, error) {
 		}
 	}
 
+	// Parse in parallel, buffering each file's warnings so they are printed
+	// in file order once all workers are done
+	results := make([]*Query, len(jobs))
+	warnings := newWarningCollector()
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range min(runtime.GOMAXPROCS(0), len(jobs)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				jobOpts := opts
+				jobOpts.Warn = warnings.scope(i)
+				query, err := parseQuery(jobs[i].path, jobs[i].category, jobs[i].catPath, jobOpts)
+				if err != nil {
+					jobOpts.Warn("failed to parse %s: %v\n", jobs[i].path, err)
+					continue
+				}
+				results[i] = &query
+			}
+		}()
+	}
+	for i := range jobs {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	var queries []Query
+	for i, query := range results {
+		warnings.flush(i)
+		if query != nil {
+			debugf("Parsed %s\n", jobs[i].path)
+			queries = append(queries, *query)
+		}
+	}
 	return queries, nil
 }
 
-func parseQuery(path, category, categoryPath string) (Query, error) {
+func parseQuery(path, category, categoryPath string, opts parseOptions) (Query, error) {
 	file, err := os.Open(path)
 	if err != nil {
 		return Query{}, err
 	}
 	defer file.Close()
 
+	return parseQueryReader(file, path, category, categoryPath, opts)
+}
+
+// parseQueryReader parses query content from r. path is only used to derive
+// the subcategory, level, and name, so r need not be backed by a file.
+func parseQueryReader(r io.Reader, path, category, categoryPath string, opts parseOptions) (Query, error) {
 	var q Query
 	q.Category = category
+	q.Path = path
+
+	src := source{path: path, warn: opts.Warn}
+	if src.warn == nil {
+		src.warn = warnf
+	}
 
 	// Extract subcategory from path (e.g., detection/execution/file.sql -> execution)
 	relPath, _ := filepath.Rel(categoryPath, path)
@@ -109,51 +864,113 @@ func parseQuery(path, category, categoryPath string) (Query, error) {
 	if len(parts) > 1 {
 		q.Subcategory = parts[0]
 	}
+	q.Source = category + "/" + strings.Join(parts, "/")
 
 	// Extract level and base name from filename
 	filename := filepath.Base(path)
 	if matches := levelRegex.FindStringSubmatch(filename); matches != nil {
-		fmt.Sscanf(matches[1], "%d", &q.Level)
+		q.Level, _ = strconv.Atoi(matches[1])
 		filename = matches[2] + ".sql"
 	}
 
-	// Generate human-readable name
+	// Generate human-readable name and stable ID
 	q.Name = generateName(filename, q.Category, q.Subcategory)
+	q.Slug = generateSlug(q.Category, parts[:len(parts)-1], filename)
 
-	scanner := bufio.NewScanner(file)
+	scanner := bufio.NewScanner(r)
 	var sqlLines []string
+	var descriptionBlock []string
 	firstComment := true
+	inDescription := false
 	inHeader := true
+	started := false // a non-blank line has been read
+	fence := 0       // line of the opening front-matter fence while inside it
+	var frontMatter []string
 
 	for scanner.Scan() {
-		line := scanner.Text()
+		src.line++
+
+		// Tolerate CRLF line endings so header regexes still match
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+
+		// A YAML front-matter block may open the file instead of header lines
+		if fence > 0 {
+			if strings.TrimSpace(line) == frontMatterFence {
+				description, err := applyFrontMatter(&q, frontMatter, src, fence)
+				if err != nil {
+					return q, err
+				}
+				if len(description) > 0 {
+					q.Description = description[0]
+					descriptionBlock = description
+					firstComment = false
+				}
+				fence = 0
+				continue
+			}
+			content, ok := strings.CutPrefix(line, "--")
+			if !ok {
+				return q, fmt.Errorf("line %d: front matter lines must start with --", src.line)
+			}
+			frontMatter = append(frontMatter, strings.TrimPrefix(content, " "))
+			continue
+		}
+		if !started && strings.TrimSpace(line) == frontMatterFence {
+			fence, started = src.line, true
+			continue
+		}
+		if strings.TrimSpace(line) != "" {
+			started = true
+		}
+
+		// Splice shared snippets in place of include directives; the
+		// snippet is SQL, so it also ends the header
+		if matches := includeRegex.FindStringSubmatch(line); matches != nil {
+			snippet, err := expandInclude(src, opts.IncludeDir, matches[1], nil)
+			if err != nil {
+				return q, fmt.Errorf("line %d: %w", src.line, err)
+			}
+			sqlLines = append(sqlLines, snippet...)
+			inHeader = false
+			continue
+		}
 
 		if inHeader && strings.HasPrefix(line, "--") {
 			commentContent := strings.TrimPrefix(line, "--")
 			commentContent = strings.TrimSpace(commentContent)
 
-			// Check for tags
-			if matches := tagsRegex.FindStringSubmatch(line); matches != nil {
-				q.Tags = strings.Fields(matches[1])
+			// Decorative rules such as "-- ======" are neither description
+			// nor SQL
+			if isBanner(line) {
+				inDescription = false
 				continue
 			}
 
-			// Check for platform
-			if matches := platformRegex.FindStringSubmatch(line); matches != nil {
-				q.Platform = normalizePlatform(strings.TrimSpace(matches[1]))
-				continue
-			}
+			// ATT&CK technique IDs may appear anywhere in the header, usually in references
+			q.Techniques = appendTechniques(q.Techniques, line)
 
-			// Check for interval
-			if matches := intervalRegex.FindStringSubmatch(line); matches != nil {
-				fmt.Sscanf(matches[1], "%d", &q.Interval)
-				continue
+			// Registered "-- key: value" directives
+			if matches := headerRegex.FindStringSubmatch(line); matches != nil {
+				if d, ok := lookupDirective(matches[1]); ok && d.apply != nil {
+					applyDirective(d, &q, strings.TrimSpace(matches[2]), src)
+					inDescription = false
+					continue
+				}
 			}
 
-			// First non-empty comment line is the description
-			if firstComment && commentContent != "" && !strings.HasPrefix(commentContent, "references:") && !strings.HasPrefix(commentContent, "false positives:") {
+			// First non-empty comment line is the description; the rest of
+			// its comment block is the long description
+			isText := commentContent != "" && !strings.HasPrefix(commentContent, "references:") && !strings.HasPrefix(commentContent, "false positives:")
+			switch {
+			case firstComment && isText:
 				q.Description = commentContent
+				descriptionBlock = []string{commentContent}
 				firstComment = false
+				inDescription = true
+			case inDescription && isText:
+				descriptionBlock = append(descriptionBlock, commentContent)
+			default:
+				inDescription = false
 			}
 		} else if strings.TrimSpace(line) != "" && !strings.HasPrefix(line, "--") {
 			inHeader = false
@@ -165,20 +982,231 @@ func parseQuery(path, category, categoryPath string) (Query, error) {
 		}
 	}
 
+	if fence > 0 {
+		return q, fmt.Errorf("line %d: front matter is not closed with %q", fence, frontMatterFence)
+	}
+
+	// Later warnings are about the query as a whole
+	src.line = 0
+
 	// Trim leading empty lines from SQL
 	for len(sqlLines) > 0 && strings.TrimSpace(sqlLines[0]) == "" {
 		sqlLines = sqlLines[1:]
 	}
 
+	// An included snippet may open with its own comment banner; drop it
+	// through its closing rule
+	sqlLines = trimBanner(sqlLines)
+
 	q.Query = strings.TrimSpace(strings.Join(sqlLines, "\n"))
+	q.Query = stripBoilerplate(src, q.Query, opts.StripPrefix, opts.StripSuffix)
+	q.Query = canonicalSQL(q.Query)
+	if query, statements := trimStatement(q.Query); statements > 1 {
+		src.warnf("query has %d statements; osquery runs only one per query\n", statements)
+	} else {
+		q.Query = query
+	}
+
+	// Narrowed once the whole header is read, so the order of the two lines
+	// does not matter
+	q.Platform = narrowPlatform(src, q.Platform, q.EnabledOn)
+
+	// Confidence is added after the header so a later tags line can't drop it
+	if q.Confidence != "" {
+		q.Tags = appendUnique(q.Tags, confidenceTagPrefix+q.Confidence)
+	}
+
+	// Tags are already normalized and deduplicated by the tags directive
+	if q.CheckEvery > 0 {
+		q.Interval = q.CheckEvery
+		q.IntervalSet = true
+	}
+
+	if opts.SortTags {
+		sort.Strings(q.Tags)
+	}
+
+	if len(descriptionBlock) > 1 || (q.Summary != "" && len(descriptionBlock) > 0) {
+		q.LongDescription = strings.Join(descriptionBlock, " ")
+	}
+	if q.Summary != "" {
+		q.Description = q.Summary
+	}
 
+	q.Described = q.Description != ""
 	if q.Description == "" {
-		q.Description = q.Name
+		switch opts.EmptyDescription {
+		case "sql":
+			q.Description, _, _ = strings.Cut(q.Query, "\n")
+			q.Description = strings.TrimSpace(q.Description)
+		case "placeholder":
+			q.Description = placeholderDescription
+		case "warn":
+			src.warn("%s has no description\n", path)
+		default:
+			q.Description = q.Name
+		}
 	}
 
 	return q, scanner.Err()
 }
 
+// bannerRunes may be repeated to draw a decorative comment rule
+const bannerRunes = "=-*#~_+/"
+
+// isBanner reports whether line is a comment made only of one rule character
+// repeated at least four times, such as "-- ======". Four keeps the
+// front-matter fence, "-- ---", from counting as a rule.
+func isBanner(line string) bool {
+	content, ok := strings.CutPrefix(strings.TrimSpace(line), "--")
+	if !ok {
+		return false
+	}
+	content = strings.TrimSpace(content)
+	if len(content) < 4 || !strings.ContainsRune(bannerRunes, rune(content[0])) {
+		return false
+	}
+	return strings.Trim(content, content[:1]) == ""
+}
+
+// trimBanner removes a comment block at the start of lines that opens with a
+// banner rule, up to and including the last rule before the SQL
+func trimBanner(lines []string) []string {
+	if len(lines) == 0 || !isBanner(lines[0]) {
+		return lines
+	}
+	end := 0
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed != "" && !strings.HasPrefix(trimmed, "--") {
+			break
+		}
+		if isBanner(line) {
+			end = i + 1
+		}
+	}
+	lines = lines[end:]
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	return lines
+}
+
+// stripPattern compiles a -strip-prefix or -strip-suffix expression anchored
+// to the start or end of the query body. An empty expression returns nil.
+func stripPattern(expr string, atEnd bool) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	if atEnd {
+		return regexp.Compile(`(?:` + expr + `)\z`)
+	}
+	return regexp.Compile(`\A(?:` + expr + `)`)
+}
+
+// stripBoilerplate removes the parts of query matched by prefix and suffix.
+// A query that would be left empty is kept as is and reported.
+func stripBoilerplate(src source, query string, prefix, suffix *regexp.Regexp) string {
+	stripped := query
+	if prefix != nil {
+		if loc := prefix.FindStringIndex(stripped); loc != nil {
+			stripped = stripped[loc[1]:]
+		}
+	}
+	if suffix != nil {
+		if loc := suffix.FindStringIndex(stripped); loc != nil {
+			stripped = stripped[:loc[0]]
+		}
+	}
+	stripped = strings.TrimSpace(stripped)
+
+	if stripped == "" && query != "" {
+		src.warnf("not stripping boilerplate, nothing would be left of the query\n")
+		return query
+	}
+	return stripped
+}
+
+// parseBoolHeader parses a true/false header value, warning and returning nil
+// (unset) when it isn't a boolean
+func parseBoolHeader(src source, key, raw string) *bool {
+	raw = strings.TrimSpace(raw)
+	v, err := strconv.ParseBool(raw)
+	if err != nil {
+		src.warnf("%s must be true or false, got %q\n", key, raw)
+		return nil
+	}
+	return &v
+}
+
+// warnDuplicateNames reports queries sharing a name; Fleet identifies
+// queries by name, so all but the last applied would be silently replaced
+func warnDuplicateNames(queries []Query) {
+	first := map[string]string{}
+	for _, q := range queries {
+		if path, ok := first[q.Name]; ok {
+			warnf("duplicate query name %q in %s and %s\n", q.Name, path, q.Path)
+			continue
+		}
+		first[q.Name] = q.Path
+	}
+}
+
+// checkSlugs fails when two queries map to the same slug, since slugs are
+// used as filenames and identities
+func checkSlugs(queries []Query) error {
+	first := map[string]string{}
+	for _, q := range queries {
+		if path, ok := first[q.Slug]; ok {
+			return fmt.Errorf("slug %q is shared by %s and %s; rename one of them", q.Slug, path, q.Path)
+		}
+		first[q.Slug] = q.Path
+	}
+	return nil
+}
+
+// resolveRelated replaces -- overlap: references, given as names or slugs,
+// with the slugs of the queries they name. References to queries that are
+// not in the catalog are reported and dropped.
+func resolveRelated(queries []Query) {
+	slugs := map[string]string{}
+	for _, q := range queries {
+		slugs[q.Slug] = q.Slug
+		slugs[strings.ToLower(q.Name)] = q.Slug
+	}
+
+	for i := range queries {
+		q := &queries[i]
+		var resolved []string
+		for _, ref := range q.RelatedTo {
+			slug, ok := slugs[strings.ToLower(ref)]
+			switch {
+			case !ok:
+				warnf("%s: overlap %q does not match any converted query\n", q.Path, ref)
+			case slug == q.Slug:
+				warnf("%s: overlap %q refers to the query itself\n", q.Path, ref)
+			default:
+				resolved = appendUnique(resolved, slug)
+			}
+		}
+		q.RelatedTo = resolved
+	}
+}
+
+// normalizeList trims each value and drops empty and duplicate entries,
+// keeping the first occurrence order
+func normalizeList(values []string) []string {
+	var out []string
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if v == "" || containsString(out, v) {
+			continue
+		}
+		out = append(out, v)
+	}
+	return out
+}
+
 func generateName(filename, category, subcategory string) string {
 	// Remove .sql extension
 	name := strings.TrimSuffix(filename, ".sql")
@@ -197,22 +1225,124 @@ func generateName(filename, category, subcategory string) string {
 	return fmt.Sprintf("[%s] %s", category, name)
 }
 
-func normalizePlatform(platform string) string {
-	switch strings.ToLower(platform) {
-	case "darwin", "macos":
-		return "darwin"
-	case "linux":
-		return "linux"
-	case "posix":
-		return "darwin,linux"
-	case "windows":
-		return "windows"
-	default:
+// generateSlug derives a stable lowercase ID from the category, the
+// directories below it, and the filename without its level prefix, so
+// changing a query's name or level does not change its ID
+func generateSlug(category string, dirs []string, filename string) string {
+	parts := append([]string{category}, dirs...)
+	parts = append(parts, strings.TrimSuffix(filename, ".sql"))
+	return slugify(strings.Join(parts, "-"))
+}
+
+// slugify lowercases s and replaces each run of other characters than
+// letters and digits with one hyphen, trimming hyphens at the ends
+func slugify(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case b.Len() > 0 && !strings.HasSuffix(b.String(), "-"):
+			b.WriteByte('-')
+		}
+	}
+	return strings.TrimSuffix(b.String(), "-")
+}
+
+// posixPlatforms is what the posix platform alias expands to, set from
+// -posix-platforms. A literal "posix" keeps the alias in the output.
+var posixPlatforms = []string{"darwin", "linux"}
+
+// posixTargets are the values -posix-platforms accepts
+var posixTargets = []string{"darwin", "linux", "freebsd", "windows", "chrome", "posix"}
+
+// parsePosixPlatforms validates a comma-separated -posix-platforms value
+func parsePosixPlatforms(value string) ([]string, error) {
+	platforms := normalizeList(strings.Split(strings.ToLower(value), ","))
+	if len(platforms) == 0 {
+		return nil, fmt.Errorf("no platforms given")
+	}
+	for _, p := range platforms {
+		if !containsString(posixTargets, p) {
+			return nil, fmt.Errorf("unknown platform %q (want %s)", p, strings.Join(posixTargets, ", "))
+		}
+	}
+	return platforms, nil
+}
+
+// normalizePlatform maps a comma-separated platform list to Fleet's format,
+// expanding aliases and dropping duplicates. Unknown entries are reported and
+// skipped. "all" returns "", which Fleet runs on every platform.
+func normalizePlatform(src source, platform string) string {
+	var platforms []string
+	all := false
+	for _, entry := range strings.Split(platform, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		var expanded []string
+		switch entry {
+		case "":
+			continue
+		case "all":
+			all = true
+			continue
+		case "darwin", "macos":
+			expanded = []string{"darwin"}
+		case "linux":
+			expanded = []string{"linux"}
+		case "posix":
+			expanded = posixPlatforms
+		case "windows":
+			expanded = []string{"windows"}
+		default:
+			src.warnf("ignoring unknown platform %q\n", entry)
+			continue
+		}
+		for _, p := range expanded {
+			if !containsString(platforms, p) {
+				platforms = append(platforms, p)
+			}
+		}
+	}
+	if all {
+		if len(platforms) > 0 {
+			src.warnf("platform all already includes %s\n", strings.Join(platforms, ","))
+		}
 		return ""
 	}
+	return strings.Join(platforms, ",")
 }
 
-func writeFleetYAML(queries []Query, outputDir string) error {
+// narrowPlatform intersects a normalized platform list with the normalized
+// enabled_platforms list, keeping platform's order. No platform means every
+// platform, so enabled is used as is. A narrowing that leaves nothing is
+// reported and platform kept.
+func narrowPlatform(src source, platform, enabled string) string {
+	if enabled == "" {
+		return platform
+	}
+	if platform == "" {
+		return enabled
+	}
+	declared, allowed := strings.Split(platform, ","), strings.Split(enabled, ",")
+	var narrowed []string
+	for _, p := range declared {
+		if containsString(allowed, p) {
+			narrowed = append(narrowed, p)
+		}
+	}
+	if len(narrowed) == 0 {
+		src.warnf("ignoring enabled_platforms %s: leaves none of platform %s\n", enabled, platform)
+		return platform
+	}
+	for _, p := range allowed {
+		if !containsString(declared, p) {
+			src.warnf("enabled_platforms %s is not in platform %s\n", p, platform)
+		}
+	}
+	return strings.Join(narrowed, ",")
+}
+
+func writeFleetYAML(queries []Query, outputDir, groupBy string) error {
 	// Group by category
 	groups := map[string][]Query{
 		"detection":         {},
@@ -224,145 +1354,428 @@ func writeFleetYAML(queries []Query, outputDir string) error {
 		groups[q.Category] = append(groups[q.Category], q)
 	}
 
-	for category, categoryQueries := range groups {
+	// Upstream categories first, then any -category-map added, in name order
+	// as addCategories lists them
+	seen := categoryMap{}
+	for category := range groups {
+		seen[category] = categoryMapping{}
+	}
+
+	for _, category := range addCategories(defaultCategories, seen) {
+		categoryQueries := groups[category]
 		if len(categoryQueries) == 0 {
 			continue
 		}
 
-		filename := filepath.Join(outputDir, fmt.Sprintf("chainguard-%s.yml", strings.ReplaceAll(category, "_", "-")))
-		file, err := os.Create(filename)
-		if err != nil {
-			return fmt.Errorf("creating %s: %w", filename, err)
-		}
-
-		for i, q := range categoryQueries {
-			if i > 0 {
-				file.WriteString("---\n")
-			}
-			if err := writeQueryYAML(file, q, 0); err != nil {
-				file.Close()
+		// Detections can be split by another key instead of one category file
+		if category == "detection" && groupBy != "category" {
+			if err := writeGroupedFiles(categoryQueries, outputDir, groupBy); err != nil {
 				return err
 			}
+			continue
+		}
+
+		filename := filepath.Join(outputDir, fmt.Sprintf("chainguard-%s.yml", strings.ReplaceAll(category, "_", "-")))
+		if err := writeQueryFile(filename, categoryQueries); err != nil {
+			return err
 		}
-		file.Close()
-		fmt.Printf("Wrote %s (%d queries)\n", filename, len(categoryQueries))
+		infof("Wrote %s (%d queries)\n", filename, len(categoryQueries))
 	}
 
-	// Write detection rules with 5-minute interval for all
-	if detectionQueries := groups["detection"]; len(detectionQueries) > 0 {
-		scheduledFile := filepath.Join(outputDir, "chainguard-detection-5min.yml")
-		file, err := os.Create(scheduledFile)
-		if err != nil {
-			return fmt.Errorf("creating %s: %w", scheduledFile, err)
+	// Write detection and incident response rules with one fixed interval for all
+	for _, variant := range fixedIntervalVariants {
+		variantQueries := groups[variant.category]
+		if len(variantQueries) == 0 {
+			continue
+		}
+		scheduledFile := filepath.Join(outputDir, fmt.Sprintf("chainguard-%s-%s.yml", strings.ReplaceAll(variant.category, "_", "-"), variant.suffix()))
+		if err := writeQueryFile(scheduledFile, variantQueries, fixedInterval(variant.interval)); err != nil {
+			return err
 		}
+		infof("Wrote %s (%d queries, %d-min interval)\n", scheduledFile, len(variantQueries), variant.interval/60)
+	}
 
-		for i, q := range detectionQueries {
-			if i > 0 {
-				file.WriteString("---\n")
-			}
-			if err := writeQueryYAML(file, q, 300); err != nil {
-				file.Close()
-				return err
-			}
+	// Also write a combined file
+	combinedFile := filepath.Join(outputDir, "chainguard-all.yml")
+	if err := writeQueryDocumentFile(combinedFile, queries); err != nil {
+		return fmt.Errorf("writing combined file: %w", err)
+	}
+	infof("Wrote %s (%d queries)\n", combinedFile, len(queries))
+
+	return nil
+}
+
+// fixedIntervalVariant is a category also written with every query scheduled
+// at one interval, as chainguard-<category>-<N>min.yml
+type fixedIntervalVariant struct {
+	category string
+	interval int // seconds
+}
+
+func (v fixedIntervalVariant) suffix() string {
+	return fmt.Sprintf("%dmin", v.interval/60)
+}
+
+// fixedIntervalVariants are written in this order after the category files
+var fixedIntervalVariants = []fixedIntervalVariant{
+	{"detection", 300},
+	{"incident_response", 600},
+}
+
+// writePlatformDirs writes the regular file set into one subdirectory per
+// platform. Multi-platform queries are written to each of their platforms;
+// queries without a platform go to common/.
+func writePlatformDirs(queries []Query, outputDir, groupBy string) error {
+	byPlatform := map[string][]Query{}
+	for _, q := range queries {
+		platforms := []string{"common"}
+		if q.Platform != "" {
+			platforms = strings.Split(q.Platform, ",")
+		}
+		for _, platform := range platforms {
+			byPlatform[platform] = append(byPlatform[platform], q)
 		}
-		file.Close()
-		fmt.Printf("Wrote %s (%d queries, 5-min interval)\n", scheduledFile, len(detectionQueries))
 	}
 
-	// Write incident response rules with 10-minute interval for all
-	if irQueries := groups["incident_response"]; len(irQueries) > 0 {
-		scheduledFile := filepath.Join(outputDir, "chainguard-incident-response-10min.yml")
-		file, err := os.Create(scheduledFile)
-		if err != nil {
-			return fmt.Errorf("creating %s: %w", scheduledFile, err)
+	platforms := make([]string, 0, len(byPlatform))
+	for platform := range byPlatform {
+		platforms = append(platforms, platform)
+	}
+	sort.Strings(platforms)
+
+	for _, platform := range platforms {
+		dir := filepath.Join(outputDir, platform)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("creating %s: %w", dir, err)
 		}
+		if err := writeFleetYAML(byPlatform[platform], dir, groupBy); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
 
-		for i, q := range irQueries {
+// singleDocument writes each output file as one YAML document holding a
+// list of query specs instead of a --- separated stream, set from
+// -single-document
+var singleDocument bool
+
+// writeQueryDocuments writes one query document per query, as a stream or
+// under -single-document as the items of one top-level list
+func writeQueryDocuments(w io.Writer, queries []Query, overrides ...metadataSource) error {
+	if singleDocument && len(queries) == 0 {
+		_, err := io.WriteString(w, "[]\n")
+		return err
+	}
+	for i, q := range queries {
+		q = resolveMetadata(q, overrides...)
+		if !singleDocument {
 			if i > 0 {
-				file.WriteString("---\n")
+				io.WriteString(w, "---\n")
 			}
-			if err := writeQueryYAML(file, q, 600); err != nil {
-				file.Close()
+			if err := writeCheckedYAML(w, q, func(w io.Writer) error { return writeQueryYAML(w, q) }); err != nil {
 				return err
 			}
+			continue
 		}
-		file.Close()
-		fmt.Printf("Wrote %s (%d queries, 10-min interval)\n", scheduledFile, len(irQueries))
-	}
-
-	// Also write a combined file
-	combinedFile := filepath.Join(outputDir, "chainguard-all.yml")
-	file, err := os.Create(combinedFile)
-	if err != nil {
-		return fmt.Errorf("creating combined file: %w", err)
-	}
-	defer file.Close()
 
-	for i, q := range queries {
-		if i > 0 {
-			file.WriteString("---\n")
-		}
-		if err := writeQueryYAML(file, q, 0); err != nil {
+		var buf bytes.Buffer
+		if err := writeCheckedYAML(&buf, q, func(w io.Writer) error { return writeQueryYAML(w, q) }); err != nil {
 			return err
 		}
+		for j, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+			lead := "  "
+			if j == 0 {
+				lead = "- "
+			}
+			if line == "" {
+				lead = ""
+			}
+			fmt.Fprintf(w, "%s%s\n", lead, line)
+		}
 	}
-	fmt.Printf("Wrote %s (%d queries)\n", combinedFile, len(queries))
-
 	return nil
 }
 
-func writeQueryYAML(w *os.File, q Query, intervalOverride int) error {
+// fleetAPIVersions are the Fleet spec versions -api-version accepts
+var fleetAPIVersions = []string{"v1"}
+
+// apiVersion is the Fleet spec version of every query, label, and policy
+// document, set from -api-version
+var apiVersion = "v1"
+
+func writeQueryYAML(w io.Writer, q Query) error {
+	switch q.As {
+	case "label":
+		return writeLabelYAML(w, q)
+	case "policy":
+		return writePolicyYAML(w, q)
+	}
+	fmt.Fprintf(w, "apiVersion: %s\n", apiVersion)
+	io.WriteString(w, "kind: query\n")
+	writeAnnotations(w, queryAnnotations(q))
+	io.WriteString(w, "spec:\n")
+	return writeQueryFields(w, q, "  ")
+}
+
+// writeQueryFields emits the spec fields of q indented by two spaces. lead
+// replaces the indentation of the first line, so "- " starts a list item.
+func writeQueryFields(w io.Writer, q Query, lead string) error {
 	// Escape description for YAML
 	desc := escapeYAML(q.Description)
-	query := escapeYAMLMultiline(q.Query)
 
-	w.WriteString("apiVersion: v1\n")
-	w.WriteString("kind: query\n")
-	w.WriteString("spec:\n")
-	w.WriteString(fmt.Sprintf("  name: %s\n", escapeYAML(q.Name)))
-	w.WriteString(fmt.Sprintf("  description: %s\n", desc))
+	fmt.Fprintf(w, "%sname: %s\n", lead, escapeYAML(q.Name))
+	fmt.Fprintf(w, "  description: %s\n", desc)
 
 	// Use literal block scalar for multi-line queries
-	w.WriteString("  query: |\n")
-	for _, line := range strings.Split(query, "\n") {
-		w.WriteString(fmt.Sprintf("    %s\n", line))
-	}
+	writeBlockScalar(w, "  query", "    ", q.Query)
 
 	if q.Platform != "" {
-		w.WriteString(fmt.Sprintf("  platform: %s\n", q.Platform))
+		fmt.Fprintf(w, "  platform: %s\n", q.Platform)
 	}
 
-	// Add interval: use override if specified, otherwise use query's interval
-	interval := q.Interval
-	if intervalOverride > 0 {
-		interval = intervalOverride
+	if q.Team != "" {
+		fmt.Fprintf(w, "  team: %s\n", escapeYAML(q.Team))
 	}
-	if interval > 0 {
-		w.WriteString(fmt.Sprintf("  interval: %d\n", interval))
+
+	// Only hosts in at least one of these labels run the query
+	if len(q.Labels) > 0 {
+		io.WriteString(w, "  labels_include_any:\n")
+		for _, label := range q.Labels {
+			fmt.Fprintf(w, "    - %s\n", escapeYAML(label))
+		}
 	}
 
-	// Add logging type based on category
-	if q.Category == "detection" || q.Category == "policy" {
-		w.WriteString("  logging: differential\n")
-	} else {
-		w.WriteString("  logging: snapshot\n")
+	if q.IntervalSet || q.Interval > 0 {
+		fmt.Fprintf(w, "  interval: %d\n", jitteredInterval(q))
+	}
+
+	if q.ObserverCanRun != nil {
+		fmt.Fprintf(w, "  observer_can_run: %t\n", *q.ObserverCanRun)
 	}
 
+	if q.Denylist != nil {
+		fmt.Fprintf(w, "  denylist: %t\n", *q.Denylist)
+	}
+
+	if q.DiscardData != nil {
+		fmt.Fprintf(w, "  discard_data: %t\n", *q.DiscardData)
+	}
+
+	fmt.Fprintf(w, "  logging: %s\n", loggingFor(q))
+
 	return nil
 }
 
+// jitteredInterval adds a deterministic offset in [0, IntervalJitter] derived
+// from the query name, so queries sharing an interval don't all fire together
+func jitteredInterval(q Query) int {
+	if q.Interval <= 0 || q.IntervalJitter <= 0 {
+		return q.Interval
+	}
+	h := fnv.New32a()
+	io.WriteString(h, q.Name)
+	return q.Interval + int(h.Sum32()%uint32(q.IntervalJitter+1))
+}
+
+// loggingFor picks the logging type from -- logging: or -auto-logging,
+// falling back to one based on category
+func loggingFor(q Query) string {
+	if q.Logging != "" {
+		return q.Logging
+	}
+	if q.Category == "detection" || q.Category == "policy" {
+		return "differential"
+	}
+	return "snapshot"
+}
+
+// queryAnnotations collects converter metadata that has no Fleet spec field.
+// It is emitted under metadata.annotations, which fleetctl ignores. Only
+// values the query has are included.
+func queryAnnotations(q Query) map[string]string {
+	annotations := map[string]string{}
+	if q.Slug != "" {
+		annotations["slug"] = q.Slug
+		annotations["uuid"] = queryUUID(q.Slug)
+	}
+	if q.LongDescription != "" && q.LongDescription != q.Description {
+		annotations["documentation"] = q.LongDescription
+	}
+	if len(q.Tags) > 0 {
+		annotations["tags"] = strings.Join(q.Tags, ",")
+	}
+	if columns := selectColumns(q.Query); columns != nil {
+		annotations["columns"] = strings.Join(columns, ",")
+	}
+	// Provenance that doesn't resolve is left out, so an upstream without a
+	// remote or license file gets the same output as before it was recorded
+	if q.Origin != "" {
+		annotations["source_repository"] = q.Origin
+	}
+	if q.License != "" {
+		annotations["source_license"] = q.License
+	}
+	if q.Runbook != "" {
+		annotations["runbook"] = q.Runbook
+	}
+	if q.Note != "" {
+		annotations["note"] = q.Note
+	}
+	if q.Maintenance != "" {
+		annotations["maintenance_window"] = q.Maintenance
+	}
+	if q.Severity != "" {
+		annotations["severity"] = q.Severity
+	}
+	if q.Deprecated != "" {
+		annotations["deprecated"] = q.Deprecated
+	}
+	if q.PlatformVersion != "" {
+		annotations["platform_version"] = q.PlatformVersion
+	}
+	if len(q.Requires) > 0 {
+		annotations["requires"] = strings.Join(q.Requires, ",")
+	}
+	if len(q.OsqueryFlags) > 0 {
+		annotations["osquery_flags"] = strings.Join(q.OsqueryFlags, " ")
+	}
+	if q.RequiresSudo != nil {
+		annotations["requires_sudo"] = strconv.FormatBool(*q.RequiresSudo)
+	}
+	if !q.Created.IsZero() {
+		annotations["created"] = q.Created.Format(time.DateOnly)
+	}
+	if !q.Updated.IsZero() {
+		annotations["updated"] = q.Updated.Format(time.DateOnly)
+	}
+	if !q.Committed.IsZero() {
+		annotations["last_commit"] = q.Committed.Format(time.DateOnly)
+	}
+	if !q.EnabledFrom.IsZero() {
+		annotations["enabled_from"] = q.EnabledFrom.Format(time.DateOnly)
+	}
+	if len(q.RelatedTo) > 0 {
+		annotations["related"] = strings.Join(q.RelatedTo, ",")
+	}
+	if len(q.TestAssertions) > 0 {
+		annotations["tests"] = strings.Join(q.TestAssertions, ",")
+	}
+	for key, value := range q.Annotations {
+		if _, ok := annotations[key]; !ok && value != "" {
+			annotations[key] = value
+		}
+	}
+	return annotations
+}
+
+func writeAnnotations(w io.Writer, annotations map[string]string) {
+	if len(annotations) == 0 {
+		return
+	}
+
+	keys := make([]string, 0, len(annotations))
+	for key := range annotations {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	io.WriteString(w, "metadata:\n")
+	io.WriteString(w, "  annotations:\n")
+	for _, key := range keys {
+		fmt.Fprintf(w, "    %s: %s\n", key, escapeYAML(annotations[key]))
+	}
+}
+
 func escapeYAML(s string) string {
-	// If string contains special characters, quote it
-	if strings.ContainsAny(s, ":#{}[]|>&*!?'\"\\") || strings.HasPrefix(s, "-") || strings.HasPrefix(s, "@") {
-		// Use double quotes and escape internal quotes
-		s = strings.ReplaceAll(s, "\\", "\\\\")
-		s = strings.ReplaceAll(s, "\"", "\\\"")
-		return fmt.Sprintf("\"%s\"", s)
+	// An empty plain scalar would be read back as null
+	if s == "" {
+		return `""`
+	}
+	// Quote indicators, surrounding whitespace, and characters YAML does not
+	// allow unescaped.
+	// strconv.Quote's escapes are all valid in a double-quoted YAML scalar.
+	if strings.ContainsAny(s, ":#{}[]|>&*!?'\"\\") || strings.ContainsAny(s[:1], "-?,@%`") ||
+		strings.TrimSpace(s) != s || strings.IndexFunc(s, func(r rune) bool { return unicode.IsControl(r) || r == '\uFEFF' }) >= 0 ||
+		yamlNonString(s) {
+		return strconv.Quote(s)
 	}
 	return s
 }
 
-func escapeYAMLMultiline(s string) string {
-	// For multiline content in literal block scalar, we don't need to escape
-	return s
+// yamlTimestamp matches the start of a YAML timestamp, which a plain scalar
+// is read as when it begins with a date
+var yamlTimestamp = regexp.MustCompile(`^[0-9]{4}-[0-9]{1,2}-[0-9]{1,2}`)
+
+// yamlNonString reports whether s, written as a plain scalar, would be read
+// back as something other than a string: a bool, null, number, or timestamp.
+// YAML 1.1 forms such as yes and off are included, since older parsers
+// still resolve them.
+func yamlNonString(s string) bool {
+	switch strings.ToLower(s) {
+	case "~", "null", "true", "false", "yes", "no", "y", "n", "on", "off",
+		".inf", "+.inf", "-.inf", ".nan":
+		return true
+	}
+	if _, err := strconv.ParseInt(s, 0, 64); err == nil {
+		return true
+	}
+	if _, err := strconv.ParseFloat(s, 64); err == nil {
+		return true
+	}
+	return yamlTimestamp.MatchString(s)
+}
+
+// blockScalarHeader returns the literal block indicator for s. YAML infers
+// the indentation of a block scalar from its first non-empty line, so when s
+// starts with a blank or indented line the indentation (2 beyond the "query"
+// key) must be stated explicitly or the leading whitespace would be lost or
+// rejected.
+func blockScalarHeader(s string) string {
+	first, _, _ := strings.Cut(s, "\n")
+	if strings.TrimSpace(first) == "" || strings.HasPrefix(first, " ") || strings.HasPrefix(first, "\t") {
+		return "|2"
+	}
+	return "|"
+}
+
+// writeBlockScalar writes s as the value of key in a literal block scalar,
+// with each line indented by indent. The value always reads back as s
+// without trailing whitespace, plus exactly one newline: trailing blank
+// lines would be chomped, so they are trimmed first. Text a block scalar
+// cannot hold, such as a carriage return or other control character, is
+// written as a double-quoted scalar with escapes instead.
+func writeBlockScalar(w io.Writer, key, indent, s string) {
+	s = blockScalarValue(s)
+	if !literalSafe(s) {
+		fmt.Fprintf(w, "%s: %s\n", key, strconv.Quote(s))
+		return
+	}
+	body := strings.TrimSuffix(s, "\n")
+	fmt.Fprintf(w, "%s: %s\n", key, blockScalarHeader(body))
+	for _, line := range strings.Split(body, "\n") {
+		fmt.Fprintf(w, "%s%s\n", indent, line)
+	}
+}
+
+// blockScalarValue is what writeBlockScalar's output decodes to
+func blockScalarValue(s string) string {
+	return strings.TrimRight(s, " \t\r\n") + "\n"
+}
+
+// literalSafe reports whether a literal block scalar can hold s unchanged.
+// YAML reads \r, NEL, and the Unicode line and paragraph separators as line
+// breaks, and forbids other control characters and the byte order mark.
+func literalSafe(s string) bool {
+	for _, r := range s {
+		switch {
+		case r == '\t' || r == '\n':
+		case r < 0x20 || r == 0x7f || (r >= 0x80 && r <= 0x9f):
+			return false
+		case r == '\u2028' || r == '\u2029' || r == '\ufeff':
+			return false
+		}
+	}
+	return true
 }
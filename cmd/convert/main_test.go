@@ -0,0 +1,497 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// fixtureQuery is a complete query file using the common headers
+const fixtureQuery = `-- Detects a shell spawned by a network daemon
+-- platform: posix
+-- tags: process
+-- interval: 300
+-- references:
+--   * https://attack.mitre.org/techniques/T1059/004/
+SELECT p.pid, p.name
+FROM processes p
+WHERE p.name = 'sh';
+`
+
+// parseTestQuery parses content as the query file at path, a slash-separated
+// path whose first element is the category, and returns the query with the
+// warnings it produced
+func parseTestQuery(t *testing.T, path, content string) (Query, []string) {
+	t.Helper()
+	return parseTestQueryOpts(t, path, content, parseOptions{EmptyDescription: "name", SortTags: true})
+}
+
+// parseTestQueryOpts is parseTestQuery with options; opts.Warn is replaced
+func parseTestQueryOpts(t *testing.T, path, content string, opts parseOptions) (Query, []string) {
+	t.Helper()
+	var warnings []string
+	opts.Warn = func(format string, args ...any) {
+		warnings = append(warnings, strings.TrimSpace(fmt.Sprintf(format, args...)))
+	}
+	category, _, _ := strings.Cut(path, "/")
+	q, err := parseQueryReader(strings.NewReader(content), path, category, category, opts)
+	if err != nil {
+		t.Fatalf("parsing %s: %v", path, err)
+	}
+	return q, warnings
+}
+
+// emittedDoc is the part of an emitted query document the tests inspect
+type emittedDoc struct {
+	APIVersion string `yaml:"apiVersion"`
+	Kind       string `yaml:"kind"`
+	Metadata   struct {
+		Annotations map[string]string `yaml:"annotations"`
+	} `yaml:"metadata"`
+	Spec map[string]any `yaml:"spec"`
+}
+
+// emitTestQuery writes q with writeQueryYAML and decodes the result
+func emitTestQuery(t *testing.T, q Query) (emittedDoc, string) {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := writeQueryYAML(&buf, q); err != nil {
+		t.Fatalf("emitting %s: %v", q.Name, err)
+	}
+	var doc emittedDoc
+	if err := yaml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("emitted YAML does not parse: %v\n%s", err, buf.String())
+	}
+	return doc, buf.String()
+}
+
+func TestLabelsEmitted(t *testing.T) {
+	q, warnings := parseTestQuery(t, "detection/execution/2-shell.sql", `-- Unexpected shell
+-- labels: production, linux-servers, production
+SELECT pid FROM processes
+`)
+	if len(warnings) > 0 {
+		t.Errorf("unexpected warnings: %q", warnings)
+	}
+	if got := strings.Join(q.Labels, ","); got != "production,linux-servers" {
+		t.Errorf("labels = %s, want production,linux-servers", got)
+	}
+
+	doc, text := emitTestQuery(t, q)
+	labels, _ := doc.Spec["labels_include_any"].([]any)
+	if len(labels) != 2 || labels[0] != "production" || labels[1] != "linux-servers" {
+		t.Errorf("labels_include_any = %v in:\n%s", doc.Spec["labels_include_any"], text)
+	}
+
+	// Without labels the key is left out, so the query targets every host
+	q, _ = parseTestQuery(t, "detection/execution/2-shell.sql", "-- Unexpected shell\nSELECT pid FROM processes\n")
+	if doc, text := emitTestQuery(t, q); doc.Spec["labels_include_any"] != nil {
+		t.Errorf("labels_include_any emitted without labels:\n%s", text)
+	}
+}
+
+// listFiles returns the slash-separated paths of the files under dir,
+// relative to it, in lexical order
+func listFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		files = append(files, filepath.ToSlash(rel))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// docNames returns the spec names of the documents in a query YAML file
+func docNames(t *testing.T, filename string) []string {
+	t.Helper()
+	docs, err := loadCombinedDocs(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, doc := range docs {
+		names = append(names, doc.name)
+	}
+	return names
+}
+
+// quietTest silences progress output and warnings for the rest of the test
+func quietTest(tb testing.TB) {
+	saved := quiet
+	quiet = true
+	tb.Cleanup(func() { quiet = saved })
+}
+
+func TestJitteredInterval(t *testing.T) {
+	offsets := map[int]bool{}
+	for i := 0; i < 200; i++ {
+		q := Query{Name: fmt.Sprintf("[detection/execution] Query %d", i), Interval: 300, IntervalJitter: 30}
+		got := jitteredInterval(q)
+		if got < 300 || got > 330 {
+			t.Fatalf("%s: interval %d outside [300, 330]", q.Name, got)
+		}
+		if again := jitteredInterval(q); again != got {
+			t.Fatalf("%s: interval %d, then %d", q.Name, got, again)
+		}
+		offsets[got-300] = true
+	}
+	// 200 names should land on most of the 31 offsets, or nothing is spread
+	if len(offsets) < 20 {
+		t.Errorf("200 queries used only %d distinct offsets", len(offsets))
+	}
+
+	for _, q := range []Query{
+		{Name: "no jitter", Interval: 300},
+		{Name: "on demand", Interval: 0, IntervalJitter: 30},
+	} {
+		if got := jitteredInterval(q); got != q.Interval {
+			t.Errorf("%s: interval %d, want %d unchanged", q.Name, got, q.Interval)
+		}
+	}
+}
+
+func TestIntervalJitterHeader(t *testing.T) {
+	q, warnings := parseTestQuery(t, "detection/execution/2-shell.sql", "-- Shell\n-- interval: 300\n-- interval_jitter: 30\nSELECT 1\n")
+	if q.IntervalJitter != 30 || len(warnings) > 0 {
+		t.Errorf("jitter = %d with warnings %q, want 30", q.IntervalJitter, warnings)
+	}
+	doc, _ := emitTestQuery(t, q)
+	if got := doc.Spec["interval"]; got != jitteredInterval(q) {
+		t.Errorf("emitted interval %v, want %d", got, jitteredInterval(q))
+	}
+
+	_, warnings = parseTestQuery(t, "detection/execution/2-shell.sql", "-- Shell\n-- interval_jitter: -5\nSELECT 1\n")
+	if len(warnings) != 1 {
+		t.Errorf("negative jitter gave warnings %q, want one", warnings)
+	}
+}
+
+// testSource returns a source whose warnings are collected in *warnings
+func testSource(warnings *[]string) source {
+	return source{path: "test.sql", warn: func(format string, args ...any) {
+		*warnings = append(*warnings, strings.TrimSpace(fmt.Sprintf(format, args...)))
+	}}
+}
+
+// captureWarnings runs fn with output enabled and returns what warnf
+// printed to stderr, one line per element
+func captureWarnings(t *testing.T, fn func()) []string {
+	t.Helper()
+	return captureLines(t, &os.Stderr, fn)
+}
+
+// captureInfo is captureWarnings for what infof printed to stdout
+func captureInfo(t *testing.T, fn func()) []string {
+	t.Helper()
+	return captureLines(t, &os.Stdout, fn)
+}
+
+func captureLines(t *testing.T, file **os.File, fn func()) []string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	savedFile, savedQuiet := *file, quiet
+	*file, quiet = w, false
+	done := make(chan []byte)
+	go func() {
+		out, _ := io.ReadAll(r)
+		done <- out
+	}()
+
+	fn()
+	*file, quiet = savedFile, savedQuiet
+	w.Close()
+	out := strings.TrimSpace(string(<-done))
+	r.Close()
+	if out == "" {
+		return nil
+	}
+	return strings.Split(out, "\n")
+}
+
+func TestRegexps(t *testing.T) {
+	tests := []struct {
+		name  string
+		re    *regexp.Regexp
+		input string
+		want  []string // submatches; nil = no match
+	}{
+		{"levelRegex", levelRegex, "1-unexpected-shell.sql", []string{"1", "unexpected-shell"}},
+		{"levelRegex", levelRegex, "unexpected-shell.sql", nil},
+		{"levelRegex", levelRegex, "12-shell.sql", nil},
+		{"headerRegex", headerRegex, "-- tags: process", []string{"tags", "process"}},
+		{"headerRegex", headerRegex, "--interval:300", []string{"interval", "300"}},
+		{"headerRegex", headerRegex, "-- Detects a shell: sometimes", nil},
+		{"includeRegex", includeRegex, "-- include: common/users.sql", []string{"common/users.sql"}},
+		{"includeRegex", includeRegex, "-- include: a.sql b.sql", nil},
+		{"techniqueRegex", techniqueRegex, "https://attack.mitre.org/techniques/T1059/004/", []string{"1059", "004"}},
+		{"techniqueRegex", techniqueRegex, "see T1053.003", []string{"1053", "003"}},
+		{"techniqueRegex", techniqueRegex, "T10590", nil},
+		{"techniqueIDRegex", techniqueIDRegex, "T1059.004", []string{".004"}},
+		{"techniqueIDRegex", techniqueIDRegex, "T1059/004", nil},
+		{"versionConstraintRegex", versionConstraintRegex, ">=13.0", []string{">=", ".0"}},
+		{"versionConstraintRegex", versionConstraintRegex, "5.2.1", []string{"", ".1"}},
+		{"versionConstraintRegex", versionConstraintRegex, "~>13", nil},
+	}
+	for _, tt := range tests {
+		m := tt.re.FindStringSubmatch(tt.input)
+		if tt.want == nil {
+			if m != nil {
+				t.Errorf("%s matches %q", tt.name, tt.input)
+			}
+			continue
+		}
+		if m == nil {
+			t.Errorf("%s does not match %q", tt.name, tt.input)
+			continue
+		}
+		if got := strings.Join(m[1:], "|"); got != strings.Join(tt.want, "|") {
+			t.Errorf("%s on %q captured %q, want %q", tt.name, tt.input, m[1:], tt.want)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	q, warnings := parseTestQuery(t, "detection/execution/2-shell.sql", fixtureQuery)
+	if len(warnings) > 0 {
+		t.Errorf("unexpected warnings: %q", warnings)
+	}
+	if q.Platform != "darwin,linux" || q.Interval != 300 || q.Level != 2 || !containsString(q.Techniques, "T1059.004") {
+		t.Errorf("fixture parsed incorrectly: %+v", q)
+	}
+
+	doc, text := emitTestQuery(t, q)
+	if doc.Kind != "query" || doc.Spec["name"] != q.Name || doc.Spec["query"] != q.Query+"\n" {
+		t.Errorf("emitted YAML does not match the parsed query:\n%s", text)
+	}
+	if doc.Spec["platform"] != "darwin,linux" || doc.Spec["interval"] != 300 {
+		t.Errorf("platform or interval not emitted:\n%s", text)
+	}
+}
+
+// runConvert runs the converter as the command line would with args, quietly
+// and with a fresh flag set, and restores the settings run changes
+func runConvert(t *testing.T, args ...string) error {
+	t.Helper()
+	savedArgs, savedFlags := os.Args, flag.CommandLine
+	savedPosix, savedDirectives := posixPlatforms, directives
+	savedSingle, savedMaxDocs, savedHeader := singleDocument, maxDocsPerFile, headerComment
+	savedQuiet, savedVerbose, savedWarnings := quiet, verbose, parseWarnings
+	savedAPIVersion := apiVersion
+	t.Cleanup(func() {
+		os.Args, flag.CommandLine = savedArgs, savedFlags
+		posixPlatforms, directives = savedPosix, savedDirectives
+		singleDocument, maxDocsPerFile, headerComment = savedSingle, savedMaxDocs, savedHeader
+		quiet, verbose, parseWarnings = savedQuiet, savedVerbose, savedWarnings
+		apiVersion = savedAPIVersion
+		written.files = nil
+	})
+
+	os.Args = append([]string{"convert", "-quiet"}, args...)
+	flag.CommandLine = flag.NewFlagSet("convert", flag.ContinueOnError)
+	flag.CommandLine.SetOutput(io.Discard)
+	return run()
+}
+
+// writeUpstream writes files, keyed by slash-separated path, into a new
+// upstream directory and returns it
+func writeUpstream(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestStripBoilerplate(t *testing.T) {
+	const banner = `/\* generated by [a-z]+ \*/\s*`
+	tests := []struct {
+		name, prefix, suffix, query, want string
+		warnings                          int
+	}{
+		{"prefix", banner, "", "/* generated by tool */\nSELECT pid FROM processes", "SELECT pid FROM processes", 0},
+		{"suffix", "", `\s*-- end of query`, "SELECT pid FROM processes\n-- end of query", "SELECT pid FROM processes", 0},
+		{"both", banner, `;\s*-- end`, "/* generated by tool */ SELECT 1; -- end", "SELECT 1", 0},
+		{"CTE header", `WITH boilerplate AS \(SELECT 1\)\s*`, "", "WITH boilerplate AS (SELECT 1)\nSELECT pid FROM processes", "SELECT pid FROM processes", 0},
+		// Only at the expected position: the same text elsewhere is kept
+		{"prefix not at start", banner, "", "SELECT pid /* generated by tool */ FROM processes", "SELECT pid /* generated by tool */ FROM processes", 0},
+		{"suffix not at end", "", `-- end of query`, "SELECT pid -- end of query\nFROM processes", "SELECT pid -- end of query\nFROM processes", 0},
+		{"no match", banner, "", "SELECT 1", "SELECT 1", 0},
+		{"nothing configured", "", "", "SELECT 1", "SELECT 1", 0},
+		// A pattern covering the whole query is reported and not applied
+		{"would empty the query", `.*`, "", "SELECT 1", "SELECT 1", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prefix, err := stripPattern(tt.prefix, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			suffix, err := stripPattern(tt.suffix, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var warnings []string
+			if got := stripBoilerplate(testSource(&warnings), tt.query, prefix, suffix); got != tt.want || len(warnings) != tt.warnings {
+				t.Errorf("stripped to %q with warnings %q, want %q and %d warnings", got, warnings, tt.want, tt.warnings)
+			}
+		})
+	}
+
+	if _, err := stripPattern("(unclosed", false); err == nil {
+		t.Error("invalid expression compiled")
+	}
+
+	// Applied to the body only, after the headers are read
+	opts := parseOptions{EmptyDescription: "name"}
+	opts.StripPrefix, _ = stripPattern(banner, false)
+	q, _ := parseTestQueryOpts(t, "detection/execution/2-shell.sql", "-- Shell\n-- interval: 60\n/* generated by tool */\nSELECT pid FROM processes\n", opts)
+	if q.Query != "SELECT pid FROM processes" || q.Interval != 60 {
+		t.Errorf("parsed query %q, interval %d", q.Query, q.Interval)
+	}
+}
+
+func TestAPIVersion(t *testing.T) {
+	q, _ := parseTestQuery(t, "detection/execution/2-shell.sql", fixtureQuery)
+	label := q
+	label.As = "label"
+	for _, q := range []Query{q, label} {
+		if doc, text := emitTestQuery(t, q); doc.APIVersion != "v1" {
+			t.Errorf("kind %s: apiVersion = %q, want v1:\n%s", doc.Kind, doc.APIVersion, text)
+		}
+	}
+
+	// The items of a -single-document list carry it too
+	saved := singleDocument
+	singleDocument = true
+	defer func() { singleDocument = saved }()
+	var buf bytes.Buffer
+	if err := writeQueryDocuments(&buf, []Query{q, label}); err != nil {
+		t.Fatal(err)
+	}
+	var docs []emittedDoc
+	if err := yaml.Unmarshal(buf.Bytes(), &docs); err != nil {
+		t.Fatalf("single document does not parse: %v\n%s", err, buf.String())
+	}
+	if len(docs) != 2 || docs[0].APIVersion != "v1" || docs[1].APIVersion != "v1" {
+		t.Errorf("single document items = %+v", docs)
+	}
+}
+
+func TestAPIVersionFlag(t *testing.T) {
+	savedVersions := fleetAPIVersions
+	t.Cleanup(func() { fleetAPIVersions = savedVersions })
+	// A second version, as Fleet would add one
+	fleetAPIVersions = []string{"v1", "v2"}
+
+	upstream := writeUpstream(t, map[string]string{
+		"detection/execution/2-shell.sql": fixtureQuery,
+		"policy/2-shell.sql":              "-- as: label\n" + fixtureQuery,
+	})
+	for _, tt := range []struct{ flag, want string }{{"", "v1"}, {"v2", "v2"}} {
+		output := t.TempDir()
+		args := []string{"-upstream", upstream, "-output", output}
+		if tt.flag != "" {
+			args = append(args, "-api-version", tt.flag)
+		}
+		if err := runConvert(t, args...); err != nil {
+			t.Fatal(err)
+		}
+		docs, err := loadCombinedDocs(filepath.Join(output, "chainguard-all.yml"))
+		if err != nil || len(docs) != 2 {
+			t.Fatalf("%d documents, error %v", len(docs), err)
+		}
+		for _, d := range docs {
+			var doc emittedDoc
+			if err := yaml.Unmarshal([]byte(d.text), &doc); err != nil {
+				t.Fatal(err)
+			}
+			if doc.APIVersion != tt.want {
+				t.Errorf("-api-version %q: %s %s has apiVersion %q, want %s", tt.flag, doc.Kind, d.name, doc.APIVersion, tt.want)
+			}
+		}
+	}
+	err := runConvert(t, "-upstream", upstream, "-output", t.TempDir(), "-api-version", "v3")
+	if err == nil || !strings.Contains(err.Error(), `unknown -api-version "v3" (want v1, v2)`) {
+		t.Errorf("-api-version v3 gave %v", err)
+	}
+}
+
+func TestBannerComments(t *testing.T) {
+	plain, _ := parseTestQuery(t, "detection/execution/2-shell.sql", fixtureQuery)
+	banners := map[string]string{
+		"framed": `-- ==========================================
+-- Detects a shell spawned by a network daemon
+-- ------------------------------------------
+-- platform: posix
+-- tags: process
+-- interval: 300
+-- references:
+--   * https://attack.mitre.org/techniques/T1059/004/
+-- ==========================================
+SELECT p.pid, p.name
+FROM processes p
+WHERE p.name = 'sh';
+`,
+		"hash rules": "-- ####\n-- Detects a shell spawned by a network daemon\n-- ####\n-- platform: posix\n-- tags: process\n-- interval: 300\n" +
+			"-- references:\n--   * https://attack.mitre.org/techniques/T1059/004/\nSELECT p.pid, p.name\nFROM processes p\nWHERE p.name = 'sh';\n",
+	}
+	for name, content := range banners {
+		q, warnings := parseTestQuery(t, "detection/execution/2-shell.sql", content)
+		if len(warnings) > 0 {
+			t.Errorf("%s: unexpected warnings: %q", name, warnings)
+		}
+		if q.Description != plain.Description || q.LongDescription != plain.LongDescription || q.Query != plain.Query || q.Platform != plain.Platform {
+			t.Errorf("%s: parsed as %+v,\nwithout rules as %+v", name, q, plain)
+		}
+	}
+
+	for line, want := range map[string]bool{
+		"-- ======": true, "--########": true, "  -- ***** ": true, "-- ~~~~": true,
+		frontMatterFence: false, "-- ===": false, "-- -- not a rule": false, "-- ==== x": false, "====": false,
+	} {
+		if got := isBanner(line); got != want {
+			t.Errorf("isBanner(%q) = %t, want %t", line, got, want)
+		}
+	}
+
+	tests := []struct{ lines, want string }{
+		{"-- ####\n-- Shell names\n-- ####\nSELECT 1", "SELECT 1"},
+		{"-- ####\n-- Shell names\n-- ####\n\nSELECT 1", "SELECT 1"},
+		// Comments after the last rule are kept
+		{"-- ====\n-- ====\n-- process names\nSELECT 1", "-- process names\nSELECT 1"},
+		{"-- Shell names\n-- ####\nSELECT 1", "-- Shell names\n-- ####\nSELECT 1"},
+		{"SELECT 1\n-- ####", "SELECT 1\n-- ####"},
+	}
+	for _, tt := range tests {
+		if got := strings.Join(trimBanner(strings.Split(tt.lines, "\n")), "\n"); got != tt.want {
+			t.Errorf("trimBanner(%q) = %q, want %q", tt.lines, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Metadata precedence, highest first:
+//
+//  1. CLI overrides: the fixed interval of the -5min/-10min files and
+//     -tags-as-labels, the only sources at precedenceCLI
+//  2. Scheduling policy (-schedule)
+//  3. Level tiers (-tier-intervals)
+//  4. In-SQL headers (-- interval:, -- platform:, ...)
+//  5. Filename-derived values (level prefix, generated name)
+//  6. Defaults (-policy-interval, -team, -auto-logging, -category-map,
+//     -category-limit), which only fill unset values
+//
+// parseQuery settles 4 over 5 while reading the file. 1, 2, 3, and 6 are
+// metadataSources applied by resolveMetadata.
+const (
+	precedenceDefault = iota + 1
+	precedenceTier
+	precedenceSchedule
+	precedenceCLI
+)
+
+// metadataSource overrides query metadata from outside the SQL file
+type metadataSource interface {
+	precedence() int
+	apply(q *Query)
+}
+
+// resolveMetadata returns q with all sources applied, lowest precedence
+// first so that higher-precedence sources win regardless of argument order
+func resolveMetadata(q Query, sources ...metadataSource) Query {
+	ordered := append([]metadataSource(nil), sources...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].precedence() < ordered[j].precedence()
+	})
+
+	for _, s := range ordered {
+		s.apply(&q)
+	}
+	return q
+}
+
+// fixedInterval forces every query to the same interval
+type fixedInterval int
+
+func (fixedInterval) precedence() int { return precedenceCLI }
+
+func (f fixedInterval) apply(q *Query) {
+	if f > 0 {
+		q.Interval = int(f)
+		q.IntervalSet = true
+	}
+}
+
+// policyInterval is the default check cadence for policy queries that set
+// neither -- check_every: nor -- interval:, including an explicit 0
+type policyInterval int
+
+func (policyInterval) precedence() int { return precedenceDefault }
+
+func (p policyInterval) apply(q *Query) {
+	if p > 0 && q.Category == "policy" && !q.IntervalSet {
+		q.Interval = int(p)
+		q.IntervalSet = true
+	}
+}
+
+// defaultTeam assigns queries without a -- team: header to a Fleet team
+type defaultTeam string
+
+func (defaultTeam) precedence() int { return precedenceDefault }
+
+func (t defaultTeam) apply(q *Query) {
+	if q.Team == "" {
+		q.Team = string(t)
+	}
+}
+
+// tagsAsLabels adds a query's tags to the labels that scope which hosts run
+// it, for fleets whose label names match the tag vocabulary
+type tagsAsLabels struct{}
+
+func (tagsAsLabels) precedence() int { return precedenceCLI }
+
+func (tagsAsLabels) apply(q *Query) {
+	labels := append([]string(nil), q.Labels...)
+	for _, tag := range q.Tags {
+		// A confidence: tag describes the query, not the hosts to run it on
+		if !strings.HasPrefix(tag, confidenceTagPrefix) {
+			labels = append(labels, tag)
+		}
+	}
+	q.Labels = normalizeList(labels)
+}
+
+// tierIntervals schedules detections by level, e.g. 3=300,2=900,1=3600.
+// It ranks below -schedule, so a scheduling policy entry still wins for a
+// specific query. Level 0 queries are left alone.
+type tierIntervals map[int]int
+
+func (tierIntervals) precedence() int { return precedenceTier }
+
+func (t tierIntervals) apply(q *Query) {
+	if interval, ok := t[q.Level]; ok && q.Level > 0 {
+		q.Interval = interval
+		q.IntervalSet = true
+	}
+}
+
+// parseTierIntervals parses a comma-separated list of level=seconds pairs
+func parseTierIntervals(value string) (tierIntervals, error) {
+	tiers := tierIntervals{}
+	for _, pair := range strings.Split(value, ",") {
+		levelText, intervalText, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		level, err := strconv.Atoi(strings.TrimSpace(levelText))
+		if !ok || err != nil || level < 1 || level > 9 {
+			return nil, fmt.Errorf("%q is not level=seconds with a level from 1 to 9", pair)
+		}
+		interval, err := strconv.Atoi(strings.TrimSpace(intervalText))
+		if err != nil || interval <= 0 {
+			return nil, fmt.Errorf("interval for level %d must be a positive number of seconds, got %q", level, intervalText)
+		}
+		if _, dup := tiers[level]; dup {
+			return nil, fmt.Errorf("level %d is listed twice", level)
+		}
+		tiers[level] = interval
+	}
+	return tiers, nil
+}
+
+// categoryLimits caps the rows returned by queries of a category, e.g.
+// incident_response=10000. Like other defaults it only fills what is unset:
+// queries with their own LIMIT, or that aggregate to a single row, are left
+// alone, and so are those with several statements, since a LIMIT would cap
+// only the last.
+type categoryLimits map[string]int
+
+func (categoryLimits) precedence() int { return precedenceDefault }
+
+func (c categoryLimits) apply(q *Query) {
+	limit, ok := c[q.Category]
+	if !ok {
+		return
+	}
+	if _, statements := trimStatement(q.Query); statements != 1 {
+		return
+	}
+	tokens := tokenizeSQL(q.Query)
+	if hasOuterLimit(tokens) || singleRow(tokens) {
+		return
+	}
+	// Right after the last token, so a trailing comment still ends the query
+	end := tokenEnd(q.Query, tokens[len(tokens)-1])
+	q.Query = fmt.Sprintf("%s\nLIMIT %d%s", q.Query[:end], limit, q.Query[end:])
+}
+
+// parseCategoryLimits parses a comma-separated list of category=rows pairs,
+// each naming one of categories
+func parseCategoryLimits(value string, categories []string) (categoryLimits, error) {
+	limits := categoryLimits{}
+	for _, pair := range strings.Split(value, ",") {
+		category, rowsText, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		category = strings.TrimSpace(category)
+		if !ok || !containsString(categories, category) {
+			return nil, fmt.Errorf("%q is not category=rows with a category of %s", pair, strings.Join(categories, ", "))
+		}
+		rows, err := strconv.Atoi(strings.TrimSpace(rowsText))
+		if err != nil || rows <= 0 {
+			return nil, fmt.Errorf("limit for %s must be a positive number of rows, got %q", category, rowsText)
+		}
+		if _, dup := limits[category]; dup {
+			return nil, fmt.Errorf("%s is listed twice", category)
+		}
+		limits[category] = rows
+	}
+	return limits, nil
+}
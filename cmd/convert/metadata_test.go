@@ -0,0 +1,334 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestIntervalPrecedence(t *testing.T) {
+	header := Query{Name: "Unexpected Shell", Category: "detection", Level: 3, Tags: []string{"process"}, Interval: 3600, IntervalSet: true}
+	unset := Query{Name: "No Interval", Category: "policy"}
+
+	schedule := schedulePolicy{Queries: map[string]int{"Unexpected Shell": 120}}
+	tiers := tierIntervals{3: 300}
+
+	tests := []struct {
+		name    string
+		query   Query
+		sources []metadataSource
+		want    int
+	}{
+		{"header alone", header, nil, 3600},
+		{"default does not replace a header", header, []metadataSource{policyInterval(60)}, 3600},
+		{"default fills an unset interval", unset, []metadataSource{policyInterval(60)}, 60},
+		{"tier over header", header, []metadataSource{tiers}, 300},
+		{"schedule over tier", header, []metadataSource{tiers, schedule}, 120},
+		{"schedule over tier in either order", header, []metadataSource{schedule, tiers}, 120},
+		{"CLI over schedule", header, []metadataSource{fixedInterval(600), schedule, tiers}, 600},
+		{"CLI over schedule in either order", header, []metadataSource{schedule, tiers, fixedInterval(600)}, 600},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := resolveMetadata(tt.query, tt.sources...); got.Interval != tt.want {
+				t.Errorf("interval = %d, want %d", got.Interval, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrecedenceLevelsDistinct(t *testing.T) {
+	seen := map[int]string{}
+	for name, s := range map[string]metadataSource{
+		"default":  policyInterval(0),
+		"tier":     tierIntervals{},
+		"schedule": schedulePolicy{},
+		"CLI":      fixedInterval(0),
+	} {
+		if other, ok := seen[s.precedence()]; ok {
+			t.Errorf("%s and %s share precedence %d", name, other, s.precedence())
+		}
+		seen[s.precedence()] = name
+	}
+}
+
+// TestPrecedenceMatrix applies every combination of interval sources, in
+// both argument orders, to a query with and without a header interval. The
+// highest-precedence source present wins; the default only fills an unset
+// interval.
+func TestPrecedenceMatrix(t *testing.T) {
+	layers := []struct {
+		name   string
+		source metadataSource
+		value  int
+	}{
+		// Lowest precedence first
+		{"default", policyInterval(10), 10},
+		{"tier", tierIntervals{2: 20}, 20},
+		{"schedule", schedulePolicy{Tags: map[string]int{"process": 30}}, 30},
+		{"CLI", fixedInterval(40), 40},
+	}
+
+	for mask := 0; mask < 1<<len(layers); mask++ {
+		for _, header := range []bool{false, true} {
+			q := Query{Name: "[policy] Matrix", Category: "policy", Level: 2, Tags: []string{"process"}}
+			want, desc := 0, "none"
+			if header {
+				q.Interval, q.IntervalSet = 5, true
+				want, desc = 5, "header"
+			}
+
+			var sources []metadataSource
+			for i, layer := range layers {
+				if mask&(1<<i) == 0 {
+					continue
+				}
+				sources = append(sources, layer.source)
+				desc += "+" + layer.name
+				if layer.name != "default" || !header {
+					want = layer.value
+				}
+			}
+
+			reversed := make([]metadataSource, len(sources))
+			for i, s := range sources {
+				reversed[len(sources)-1-i] = s
+			}
+			for _, order := range [][]metadataSource{sources, reversed} {
+				if got := resolveMetadata(q, order...).Interval; got != want {
+					t.Errorf("%s: interval = %d, want %d", desc, got, want)
+				}
+			}
+		}
+	}
+}
+
+// TestHeaderOverFilename checks the layer parseQuery settles itself: in-SQL
+// headers win over values derived from the filename
+func TestHeaderOverFilename(t *testing.T) {
+	derived, _ := parseTestQuery(t, "detection/execution/3-unexpected-shell.sql", "-- Shell\nSELECT 1\n")
+	named, _ := parseTestQuery(t, "detection/execution/3-unexpected-shell.sql", "-- Shell\n-- query_name: Daemon Shell\nSELECT 1\n")
+	if derived.Name != "[detection/execution] Unexpected Shell" || named.Name != "Daemon Shell" {
+		t.Errorf("names = %q without -- query_name:, %q with it", derived.Name, named.Name)
+	}
+	if named.Level != 3 {
+		t.Errorf("level = %d, want 3 from the filename", named.Level)
+	}
+}
+
+func TestTagsAsLabelsFlag(t *testing.T) {
+	upstream := writeUpstream(t, map[string]string{
+		"detection/execution/2-shell.sql": "-- Shell\n-- tags: linux process\n-- labels: servers\n-- confidence: high\nSELECT 1\n",
+	})
+
+	labels := func(args ...string) []any {
+		t.Helper()
+		output := t.TempDir()
+		if err := runConvert(t, append([]string{"-upstream", upstream, "-output", output}, args...)...); err != nil {
+			t.Fatal(err)
+		}
+		docs, err := loadCombinedDocs(filepath.Join(output, "chainguard-detection.yml"))
+		if err != nil || len(docs) != 1 {
+			t.Fatalf("%d documents, error %v", len(docs), err)
+		}
+		var doc emittedDoc
+		if err := yaml.Unmarshal([]byte(docs[0].text), &doc); err != nil {
+			t.Fatal(err)
+		}
+		// Tags stay descriptive annotations either way
+		if !strings.Contains(doc.Metadata.Annotations["tags"], "linux") {
+			t.Errorf("tags annotation = %q", doc.Metadata.Annotations["tags"])
+		}
+		got, _ := doc.Spec["labels_include_any"].([]any)
+		return got
+	}
+
+	if got := labels(); fmt.Sprint(got) != "[servers]" {
+		t.Errorf("without -tags-as-labels, labels = %v, want only [servers]", got)
+	}
+	// Confidence tags describe the query, not hosts
+	if got := labels("-tags-as-labels"); fmt.Sprint(got) != "[servers linux process]" {
+		t.Errorf("with -tags-as-labels, labels = %v, want [servers linux process]", got)
+	}
+}
+
+func TestTierIntervals(t *testing.T) {
+	tiers, err := parseTierIntervals("3=300, 2=900,1 = 3600")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		query Query
+		want  int
+	}{
+		{"level 3", Query{Level: 3}, 300},
+		{"level 2", Query{Level: 2}, 900},
+		{"level 1", Query{Level: 1}, 3600},
+		{"over a header interval", Query{Level: 3, Interval: 86400, IntervalSet: true}, 300},
+		{"over an explicit on-demand 0", Query{Level: 2, IntervalSet: true}, 900},
+		// Level 0 queries and levels without a tier keep their own interval
+		{"level 0", Query{Interval: 120, IntervalSet: true}, 120},
+		{"level 0 without an interval", Query{}, 0},
+		{"unlisted level", Query{Level: 4, Interval: 60, IntervalSet: true}, 60},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.query.Name, tt.query.Category = "[detection/execution] Shell", "detection"
+			if got := resolveMetadata(tt.query, tiers); got.Interval != tt.want {
+				t.Errorf("interval = %d, want %d", got.Interval, tt.want)
+			}
+		})
+	}
+
+	for _, value := range []string{"", "3", "0=300", "10=300", "x=300", "3=0", "3=-1", "3=soon", "3=300,3=600"} {
+		if _, err := parseTierIntervals(value); err == nil {
+			t.Errorf("parseTierIntervals(%q) succeeded", value)
+		}
+	}
+}
+
+func TestTierIntervalsFlag(t *testing.T) {
+	upstream := writeUpstream(t, map[string]string{
+		"detection/execution/3-shell.sql": "-- Shell\n-- interval: 86400\nSELECT 1\n",
+		"detection/execution/1-cron.sql":  "-- Cron\nSELECT 2\n",
+		"detection/execution/listing.sql": "-- Listing\n-- interval: 120\nSELECT 3\n",
+	})
+	output := t.TempDir()
+	if err := runConvert(t, "-upstream", upstream, "-output", output, "-tier-intervals", "3=300,1=3600"); err != nil {
+		t.Fatal(err)
+	}
+	docs, err := loadCombinedDocs(filepath.Join(output, "chainguard-detection.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int{
+		"[detection/execution] Shell":   300,
+		"[detection/execution] Cron":    3600,
+		"[detection/execution] Listing": 120,
+	}
+	if len(docs) != len(want) {
+		t.Fatalf("%d documents, want %d", len(docs), len(want))
+	}
+	for _, d := range docs {
+		var doc emittedDoc
+		if err := yaml.Unmarshal([]byte(d.text), &doc); err != nil {
+			t.Fatal(err)
+		}
+		if got := doc.Spec["interval"]; got != want[d.name] {
+			t.Errorf("%s: interval %v, want %d", d.name, got, want[d.name])
+		}
+	}
+}
+
+func TestTeam(t *testing.T) {
+	upstream := writeUpstream(t, map[string]string{
+		"detection/execution/2-shell.sql": "-- Shell\n-- team: Workstations\nSELECT 1\n",
+		"detection/c2/2-dns.sql":          "-- DNS\nSELECT 2\n",
+		"policy/ssh-root.sql":             "-- Root SSH\n-- team: Servers: EU\nSELECT 3\n",
+	})
+
+	// teams returns the spec team of each query by source file name
+	teams := func(args ...string) map[string]any {
+		t.Helper()
+		output := t.TempDir()
+		if err := runConvert(t, append([]string{"-upstream", upstream, "-output", output}, args...)...); err != nil {
+			t.Fatal(err)
+		}
+		docs, err := loadCombinedDocs(filepath.Join(output, "chainguard-all.yml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := map[string]any{}
+		for _, d := range docs {
+			var doc emittedDoc
+			if err := yaml.Unmarshal([]byte(d.text), &doc); err != nil {
+				t.Fatal(err)
+			}
+			got[d.name] = doc.Spec["team"]
+		}
+		return got
+	}
+
+	// The header overrides -team, which fills in the rest
+	want := map[string]any{"[detection/execution] Shell": "Workstations", "[detection/c2] Dns": "Servers", "[policy] Ssh Root": "Servers: EU"}
+	if got := teams("-team", "Servers"); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("with -team, teams = %v, want %v", got, want)
+	}
+	// Without -team a query without the header is global
+	want["[detection/c2] Dns"] = nil
+	if got := teams(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("without -team, teams = %v, want %v", got, want)
+	}
+
+	// GitOps leaves the team to the team file referencing the query
+	output := t.TempDir()
+	if err := runConvert(t, "-upstream", upstream, "-output", output, "-format", "gitops", "-team", "Servers"); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range listFiles(t, output) {
+		data, err := os.ReadFile(filepath.Join(output, file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(data), "team:") {
+			t.Errorf("%s has a team:\n%s", file, data)
+		}
+	}
+}
+
+func TestCategoryLimits(t *testing.T) {
+	limits := categoryLimits{"incident_response": 100}
+	tests := []struct {
+		name, category, query, want string
+	}{
+		{"appended", "incident_response", "SELECT * FROM users", "SELECT * FROM users\nLIMIT 100"},
+		{"own LIMIT", "incident_response", "SELECT * FROM users LIMIT 5", "SELECT * FROM users LIMIT 5"},
+		{"own lowercase LIMIT with OFFSET", "incident_response", "SELECT * FROM users limit 5 offset 10", "SELECT * FROM users limit 5 offset 10"},
+		{"LIMIT only in a subquery", "incident_response", "SELECT * FROM users WHERE uid IN (SELECT uid FROM processes LIMIT 5)",
+			"SELECT * FROM users WHERE uid IN (SELECT uid FROM processes LIMIT 5)\nLIMIT 100"},
+		{"LIMIT in a string", "incident_response", "SELECT * FROM users WHERE shell != 'LIMIT 5'", "SELECT * FROM users WHERE shell != 'LIMIT 5'\nLIMIT 100"},
+		{"single-row aggregate", "incident_response", "SELECT count(*) FROM users", "SELECT count(*) FROM users"},
+		{"grouped aggregate", "incident_response", "SELECT shell, count(*) FROM users GROUP BY shell", "SELECT shell, count(*) FROM users GROUP BY shell\nLIMIT 100"},
+		{"aggregate only in a subquery", "incident_response", "SELECT * FROM users WHERE uid > (SELECT max(uid) FROM processes)",
+			"SELECT * FROM users WHERE uid > (SELECT max(uid) FROM processes)\nLIMIT 100"},
+		{"other category", "detection", "SELECT * FROM users", "SELECT * FROM users"},
+		{"trailing line comment", "incident_response", "SELECT * FROM users -- every account", "SELECT * FROM users\nLIMIT 100 -- every account"},
+		{"trailing comment lines", "incident_response", "SELECT *\nFROM users\n-- no filter\n/* on purpose */", "SELECT *\nFROM users\nLIMIT 100\n-- no filter\n/* on purpose */"},
+		{"ends in a string", "incident_response", "SELECT * FROM users WHERE shell = '/bin/sh' -- x", "SELECT * FROM users WHERE shell = '/bin/sh'\nLIMIT 100 -- x"},
+		{"ends in a quoted identifier", "incident_response", "SELECT * FROM \"users\"", "SELECT * FROM \"users\"\nLIMIT 100"},
+		{"ends in a parenthesis", "incident_response", "SELECT * FROM users WHERE uid IN (0, 1)", "SELECT * FROM users WHERE uid IN (0, 1)\nLIMIT 100"},
+		{"several statements", "incident_response", "SELECT * FROM users; SELECT * FROM groups", "SELECT * FROM users; SELECT * FROM groups"},
+		{"union of aggregates", "incident_response", "SELECT count(*) FROM users UNION ALL SELECT count(*) FROM groups",
+			"SELECT count(*) FROM users UNION ALL SELECT count(*) FROM groups"},
+		{"union with an unaggregated branch", "incident_response", "SELECT count(*) FROM users UNION SELECT gid FROM groups",
+			"SELECT count(*) FROM users UNION SELECT gid FROM groups\nLIMIT 100"},
+		{"unaggregated branch first", "incident_response", "SELECT gid FROM groups EXCEPT SELECT max(gid) FROM groups",
+			"SELECT gid FROM groups EXCEPT SELECT max(gid) FROM groups\nLIMIT 100"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := Query{Category: tt.category, Query: tt.query}
+			limits.apply(&q)
+			if q.Query != tt.want {
+				t.Errorf("query became %q, want %q", q.Query, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCategoryLimits(t *testing.T) {
+	limits, err := parseCategoryLimits("incident_response=10000, policy = 50", defaultCategories)
+	if err != nil || fmt.Sprint(limits) != "map[incident_response:10000 policy:50]" {
+		t.Errorf("limits = %v, %v", limits, err)
+	}
+	for _, value := range []string{"incident_response", "hunting=10", "policy=0", "policy=-1", "policy=many", "policy=1,policy=2", ""} {
+		if limits, err := parseCategoryLimits(value, defaultCategories); err == nil {
+			t.Errorf("-category-limit %q accepted as %v", value, limits)
+		}
+	}
+}
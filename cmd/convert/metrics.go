@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"time"
+)
+
+// writeMetrics writes gauges about the run in the Prometheus text exposition
+// format, for CI to scrape or push. Metric names are part of the interface;
+// don't rename them.
+func writeMetrics(filename string, queries []Query, categories []string, duration time.Duration) error {
+	counts := map[string]int{}
+	confidence := map[string]int{}
+	for _, q := range queries {
+		counts[q.Category]++
+		if q.Category == "detection" {
+			confidence[q.Confidence]++
+		}
+	}
+
+	return writeFileAtomic(filename, func(w io.Writer) error {
+		bw := bufio.NewWriter(w)
+
+		fmt.Fprintf(bw, "# HELP defensekit_queries_total Queries in the converted catalog.\n")
+		fmt.Fprintf(bw, "# TYPE defensekit_queries_total gauge\n")
+		for _, category := range categories {
+			fmt.Fprintf(bw, "defensekit_queries_total{category=%q} %d\n", category, counts[category])
+		}
+
+		fmt.Fprintf(bw, "# HELP defensekit_detections_by_confidence Detections by -- confidence: header; unset when there is none.\n")
+		fmt.Fprintf(bw, "# TYPE defensekit_detections_by_confidence gauge\n")
+		for _, level := range append(confidenceLevels, "") {
+			label := level
+			if label == "" {
+				label = "unset"
+			}
+			fmt.Fprintf(bw, "defensekit_detections_by_confidence{confidence=%q} %d\n", label, confidence[level])
+		}
+
+		fmt.Fprintf(bw, "# HELP defensekit_parse_warnings_total Warnings reported while parsing query files.\n")
+		fmt.Fprintf(bw, "# TYPE defensekit_parse_warnings_total gauge\n")
+		fmt.Fprintf(bw, "defensekit_parse_warnings_total %d\n", parseWarnings)
+
+		fmt.Fprintf(bw, "# HELP defensekit_run_duration_seconds Wall-clock duration of the conversion.\n")
+		fmt.Fprintf(bw, "# TYPE defensekit_run_duration_seconds gauge\n")
+		fmt.Fprintf(bw, "defensekit_run_duration_seconds %.3f\n", duration.Seconds())
+
+		return bw.Flush()
+	})
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	savedWarnings := parseWarnings
+	parseWarnings = 4
+	t.Cleanup(func() { parseWarnings = savedWarnings })
+
+	queries := []Query{
+		{Name: "A", Category: "detection", Confidence: "high"},
+		{Name: "B", Category: "detection"},
+		{Name: "C", Category: "policy", Confidence: "low"},
+	}
+	filename := filepath.Join(t.TempDir(), "convert.prom")
+	if err := writeMetrics(filename, queries, defaultCategories, 1500*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The names and labels are an interface, so the whole file is pinned
+	want := `# HELP defensekit_queries_total Queries in the converted catalog.
+# TYPE defensekit_queries_total gauge
+defensekit_queries_total{category="detection"} 2
+defensekit_queries_total{category="policy"} 1
+defensekit_queries_total{category="incident_response"} 0
+# HELP defensekit_detections_by_confidence Detections by -- confidence: header; unset when there is none.
+# TYPE defensekit_detections_by_confidence gauge
+defensekit_detections_by_confidence{confidence="low"} 0
+defensekit_detections_by_confidence{confidence="medium"} 0
+defensekit_detections_by_confidence{confidence="high"} 1
+defensekit_detections_by_confidence{confidence="unset"} 1
+# HELP defensekit_parse_warnings_total Warnings reported while parsing query files.
+# TYPE defensekit_parse_warnings_total gauge
+defensekit_parse_warnings_total 4
+# HELP defensekit_run_duration_seconds Wall-clock duration of the conversion.
+# TYPE defensekit_run_duration_seconds gauge
+defensekit_run_duration_seconds 1.500
+`
+	if string(data) != want {
+		t.Errorf("metrics file:\n%s\nwant:\n%s", data, want)
+	}
+
+	// Every line follows the text exposition format: HELP and TYPE come
+	// once per family before its samples, and samples are name, labels,
+	// value
+	var (
+		help   = regexp.MustCompile(`^# HELP ([a-zA-Z_:][a-zA-Z0-9_:]*) \S.*$`)
+		typ    = regexp.MustCompile(`^# TYPE ([a-zA-Z_:][a-zA-Z0-9_:]*) (counter|gauge|histogram|summary|untyped)$`)
+		sample = regexp.MustCompile(`^([a-zA-Z_:][a-zA-Z0-9_:]*)(\{[a-zA-Z_][a-zA-Z0-9_]*="(?:[^"\\\n]|\\.)*"(?:,[a-zA-Z_][a-zA-Z0-9_]*="(?:[^"\\\n]|\\.)*")*\})? -?[0-9]+(?:\.[0-9]+)?$`)
+	)
+	if !strings.HasSuffix(string(data), "\n") {
+		t.Error("file does not end with a newline")
+	}
+	seen, samples := map[string]bool{}, map[string]bool{}
+	var family string
+	for i, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		switch {
+		case help.MatchString(line):
+			family = help.FindStringSubmatch(line)[1]
+			if seen[family] {
+				t.Errorf("line %d: %s described twice", i+1, family)
+			}
+			seen[family] = true
+		case typ.MatchString(line):
+			if name := typ.FindStringSubmatch(line)[1]; name != family {
+				t.Errorf("line %d: TYPE for %s under HELP for %s", i+1, name, family)
+			}
+		case sample.MatchString(line):
+			if name := sample.FindStringSubmatch(line)[1]; name != family {
+				t.Errorf("line %d: sample of %s in family %s", i+1, name, family)
+			}
+			series, _, _ := strings.Cut(line, " ")
+			if samples[series] {
+				t.Errorf("line %d: duplicate series %s", i+1, series)
+			}
+			samples[series] = true
+		default:
+			t.Errorf("line %d is not valid exposition format: %q", i+1, line)
+		}
+	}
+}
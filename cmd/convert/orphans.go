@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// orphanedSubcategories lists the subcategory directories under upstreamDir,
+// as category/subcategory, that none of queries came from. Such a directory
+// holds only non-.sql files or queries that all failed to parse.
+func orphanedSubcategories(upstreamDir string, categories []string, queries []Query) ([]string, error) {
+	found := map[string]bool{}
+	for _, q := range queries {
+		if q.Subcategory != "" {
+			found[q.Category+"/"+q.Subcategory] = true
+		}
+	}
+
+	var orphans []string
+	for _, category := range categories {
+		entries, err := os.ReadDir(filepath.Join(upstreamDir, category))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") && !found[category+"/"+entry.Name()] {
+				orphans = append(orphans, category+"/"+entry.Name())
+			}
+		}
+	}
+	sort.Strings(orphans)
+	return orphans, nil
+}
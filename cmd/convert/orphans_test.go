@@ -0,0 +1,40 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOrphanedSubcategories(t *testing.T) {
+	upstream := writeUpstream(t, map[string]string{
+		// Notes only and a query that fails to parse; hidden directories are skipped
+		"detection/notes/README.md":              "Queries moved to execution/\n",
+		"detection/broken/2-unclosed.sql":        frontMatterFence + "\nplatform: linux\n",
+		"detection/execution/2-shell.sql":        fixtureQuery,
+		"detection/execution/linux/2-other.sql":  fixtureQuery,
+		"policy/.git/HEAD":                       "ref: refs/heads/main\n",
+		"incident_response/persistence/1-ir.sql": fixtureQuery,
+	})
+	queries, err := parseAllQueries(upstream, parseOptions{Warn: func(string, ...any) {}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	orphans, err := orphanedSubcategories(upstream, defaultCategories, queries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"detection/broken", "detection/notes"}; !reflect.DeepEqual(orphans, want) {
+		t.Errorf("orphaned subcategories are %q, want %q", orphans, want)
+	}
+
+	// Only the given categories are looked at
+	if orphans, err := orphanedSubcategories(upstream, []string{"incident_response"}, queries); err != nil || len(orphans) > 0 {
+		t.Errorf("incident_response orphans are %q, %v", orphans, err)
+	}
+
+	err = runConvert(t, "-upstream", upstream, "-output", t.TempDir(), "-strict")
+	if err == nil || !strings.Contains(err.Error(), "2 subcategory directories have no valid queries") {
+		t.Errorf("-strict with orphans gave %v", err)
+	}
+}
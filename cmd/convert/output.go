@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// Output verbosity, set from -quiet and -verbose. Errors are always printed.
+var (
+	quiet   bool
+	verbose bool
+)
+
+// infof prints progress to stdout unless -quiet is set
+func infof(format string, args ...any) {
+	if !quiet {
+		fmt.Printf(format, args...)
+	}
+}
+
+// warnf prints a warning to stderr unless -quiet is set
+func warnf(format string, args ...any) {
+	if !quiet {
+		fmt.Fprintf(os.Stderr, "Warning: "+format, args...)
+	}
+}
+
+// debugf prints detail to stdout only under -verbose
+func debugf(format string, args ...any) {
+	if verbose {
+		fmt.Printf(format, args...)
+	}
+}
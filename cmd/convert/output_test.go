@@ -0,0 +1,96 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWritePlatformDirs(t *testing.T) {
+	quietTest(t)
+	queries := []Query{
+		{Name: "[detection/execution] Shell", Query: "SELECT 1", Category: "detection", Platform: "darwin,linux"},
+		{Name: "[detection/c2] Dns", Query: "SELECT 2", Category: "detection", Platform: "linux"},
+		{Name: "[detection/c2] Anywhere", Query: "SELECT 3", Category: "detection"},
+		{Name: "[policy] Firewall", Query: "SELECT 4", Category: "policy", Platform: "windows"},
+	}
+	dir := t.TempDir()
+	if err := writePlatformDirs(queries, dir, "category"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"common/chainguard-all.yml",
+		"common/chainguard-detection-5min.yml",
+		"common/chainguard-detection.yml",
+		"darwin/chainguard-all.yml",
+		"darwin/chainguard-detection-5min.yml",
+		"darwin/chainguard-detection.yml",
+		"linux/chainguard-all.yml",
+		"linux/chainguard-detection-5min.yml",
+		"linux/chainguard-detection.yml",
+		"windows/chainguard-all.yml",
+		"windows/chainguard-policy.yml",
+	}
+	if got := listFiles(t, dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("files = %v\nwant %v", got, want)
+	}
+
+	// Multi-platform queries are duplicated into each platform
+	for platform, names := range map[string][]string{
+		"common":  {"[detection/c2] Anywhere"},
+		"darwin":  {"[detection/execution] Shell"},
+		"linux":   {"[detection/execution] Shell", "[detection/c2] Dns"},
+		"windows": {"[policy] Firewall"},
+	} {
+		if got := docNames(t, filepath.Join(dir, platform, "chainguard-all.yml")); !reflect.DeepEqual(got, names) {
+			t.Errorf("%s/chainguard-all.yml holds %v, want %v", platform, got, names)
+		}
+	}
+}
+
+func TestFormatList(t *testing.T) {
+	upstream := writeUpstream(t, map[string]string{
+		"detection/2-shell.sql": fixtureQuery,
+		"policy/2-shell.sql":    fixtureQuery,
+	})
+
+	// Every format in one run writes each format's files
+	output := t.TempDir()
+	if err := runConvert(t, "-upstream", upstream, "-output", output, "-format", strings.Join(outputFormats, " , ")); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{
+		"chainguard-all.yml",
+		"chainguard-catalog.db",
+		"chainguard-pack.json",
+		"chainguard-queries.tf",
+		"queries/detection-shell.yml",
+		"chainguard-siem-rules.ndjson",
+		"rego/policy-shell.rego",
+	} {
+		if _, err := os.Stat(filepath.Join(output, filepath.FromSlash(name))); err != nil {
+			t.Errorf("-format %s left out %s; wrote %q", strings.Join(outputFormats, ","), name, listFiles(t, output))
+		}
+	}
+
+	// A repeated format is written once, and unlisted ones not at all
+	output = t.TempDir()
+	if err := runConvert(t, "-upstream", upstream, "-output", output, "-format", "rego,rego"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := listFiles(t, output), []string{"rego/.manifest", "rego/policy-shell.rego"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("-format rego,rego wrote %q, want %q", got, want)
+	}
+
+	for value, want := range map[string]string{
+		"yaml,json": `unknown -format "json"`,
+		" , ":       "-format needs at least one of",
+	} {
+		if err := runConvert(t, "-upstream", upstream, "-output", t.TempDir(), "-format", value); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("-format %q gave %v, want %s", value, err, want)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// defaultPackInterval is used for queries without an interval, since osquery
+// packs require one
+const defaultPackInterval = 3600
+
+// osqueryPack is the classic osquery pack format consumed by osqueryd
+type osqueryPack struct {
+	Queries map[string]packQuery `json:"queries"`
+}
+
+type packQuery struct {
+	Query       string `json:"query"`
+	Interval    int    `json:"interval"`
+	Platform    string `json:"platform,omitempty"`
+	Description string `json:"description,omitempty"`
+	Snapshot    bool   `json:"snapshot,omitempty"`
+	Denylist    *bool  `json:"denylist,omitempty"`
+}
+
+// writeOsqueryPack writes all queries as a single osquery pack keyed by name
+// and returns the number of queries in it, which leaves out on-demand ones
+func writeOsqueryPack(queries []Query, filename string) (int, error) {
+	pack := osqueryPack{Queries: map[string]packQuery{}}
+	for _, q := range queries {
+		// Packs would schedule an on-demand query at the default interval
+		if q.IntervalSet && q.Interval == 0 {
+			debugf("Leaving on-demand %s out of the pack\n", q.Name)
+			continue
+		}
+
+		interval := jitteredInterval(q)
+		if interval <= 0 {
+			interval = defaultPackInterval
+		}
+
+		pack.Queries[q.Name] = packQuery{
+			Query:       q.Query,
+			Interval:    interval,
+			Platform:    q.Platform,
+			Description: packDescription(q),
+			Snapshot:    loggingFor(q) == "snapshot",
+			Denylist:    q.Denylist,
+		}
+	}
+
+	err := writeFileAtomic(filename, func(w io.Writer) error {
+		return writeJSON(w, pack)
+	})
+	return len(pack.Queries), err
+}
+
+// writeJSON writes v as indented JSON without HTML escaping, so SQL
+// comparisons like "> 120" stay readable
+func writeJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// packDescription folds the category and level into the description, since
+// packs have no other place for them
+func packDescription(q Query) string {
+	if q.Level > 0 {
+		return fmt.Sprintf("%s (%s, level %d)", q.Description, q.Category, q.Level)
+	}
+	return fmt.Sprintf("%s (%s)", q.Description, q.Category)
+}
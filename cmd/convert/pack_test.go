@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteOsqueryPack(t *testing.T) {
+	denylist := false
+	queries := []Query{
+		{Name: "[detection/execution] Shell", Description: "Unexpected shell", Query: "SELECT 1", Platform: "darwin,linux",
+			Category: "detection", Level: 3, Interval: 300, IntervalSet: true, Denylist: &denylist},
+		{Name: "[incident_response] Users", Description: "Local users", Query: "SELECT 2", Category: "incident_response"},
+		{Name: "[detection/c2] On Demand", Description: "Run by hand", Query: "SELECT 3", Category: "detection", IntervalSet: true},
+	}
+	filename := filepath.Join(t.TempDir(), "pack.json")
+	n, err := writeOsqueryPack(queries, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("wrote %d queries, want 2 without the on-demand one", n)
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Decode loosely so that unexpected keys and types show up
+	var pack map[string]map[string]map[string]any
+	if err := json.Unmarshal(data, &pack); err != nil {
+		t.Fatalf("pack is not JSON of the expected shape: %v\n%s", err, data)
+	}
+	if len(pack) != 1 || len(pack["queries"]) != 2 {
+		t.Fatalf("pack = %s", data)
+	}
+
+	allowed := map[string]bool{"query": true, "interval": true, "platform": true, "description": true, "snapshot": true, "denylist": true}
+	for name, q := range pack["queries"] {
+		for key := range q {
+			if !allowed[key] {
+				t.Errorf("%s has unexpected key %q", name, key)
+			}
+		}
+		if _, ok := q["query"].(string); !ok {
+			t.Errorf("%s: query is %T, want a string", name, q["query"])
+		}
+		if _, ok := q["interval"].(float64); !ok {
+			t.Errorf("%s: interval is %T, want a number", name, q["interval"])
+		}
+	}
+
+	shell := pack["queries"]["[detection/execution] Shell"]
+	if shell["interval"] != 300.0 || shell["platform"] != "darwin,linux" || shell["description"] != "Unexpected shell (detection, level 3)" ||
+		shell["denylist"] != false {
+		t.Errorf("shell entry = %v", shell)
+	}
+	users := pack["queries"]["[incident_response] Users"]
+	if users["interval"] != float64(defaultPackInterval) || users["snapshot"] != true || users["description"] != "Local users (incident_response)" {
+		t.Errorf("users entry = %v", users)
+	}
+}
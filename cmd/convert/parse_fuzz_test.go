@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// fuzzSeeds are representative query files: header lines, front matter,
+// banners, includes, and malformed headers
+var fuzzSeeds = []string{
+	`-- Detects a shell spawned by a network daemon
+-- platform: posix
+-- tags: process
+-- interval: 300
+-- references:
+--   * https://attack.mitre.org/techniques/T1059/004/
+SELECT p.pid, p.name
+FROM processes p
+WHERE p.name = 'sh';
+`,
+	`-- ---
+-- description: Detects a shell spawned by a network daemon
+-- platform: posix
+-- tags: [process]
+-- interval: 300
+-- ---
+SELECT p.pid, p.name FROM processes p WHERE p.name = 'sh';
+`,
+	`-- ==========================================
+-- Detects crontab entries that fetch remote scripts
+-- ------------------------------------------
+-- platform: linux, darwin
+-- severity: high
+-- ==========================================
+SELECT command FROM crontab WHERE command LIKE '%curl%|%sh%'
+`,
+	`-- Possible DNS tunnel: long query
+-- summary: Long DNS names
+-- note: Expect noise on CI runners.
+-- requires: network, edr
+-- test: expect_empty_on_clean_host
+-- interval: 1,200
+SELECT * FROM dns_cache WHERE length(name) > 120
+`,
+	"-- platform: bsd\n-- interval: -5\n-- observer_can_run: maybe\n-- created: soon\nSELECT 1; SELECT 2;\n",
+	"-- ---\n-- description: unterminated\nSELECT 1\n",
+	"--\n-- \t\n\n   SELECT \"x\" AS y -- trailing\n",
+	"",
+
+	// Found by fuzzing: descriptions starting with a YAML indicator
+	"--%",
+	"--,",
+}
+
+func FuzzParseQuery(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add(seed)
+	}
+	opts := parseOptions{EmptyDescription: "name", SortTags: true, Warn: func(string, ...any) {}}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		q, err := parseQueryReader(strings.NewReader(input), "detection/execution/2-fuzz.sql", "detection", "detection", opts)
+		// YAML text is Unicode, so invalid UTF-8 cannot round-trip; checkText
+		// reports it before anything is written
+		if err != nil || !utf8.ValidString(input) {
+			return
+		}
+
+		var first, second bytes.Buffer
+		if err := writeQueryYAML(&first, q); err != nil {
+			t.Fatalf("emitting: %v", err)
+		}
+		if err := writeQueryYAML(&second, q); err != nil {
+			t.Fatalf("emitting again: %v", err)
+		}
+		if !bytes.Equal(first.Bytes(), second.Bytes()) {
+			t.Fatalf("emitting twice differs:\n%s\n---\n%s", first.String(), second.String())
+		}
+
+		var doc struct {
+			Spec struct {
+				Name  string `yaml:"name"`
+				Query string `yaml:"query"`
+			} `yaml:"spec"`
+		}
+		if err := yaml.Unmarshal(first.Bytes(), &doc); err != nil {
+			t.Fatalf("emitted YAML does not parse: %v\n%s", err, first.String())
+		}
+		if doc.Spec.Name != q.Name {
+			t.Errorf("name read back as %q, want %q", doc.Spec.Name, q.Name)
+		}
+		if strings.TrimRight(doc.Spec.Query, "\n") != q.Query {
+			t.Errorf("query read back as %q, want %q", doc.Spec.Query, q.Query)
+		}
+	})
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const pickerHelp = "↑/↓ move, space toggle, a all, n none, / select matching, enter done, q quit"
+
+// pickerChrome is the number of screen lines the picker uses besides the list
+const pickerChrome = 3
+
+// isTerminal reports whether f is attached to a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// pickQueries shows queries as a checklist in a terminal UI and returns the
+// selected ones in their original order
+func pickQueries(queries []Query, in io.Reader, out io.Writer) ([]Query, error) {
+	p := tea.NewProgram(newPickerModel(queries), tea.WithInput(in), tea.WithOutput(out), tea.WithAltScreen())
+	final, err := p.Run()
+	if err != nil {
+		return nil, err
+	}
+	m := final.(pickerModel)
+	if !m.done {
+		return nil, errors.New("selection cancelled")
+	}
+	return m.picked(), nil
+}
+
+// pickerModel is the bubbletea model behind pickQueries
+type pickerModel struct {
+	queries  []Query
+	selected []bool
+	cursor   int // index of the highlighted query
+	offset   int // index of the first query on screen
+	height   int // rows available for the list; 0 until the size is known
+
+	filtering bool   // reading a /text match
+	filter    string // text typed after /
+	message   string // shown under the list until the next key
+
+	done bool // enter was pressed with a selection
+}
+
+func newPickerModel(queries []Query) pickerModel {
+	return pickerModel{queries: queries, selected: make([]bool, len(queries))}
+}
+
+func (m pickerModel) Init() tea.Cmd {
+	return nil
+}
+
+func (m pickerModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.height = max(msg.Height-pickerChrome, 1)
+		m.scroll()
+		return m, nil
+
+	case tea.KeyMsg:
+		m.message = ""
+		if m.filtering {
+			return m.updateFilter(msg), nil
+		}
+		// Keys read together, as when typed ahead, arrive as one message
+		if msg.Type == tea.KeyRunes && len(msg.Runes) > 1 && !msg.Paste {
+			var model tea.Model = m
+			var cmd tea.Cmd
+			for _, r := range msg.Runes {
+				if model, cmd = model.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{r}}); cmd != nil {
+					return model, cmd
+				}
+			}
+			return model, nil
+		}
+
+		switch msg.String() {
+		case "ctrl+c", "q", "esc":
+			return m, tea.Quit
+		case "up", "k":
+			m.cursor--
+		case "down", "j":
+			m.cursor++
+		case "pgup":
+			m.cursor -= m.page()
+		case "pgdown":
+			m.cursor += m.page()
+		case "home", "g":
+			m.cursor = 0
+		case "end", "G":
+			m.cursor = len(m.queries) - 1
+		case " ", "x":
+			if len(m.queries) > 0 {
+				m.selected[m.cursor] = !m.selected[m.cursor]
+			}
+		case "a", "n":
+			for i := range m.selected {
+				m.selected[i] = msg.String() == "a"
+			}
+		case "/":
+			m.filtering, m.filter = true, ""
+		case "enter":
+			if len(m.picked()) == 0 {
+				m.message = "Nothing selected."
+				return m, nil
+			}
+			m.done = true
+			return m, tea.Quit
+		}
+		m.scroll()
+	}
+	return m, nil
+}
+
+// updateFilter handles a key while /text is being typed. Enter selects every
+// query whose name contains the text and moves to the first of them.
+func (m pickerModel) updateFilter(msg tea.KeyMsg) pickerModel {
+	switch msg.Type {
+	case tea.KeyEnter:
+		m.filtering = false
+		first, n := -1, 0
+		for i, q := range m.queries {
+			if m.filter != "" && strings.Contains(strings.ToLower(q.Name), strings.ToLower(m.filter)) {
+				m.selected[i] = true
+				if first < 0 {
+					first = i
+				}
+				n++
+			}
+		}
+		m.message = fmt.Sprintf("Selected %d matching %q.", n, m.filter)
+		if first >= 0 {
+			m.cursor = first
+			m.scroll()
+		}
+	case tea.KeyEsc, tea.KeyCtrlC:
+		m.filtering, m.filter = false, ""
+	case tea.KeyBackspace:
+		if r := []rune(m.filter); len(r) > 0 {
+			m.filter = string(r[:len(r)-1])
+		}
+	case tea.KeyRunes, tea.KeySpace:
+		m.filter += string(msg.Runes)
+	}
+	return m
+}
+
+// page is how far pgup and pgdown move
+func (m pickerModel) page() int {
+	return max(m.height, 1)
+}
+
+// scroll clamps the cursor and moves the visible window to keep it on screen
+func (m *pickerModel) scroll() {
+	m.cursor = min(max(m.cursor, 0), max(len(m.queries)-1, 0))
+	if m.height == 0 {
+		return
+	}
+	if m.cursor < m.offset {
+		m.offset = m.cursor
+	}
+	if m.cursor >= m.offset+m.height {
+		m.offset = m.cursor - m.height + 1
+	}
+}
+
+// picked returns the selected queries in their original order
+func (m pickerModel) picked() []Query {
+	var picked []Query
+	for i, q := range m.queries {
+		if m.selected[i] {
+			picked = append(picked, q)
+		}
+	}
+	return picked
+}
+
+func (m pickerModel) View() string {
+	var b strings.Builder
+	end := len(m.queries)
+	if m.height > 0 {
+		end = min(end, m.offset+m.height)
+	}
+	for i := m.offset; i < end; i++ {
+		cursor, mark := " ", " "
+		if i == m.cursor {
+			cursor = ">"
+		}
+		if m.selected[i] {
+			mark = "x"
+		}
+		fmt.Fprintf(&b, "%s [%s] %s\n", cursor, mark, m.queries[i].Name)
+	}
+
+	fmt.Fprintf(&b, "\n%d of %d selected", len(m.picked()), len(m.queries))
+	switch {
+	case m.filtering:
+		fmt.Fprintf(&b, "  /%s", m.filter)
+	case m.message != "":
+		fmt.Fprintf(&b, "  %s", m.message)
+	}
+	fmt.Fprintf(&b, "\n%s", pickerHelp)
+	return b.String()
+}
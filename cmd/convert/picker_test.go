@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// pickerQueries returns n queries named Query 1 to Query n
+func pickerQueries(n int) []Query {
+	queries := make([]Query, n)
+	for i := range queries {
+		queries[i] = Query{Name: "[detection/execution] Query " + string(rune('A'+i))}
+	}
+	return queries
+}
+
+// press feeds keys to m, a key name such as "down" or typed text
+func press(m pickerModel, keys ...string) pickerModel {
+	for _, key := range keys {
+		var msg tea.KeyMsg
+		switch key {
+		case "up":
+			msg = tea.KeyMsg{Type: tea.KeyUp}
+		case "down":
+			msg = tea.KeyMsg{Type: tea.KeyDown}
+		case "pgdown":
+			msg = tea.KeyMsg{Type: tea.KeyPgDown}
+		case "enter":
+			msg = tea.KeyMsg{Type: tea.KeyEnter}
+		case "esc":
+			msg = tea.KeyMsg{Type: tea.KeyEsc}
+		case "backspace":
+			msg = tea.KeyMsg{Type: tea.KeyBackspace}
+		case " ":
+			msg = tea.KeyMsg{Type: tea.KeySpace, Runes: []rune{' '}}
+		default:
+			msg = tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(key)}
+		}
+		next, _ := m.Update(msg)
+		m = next.(pickerModel)
+	}
+	return m
+}
+
+func pickedNames(m pickerModel) string {
+	var names []string
+	for _, q := range m.picked() {
+		names = append(names, strings.TrimPrefix(q.Name, "[detection/execution] Query "))
+	}
+	return strings.Join(names, ",")
+}
+
+func TestPickerToggle(t *testing.T) {
+	tests := []struct {
+		name string
+		keys []string
+		want string
+	}{
+		{"first", []string{" "}, "A"},
+		{"move and toggle", []string{"down", "down", " ", "j", "x"}, "C,D"},
+		{"toggle twice", []string{" ", " "}, ""},
+		{"cursor stops at the ends", []string{"up", " ", "G", "down", " "}, "A,E"},
+		{"all then one off", []string{"a", "down", " "}, "A,C,D,E"},
+		{"none", []string{"a", "n"}, ""},
+		{"keys typed ahead in one read", []string{"jjx"}, "C"},
+		// Picked in list order, not the order they were toggled
+		{"original order", []string{"G", " ", "g", " "}, "A,E"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pickedNames(press(newPickerModel(pickerQueries(5)), tt.keys...)); got != tt.want {
+				t.Errorf("picked %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPickerFilter(t *testing.T) {
+	queries := []Query{{Name: "Unexpected Shell"}, {Name: "SSH keys"}, {Name: "Reverse shell"}, {Name: "Cron job"}}
+	m := press(newPickerModel(queries), "/", "s", "h", "e", "l", "l", "enter")
+	if got := len(m.picked()); got != 2 || m.selected[1] || !m.selected[2] {
+		t.Errorf("/shell selected %v", m.selected)
+	}
+	if m.cursor != 0 || m.filtering {
+		t.Errorf("cursor %d, filtering %t after /shell", m.cursor, m.filtering)
+	}
+
+	// Keys that mean something else are typed while filtering
+	m = press(newPickerModel(queries), "/", "c", "r", "o", "x", "backspace", "n", "enter")
+	if !m.selected[3] || len(m.picked()) != 1 || m.cursor != 3 {
+		t.Errorf("/cron selected %v with cursor %d", m.selected, m.cursor)
+	}
+
+	m = press(newPickerModel(queries), "/", "s", "s", "h", "esc")
+	if len(m.picked()) != 0 || m.filtering {
+		t.Errorf("cancelled filter selected %v", m.selected)
+	}
+}
+
+func TestPickerScroll(t *testing.T) {
+	next, _ := newPickerModel(pickerQueries(20)).Update(tea.WindowSizeMsg{Width: 80, Height: 8})
+	m := next.(pickerModel)
+	if rows := strings.Count(m.View(), " [detection/execution] "); rows != 8-pickerChrome {
+		t.Errorf("%d rows on an 8-line screen, want %d", rows, 8-pickerChrome)
+	}
+
+	m = press(m, "pgdown", "pgdown", "down")
+	if m.cursor != 11 || m.offset != 7 {
+		t.Errorf("cursor %d at offset %d, want 11 at 7", m.cursor, m.offset)
+	}
+	if !strings.Contains(m.View(), "> [ ] [detection/execution] Query L") {
+		t.Errorf("cursor not on screen:\n%s", m.View())
+	}
+	m = press(m, "g")
+	if m.offset != 0 {
+		t.Errorf("offset %d after g, want 0", m.offset)
+	}
+}
+
+func TestPickerFinish(t *testing.T) {
+	m := press(newPickerModel(pickerQueries(3)), "enter")
+	if m.done || !strings.Contains(m.View(), "Nothing selected.") {
+		t.Errorf("enter with nothing selected finished the picker")
+	}
+
+	next, cmd := press(m, "down", " ").Update(tea.KeyMsg{Type: tea.KeyEnter})
+	if m = next.(pickerModel); !m.done || cmd == nil {
+		t.Errorf("enter with a selection did not finish")
+	}
+	if _, cmd := newPickerModel(pickerQueries(3)).Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("q")}); cmd == nil {
+		t.Errorf("q did not quit")
+	}
+}
+
+func TestPickQueries(t *testing.T) {
+	queries := pickerQueries(4)
+	var out bytes.Buffer
+	picked, err := pickQueries(queries, strings.NewReader("jjx\r"), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(picked) != 1 || picked[0].Name != queries[2].Name {
+		t.Errorf("picked %v, want %s", picked, queries[2].Name)
+	}
+
+	if _, err := pickQueries(queries, strings.NewReader(" q"), &out); err == nil {
+		t.Errorf("q did not cancel the selection")
+	}
+}
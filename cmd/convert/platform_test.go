@@ -0,0 +1,189 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNormalizePlatformList(t *testing.T) {
+	tests := []struct {
+		input, want string
+		warnings    int
+	}{
+		{"", "", 0},
+		{"linux", "linux", 0},
+		{"macos", "darwin", 0},
+		{"posix", "darwin,linux", 0},
+		{"darwin,windows", "darwin,windows", 0},
+		// Whitespace and case around entries
+		{"  darwin ,\twindows  ", "darwin,windows", 0},
+		{"Windows, MacOS", "windows,darwin", 0},
+		{"linux,,windows,", "linux,windows", 0},
+		// Duplicates, including ones an alias introduces, keep first position
+		{"linux, linux", "linux", 0},
+		{"darwin, macos", "darwin", 0},
+		{"linux,posix", "linux,darwin", 0},
+		{"windows,posix,darwin", "windows,darwin,linux", 0},
+		// Unknown entries are dropped with a warning each
+		{"linux, solaris", "linux", 1},
+		{"aix, darwin, hpux", "darwin", 2},
+		{"linx", "", 1},
+		// all is every platform, which Fleet spells as no platform at all
+		{"all", "", 0},
+		{"All", "", 0},
+		{" ALL ", "", 0},
+		// Platforms next to all are redundant and reported
+		{"all, linux", "", 1},
+		{"darwin,all,windows", "", 1},
+		{"all, linx", "", 1}, // the typo, not the redundancy
+	}
+	for _, tt := range tests {
+		var warnings []string
+		got := normalizePlatform(testSource(&warnings), tt.input)
+		if got != tt.want || len(warnings) != tt.warnings {
+			t.Errorf("%q normalized to %q with warnings %q, want %q and %d warnings", tt.input, got, warnings, tt.want, tt.warnings)
+		}
+		// Normalized output is a fixed point
+		warnings = nil
+		if again := normalizePlatform(testSource(&warnings), got); again != got || len(warnings) > 0 {
+			t.Errorf("%q is not stable: %q normalized to %q", tt.input, got, again)
+		}
+	}
+}
+
+func TestPlatformAll(t *testing.T) {
+	content := strings.Replace(fixtureQuery, "-- platform: posix", "-- platform: all", 1)
+	q, warnings := parseTestQuery(t, "detection/execution/2-shell.sql", content)
+	if q.Platform != "" || len(warnings) > 0 {
+		t.Fatalf("platform: all parsed as %q with warnings %q", q.Platform, warnings)
+	}
+	if doc, text := emitTestQuery(t, q); doc.Spec["platform"] != nil {
+		t.Errorf("platform: all emitted a platform:\n%s", text)
+	}
+}
+
+func TestNarrowPlatform(t *testing.T) {
+	tests := []struct {
+		platform, enabled, want string
+		warns                   bool
+	}{
+		{"darwin,linux", "linux", "linux", false},
+		{"darwin,linux", "", "darwin,linux", false},
+		// No platform is every platform
+		{"", "linux", "linux", false},
+		{"", "", "", false},
+		// platform's order is kept
+		{"windows,darwin,linux", "linux,darwin", "darwin,linux", false},
+		// Entries outside platform are reported
+		{"darwin,linux", "linux,windows", "linux", true},
+		// Nothing left keeps platform
+		{"darwin,linux", "windows", "darwin,linux", true},
+	}
+	for _, tt := range tests {
+		var warnings []string
+		if got := narrowPlatform(testSource(&warnings), tt.platform, tt.enabled); got != tt.want || (len(warnings) > 0) != tt.warns {
+			t.Errorf("%q narrowed by %q to %q with warnings %q, want %q", tt.platform, tt.enabled, got, warnings, tt.want)
+		}
+	}
+}
+
+func TestEnabledPlatformsHeader(t *testing.T) {
+	tests := []struct{ headers, want string }{
+		{"-- platform: posix\n-- enabled_platforms: linux\n", "linux"},
+		// Order doesn't matter: narrowing happens after every header
+		{"-- enabled_platforms: macos\n-- platform: posix\n", "darwin"},
+		{"-- platform: all\n-- enabled_platforms: windows, linux\n", "windows,linux"},
+	}
+	for _, tt := range tests {
+		q, warnings := parseTestQuery(t, "detection/execution/2-shell.sql", "-- Shell\n"+tt.headers+"SELECT 1\n")
+		if q.Platform != tt.want || len(warnings) > 0 {
+			t.Errorf("%q: platform %q with warnings %q, want %q", tt.headers, q.Platform, warnings, tt.want)
+		}
+		if doc, text := emitTestQuery(t, q); doc.Spec["platform"] != tt.want {
+			t.Errorf("%q: emitted platform %v:\n%s", tt.headers, doc.Spec["platform"], text)
+		}
+	}
+}
+
+func TestParsePosixPlatforms(t *testing.T) {
+	tests := []struct {
+		value, want string // want "" = error
+	}{
+		{"darwin,linux", "darwin,linux"},
+		{"Darwin, linux, FreeBSD", "darwin,linux,freebsd"},
+		{"linux,linux", "linux"},
+		{"posix", "posix"},
+		{"darwin,solaris", ""},
+		{"", ""},
+		{" , ", ""},
+	}
+	for _, tt := range tests {
+		got, err := parsePosixPlatforms(tt.value)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("-posix-platforms %q accepted as %q", tt.value, got)
+			}
+			continue
+		}
+		if err != nil || strings.Join(got, ",") != tt.want {
+			t.Errorf("-posix-platforms %q = %q, %v; want %s", tt.value, got, err, tt.want)
+		}
+	}
+}
+
+func TestPosixExpansion(t *testing.T) {
+	tests := []struct {
+		name     string
+		posix    []string
+		platform string
+		want     string
+	}{
+		{"default", []string{"darwin", "linux"}, "posix", "darwin,linux"},
+		{"with freebsd", []string{"darwin", "linux", "freebsd"}, "posix", "darwin,linux,freebsd"},
+		{"merged with a list", []string{"linux", "freebsd"}, "windows,posix,linux", "windows,linux,freebsd"},
+		{"kept literal", []string{"posix"}, "posix,windows", "posix,windows"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			saved := posixPlatforms
+			posixPlatforms = tt.posix
+			defer func() { posixPlatforms = saved }()
+			var warnings []string
+			if got := normalizePlatform(testSource(&warnings), tt.platform); got != tt.want || len(warnings) > 0 {
+				t.Errorf("%q = %q with warnings %q, want %q", tt.platform, got, warnings, tt.want)
+			}
+		})
+	}
+}
+
+func TestPosixPlatformsFlag(t *testing.T) {
+	upstream := writeUpstream(t, map[string]string{"detection/execution/2-shell.sql": fixtureQuery})
+	for _, tt := range []struct{ flag, want string }{
+		{"", "darwin,linux"},
+		{"darwin,linux,freebsd", "darwin,linux,freebsd"},
+		{"posix", "posix"},
+	} {
+		output := t.TempDir()
+		args := []string{"-upstream", upstream, "-output", output}
+		if tt.flag != "" {
+			args = append(args, "-posix-platforms", tt.flag)
+		}
+		if err := runConvert(t, args...); err != nil {
+			t.Fatalf("-posix-platforms %q: %v", tt.flag, err)
+		}
+		data, err := os.ReadFile(filepath.Join(output, "chainguard-all.yml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(data), "\n  platform: "+tt.want+"\n") {
+			t.Errorf("-posix-platforms %q: platform is not %s:\n%s", tt.flag, tt.want, data)
+		}
+	}
+
+	err := runConvert(t, "-upstream", upstream, "-output", t.TempDir(), "-posix-platforms", "darwin,solaris")
+	if err == nil || !strings.Contains(err.Error(), `unknown platform "solaris"`) {
+		t.Errorf("invalid -posix-platforms gave %v", err)
+	}
+}
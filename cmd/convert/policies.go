@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// writePolicyYAML writes a query marked -- as: policy as a Fleet policy.
+// Upstream policy queries return rows when a host fails, while a Fleet
+// policy passes when its query returns rows, so the SQL is wrapped to pass
+// exactly when the upstream query finds nothing.
+func writePolicyYAML(w io.Writer, q Query) error {
+	fmt.Fprintf(w, "apiVersion: %s\n", apiVersion)
+	io.WriteString(w, "kind: policy\n")
+	writeAnnotations(w, queryAnnotations(q))
+	io.WriteString(w, "spec:\n")
+	fmt.Fprintf(w, "  name: %s\n", escapeYAML(q.Name))
+	fmt.Fprintf(w, "  description: %s\n", escapeYAML(q.Description))
+
+	writeBlockScalar(w, "  query", "    ", policyQuery(q.Query))
+
+	if q.Platform != "" {
+		fmt.Fprintf(w, "  platform: %s\n", q.Platform)
+	}
+	if q.Team != "" {
+		fmt.Fprintf(w, "  team: %s\n", escapeYAML(q.Team))
+	}
+	if q.Runbook != "" {
+		fmt.Fprintf(w, "  resolution: %s\n", escapeYAML("See "+q.Runbook))
+	}
+	if len(q.Labels) > 0 {
+		io.WriteString(w, "  labels_include_any:\n")
+		for _, label := range q.Labels {
+			fmt.Fprintf(w, "    - %s\n", escapeYAML(label))
+		}
+	}
+	return nil
+}
+
+// policyQuery wraps a query that returns rows on failure so it returns a
+// row on success. The closing parenthesis goes on its own line in case the
+// query ends in a comment.
+func policyQuery(query string) string {
+	return "SELECT 1 WHERE NOT EXISTS (\n" + blockScalarValue(query) + ")"
+}
+
+// emittedQuery is the SQL a document for q carries
+func emittedQuery(q Query) string {
+	if q.As == "policy" {
+		return policyQuery(q.Query)
+	}
+	return q.Query
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPolicyEmitted(t *testing.T) {
+	q, warnings := parseTestQuery(t, "policy/ssh-root-login.sql", `-- SSH permits root login
+-- as: policy
+-- triage: https://runbooks.example.com/ssh
+-- platform: linux
+SELECT * FROM augeas WHERE path = '/etc/ssh/sshd_config' AND label = 'PermitRootLogin' AND value = 'yes' -- upstream fails on any row
+;`)
+	if len(warnings) > 0 {
+		t.Fatalf("unexpected warnings: %v", warnings)
+	}
+	doc, text := emitTestQuery(t, q)
+	if doc.Kind != "policy" || doc.APIVersion != "v1" {
+		t.Fatalf("got %s %s:\n%s", doc.APIVersion, doc.Kind, text)
+	}
+	want := "SELECT 1 WHERE NOT EXISTS (\n" + q.Query + "\n)\n"
+	if doc.Spec["query"] != want {
+		t.Errorf("policy query = %q, want %q", doc.Spec["query"], want)
+	}
+	if doc.Spec["platform"] != "linux" || doc.Spec["resolution"] != "See https://runbooks.example.com/ssh" {
+		t.Errorf("platform or resolution missing:\n%s", text)
+	}
+	for _, key := range []string{"interval", "logging", "observer_can_run", "automations_enabled"} {
+		if _, ok := doc.Spec[key]; ok {
+			t.Errorf("query-only field %s in a policy:\n%s", key, text)
+		}
+	}
+}
+
+// TestPolicyQueryInverts runs wrapped queries to check that a policy passes
+// exactly where the upstream query returns nothing
+func TestPolicyQueryInverts(t *testing.T) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "policy.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	tests := []struct {
+		query string
+		pass  bool
+	}{
+		{"SELECT 1 WHERE 0", true},
+		{"SELECT 1", false},
+		{"SELECT 1 -- a trailing comment", false},
+		{"SELECT 1 WHERE 0\n-- a trailing comment", true},
+	}
+	for _, tt := range tests {
+		rows, err := db.Query(policyQuery(tt.query))
+		if err != nil {
+			t.Fatalf("%q: %v", policyQuery(tt.query), err)
+		}
+		pass := rows.Next()
+		rows.Close()
+		if pass != tt.pass {
+			t.Errorf("policy for %q passes = %t, want %t", tt.query, pass, tt.pass)
+		}
+	}
+}
+
+func TestCategoryMapPolicy(t *testing.T) {
+	upstream := writeUpstream(t, map[string]string{
+		"policy/ssh.sql":                  "-- Root login allowed\nSELECT 1 FROM users WHERE uid = 0;\n",
+		"detection/execution/2-shell.sql": fixtureQuery,
+	})
+	mapPath := filepath.Join(t.TempDir(), "categories.json")
+	if err := os.WriteFile(mapPath, []byte(`{"policy": {"kind": "policy"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	output := t.TempDir()
+	if err := runConvert(t, "-upstream", upstream, "-output", output, "-category-map", mapPath, "-format", "yaml,osquery-pack"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(output, "chainguard-policy.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "kind: policy\n") || !strings.Contains(string(data), "SELECT 1 WHERE NOT EXISTS (\n") {
+		t.Errorf("mapped category not emitted as policies:\n%s", data)
+	}
+	pack, err := os.ReadFile(filepath.Join(output, "chainguard-pack.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(pack), "uid = 0") {
+		t.Errorf("pack should skip policies:\n%s", pack)
+	}
+}
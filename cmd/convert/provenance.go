@@ -0,0 +1,171 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// licenseFiles are the upstream files searched, in order, for its license
+var licenseFiles = []string{"LICENSE", "LICENSE.md", "LICENSE.txt", "COPYING"}
+
+// licenseMarkers map phrases from common license texts to SPDX identifiers.
+// Every phrase must appear; earlier entries win, so BSD-3-Clause is tried
+// before the BSD-2-Clause text it contains.
+var licenseMarkers = []struct {
+	spdx    string
+	phrases []string
+}{
+	{"Apache-2.0", []string{"Apache License", "Version 2.0"}},
+	{"MIT", []string{"Permission is hereby granted, free of charge"}},
+	{"BSD-3-Clause", []string{"Redistribution and use in source and binary forms", "Neither the name"}},
+	{"BSD-2-Clause", []string{"Redistribution and use in source and binary forms"}},
+	{"MPL-2.0", []string{"Mozilla Public License Version 2.0"}},
+	{"GPL-3.0", []string{"GNU GENERAL PUBLIC LICENSE", "Version 3"}},
+	{"GPL-2.0", []string{"GNU GENERAL PUBLIC LICENSE", "Version 2"}},
+}
+
+var spdxRegex = regexp.MustCompile(`SPDX-License-Identifier:\s*(\S+)`)
+
+// detectLicense returns the SPDX identifier of the license file in dir and
+// whether dir has one. The identifier is "" when the text is not recognized.
+func detectLicense(dir string) (string, bool) {
+	for _, name := range licenseFiles {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		text := string(data)
+		if m := spdxRegex.FindStringSubmatch(text); m != nil {
+			return m[1], true
+		}
+		for _, marker := range licenseMarkers {
+			matched := true
+			for _, phrase := range marker.phrases {
+				matched = matched && strings.Contains(text, phrase)
+			}
+			if matched {
+				return marker.spdx, true
+			}
+		}
+		warnf("%s: license not recognized; pass -source-license\n", filepath.Join(dir, name))
+		return "", true
+	}
+	return "", false
+}
+
+// nearestLicense returns the license of the closest directory from dir up to
+// root that has a license file. Each directory's answer is kept in cache, so
+// every license file is read and reported once.
+func nearestLicense(dir, root string, cache map[string]string) string {
+	if license, ok := cache[dir]; ok {
+		return license
+	}
+	license, found := detectLicense(dir)
+	if !found {
+		// Stop at root, and for a query outside it, at dir itself
+		if rel, err := filepath.Rel(root, dir); err == nil && rel != "." && !strings.HasPrefix(rel, "..") {
+			license = nearestLicense(filepath.Dir(dir), root, cache)
+		}
+	}
+	cache[dir] = license
+	return license
+}
+
+// remoteRepository returns the origin remote of the git checkout at root as
+// host/owner/repo, e.g. github.com/chainguard-dev/osquery-defense-kit, or
+// "" when it has none
+func remoteRepository(root string) string {
+	out, err := exec.Command("git", "-C", root, "remote", "get-url", "origin").Output()
+	if err != nil {
+		return ""
+	}
+	url := strings.TrimSuffix(strings.TrimSpace(string(out)), ".git")
+	if _, rest, ok := strings.Cut(url, "://"); ok {
+		url = rest
+	} else if _, rest, ok := strings.Cut(url, "@"); ok {
+		url = strings.Replace(rest, ":", "/", 1) // git@host:owner/repo
+	}
+	if _, rest, ok := strings.Cut(url, "@"); ok {
+		url = rest // credentials in an https URL
+	}
+	return url
+}
+
+// repositories finds the origin remote of the checkout holding each query
+// directory. git is asked once per directory for its checkout root and once
+// per root for the remote. Only checkouts rooted at or under the upstream
+// count: a plain upstream directory inside some other checkout has no
+// repository of its own, rather than that checkout's.
+type repositories struct {
+	upstream string            // upstream directory, absolute with symlinks resolved as git reports roots
+	roots    map[string]string // query directory -> checkout root, "" for none
+	remotes  map[string]string // checkout root -> origin remote
+}
+
+func newRepositories(upstreamDir string) *repositories {
+	upstream, err := filepath.Abs(upstreamDir)
+	if err == nil {
+		if resolved, err := filepath.EvalSymlinks(upstream); err == nil {
+			upstream = resolved
+		}
+	}
+	return &repositories{upstream: upstream, roots: map[string]string{}, remotes: map[string]string{}}
+}
+
+// remote returns the origin remote for a query in dir, or "" when it is in
+// no checkout under the upstream or the checkout has no origin
+func (r *repositories) remote(dir string) string {
+	root, ok := r.roots[dir]
+	if !ok {
+		root = r.checkoutRoot(dir)
+		r.roots[dir] = root
+	}
+	if root == "" {
+		return ""
+	}
+	remote, ok := r.remotes[root]
+	if !ok {
+		remote = remoteRepository(root)
+		r.remotes[root] = remote
+	}
+	return remote
+}
+
+// checkoutRoot returns the top of the git checkout holding dir when it is at
+// or under the upstream, and "" otherwise
+func (r *repositories) checkoutRoot(dir string) string {
+	out, err := exec.Command("git", "-C", dir, "rev-parse", "--show-toplevel").Output()
+	if err != nil {
+		return ""
+	}
+	root := filepath.Clean(filepath.FromSlash(strings.TrimSpace(string(out))))
+	rel, err := filepath.Rel(r.upstream, root)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return ""
+	}
+	return root
+}
+
+// addProvenance records the repository and license each query came from:
+// the origin remote of the checkout under upstreamDir holding its file, and
+// the nearest license file between its directory and upstreamDir. A
+// submodule or vendored tree under the upstream thus keeps its own.
+// repository and license, when set, apply to every query instead, and git
+// is not run at all when repository is set.
+func addProvenance(queries []Query, upstreamDir, repository, license string) {
+	remotes := newRepositories(upstreamDir)
+	licenses := map[string]string{} // directory -> nearest license
+	for i, q := range queries {
+		dir := filepath.Dir(q.Path)
+		queries[i].Origin, queries[i].License = repository, license
+		if repository == "" {
+			queries[i].Origin = remotes.remote(dir)
+		}
+		if license == "" {
+			queries[i].License = nearestLicense(dir, upstreamDir, licenses)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxPushAttempts bounds retries of a rate-limited request
+const maxPushAttempts = 5
+
+// fleetClient applies query specs through the Fleet REST API
+type fleetClient struct {
+	baseURL string
+	token   string
+	dryRun  bool
+	http    *http.Client
+}
+
+func newFleetClient(baseURL, token string, dryRun bool) (*fleetClient, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, fmt.Errorf("invalid Fleet URL %q", baseURL)
+	}
+	if token == "" && !dryRun {
+		return nil, fmt.Errorf("a Fleet API token is required (-fleet-token or FLEET_API_TOKEN)")
+	}
+	return &fleetClient{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		token:   token,
+		dryRun:  dryRun,
+		http:    &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// pushQueries applies each query as its own spec so failures are reported
+// per query. Fleet creates or updates specs by name.
+func pushQueries(client *fleetClient, queries []Query) error {
+	failed := 0
+	for _, q := range queries {
+		if err := client.applyQuery(q); err != nil {
+			warnf("%s: push failed: %v\n", q.Name, err)
+			failed++
+			continue
+		}
+		debugf("Pushed %s\n", q.Name)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d queries failed to push", failed, len(queries))
+	}
+	return nil
+}
+
+func (c *fleetClient) applyQuery(q Query) error {
+	doc, err := renderDocument(q)
+	if err != nil {
+		return err
+	}
+	spec := doc.(map[string]any)["spec"]
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(map[string]any{"specs": []any{spec}}); err != nil {
+		return err
+	}
+	body := bytes.TrimSpace(buf.Bytes())
+
+	endpoint := c.baseURL + "/api/v1/fleet/spec/queries"
+	switch q.As {
+	case "label":
+		endpoint = c.baseURL + "/api/v1/fleet/spec/labels"
+	case "policy":
+		endpoint = c.baseURL + "/api/v1/fleet/spec/policies"
+	}
+	if c.dryRun {
+		fmt.Printf("POST %s %s\n", endpoint, body)
+		return nil
+	}
+
+	delay := time.Second
+	for attempt := 1; ; attempt++ {
+		req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+c.token)
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := c.http.Do(req)
+		if err != nil {
+			return err
+		}
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+
+		switch {
+		case resp.StatusCode < 300:
+			return nil
+		case resp.StatusCode == http.StatusTooManyRequests && attempt < maxPushAttempts:
+			wait := delay
+			if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+				wait = time.Duration(seconds) * time.Second
+			}
+			debugf("%s: rate limited, retrying in %s\n", q.Name, wait)
+			time.Sleep(wait)
+			delay *= 2
+		default:
+			return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeFleet applies specs by name, as Fleet does, and records which were
+// created and which updated
+type fakeFleet struct {
+	mu      sync.Mutex
+	specs   map[string]map[string]any // endpoint path + name -> spec
+	applied []string                  // "created <path> <name>" or "updated <path> <name>"
+	reject  string                    // spec name answered with 422
+}
+
+func (f *fakeFleet) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.Header.Get("Authorization") != "Bearer secret" || r.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	var body struct {
+		Specs []map[string]any `json:"specs"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || len(body.Specs) != 1 {
+		http.Error(w, "want one spec", http.StatusBadRequest)
+		return
+	}
+	spec := body.Specs[0]
+	name, _ := spec["name"].(string)
+	if name == f.reject {
+		http.Error(w, `{"message": "Validation Failed"}`, http.StatusUnprocessableEntity)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := r.URL.Path + " " + name
+	if _, ok := f.specs[key]; ok {
+		f.applied = append(f.applied, "updated "+key)
+	} else {
+		f.applied = append(f.applied, "created "+key)
+	}
+	f.specs[key] = spec
+	w.Write([]byte("{}"))
+}
+
+func newFakeFleet(t *testing.T) (*fakeFleet, *fleetClient) {
+	fleet := &fakeFleet{specs: map[string]map[string]any{}}
+	server := httptest.NewServer(fleet)
+	t.Cleanup(server.Close)
+	client, err := newFleetClient(server.URL+"/", "secret", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fleet, client
+}
+
+func TestPushCreatesAndUpdates(t *testing.T) {
+	quietTest(t)
+	fleet, client := newFakeFleet(t)
+	shell, _ := parseTestQuery(t, "detection/execution/2-shell.sql", fixtureQuery)
+	label, _ := parseTestQuery(t, "incident_response/affected-hosts.sql", "-- Hosts running the implant\n-- as: label\nSELECT 1 FROM processes WHERE name = 'implant';\n")
+	policy, _ := parseTestQuery(t, "policy/ssh.sql", "-- Root login allowed\n-- as: policy\nSELECT 1 FROM users WHERE uid = 0;\n")
+	if err := pushQueries(client, []Query{shell, label, policy}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The same name again updates the spec in place
+	changed := shell
+	changed.Interval = 60
+	if err := pushQueries(client, []Query{changed}); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"created /api/v1/fleet/spec/queries " + shell.Name,
+		"created /api/v1/fleet/spec/labels " + label.Name,
+		"created /api/v1/fleet/spec/policies " + policy.Name,
+		"updated /api/v1/fleet/spec/queries " + shell.Name,
+	}
+	if !reflect.DeepEqual(fleet.applied, want) {
+		t.Errorf("applied %q, want %q", fleet.applied, want)
+	}
+	spec := fleet.specs["/api/v1/fleet/spec/queries "+shell.Name]
+	if spec["interval"] != float64(60) || spec["query"] != shell.Query+"\n" {
+		t.Errorf("stored spec = %v, want the updated query", spec)
+	}
+	if got := fleet.specs["/api/v1/fleet/spec/policies "+policy.Name]["query"]; got != policyQuery(policy.Query)+"\n" {
+		t.Errorf("policy query = %q, want the inverted SQL", got)
+	}
+}
+
+func TestPushReportsFailures(t *testing.T) {
+	fleet, client := newFakeFleet(t)
+	shell, _ := parseTestQuery(t, "detection/execution/2-shell.sql", fixtureQuery)
+	zsh, _ := parseTestQuery(t, "detection/execution/2-zsh.sql", fixtureQuery)
+	fleet.reject = shell.Name
+
+	var err error
+	warnings := captureWarnings(t, func() { err = pushQueries(client, []Query{shell, zsh}) })
+	if err == nil || err.Error() != "1 of 2 queries failed to push" {
+		t.Errorf("error = %v, want 1 of 2 queries failed", err)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], shell.Name+": push failed: 422 Unprocessable Entity: "+`{"message": "Validation Failed"}`) {
+		t.Errorf("warnings = %q, want the status and message of the rejected query", warnings)
+	}
+	// The other query still went through
+	if want := []string{"created /api/v1/fleet/spec/queries " + zsh.Name}; !reflect.DeepEqual(fleet.applied, want) {
+		t.Errorf("applied %q, want %q", fleet.applied, want)
+	}
+}
+
+func TestNewFleetClient(t *testing.T) {
+	for _, tt := range []struct {
+		url, token string
+		dryRun     bool
+		ok         bool
+	}{
+		{"https://fleet.example.com", "secret", false, true},
+		{"https://fleet.example.com", "", true, true},
+		{"https://fleet.example.com", "", false, false},
+		{"ftp://fleet.example.com", "secret", false, false},
+		{"fleet.example.com", "secret", false, false},
+	} {
+		if _, err := newFleetClient(tt.url, tt.token, tt.dryRun); (err == nil) != tt.ok {
+			t.Errorf("newFleetClient(%q, %q, %t) error = %v, want ok %t", tt.url, tt.token, tt.dryRun, err, tt.ok)
+		}
+	}
+}
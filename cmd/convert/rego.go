@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// regoRoot is the package every generated policy lives under, and the only
+// root the bundle manifest claims
+const regoRoot = "chainguard"
+
+// regoIdentRegex matches the characters a Rego package segment can't hold
+var regoIdentRegex = regexp.MustCompile(`[^a-z0-9_]+`)
+
+// writeRegoBundle writes one Rego rule skeleton per policy query to dir,
+// as <slug>.rego, with an OPA bundle manifest. Other categories have no
+// pass/fail meaning and are skipped; the number written is returned.
+// Generated files for policies that no longer exist are removed.
+func writeRegoBundle(queries []Query, dir string) (int, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, err
+	}
+
+	current := map[string]bool{}
+	for _, q := range queries {
+		if q.Category != "policy" {
+			continue
+		}
+		filename := q.Slug + ".rego"
+		current[filename] = true
+		err := writeFileAtomic(filepath.Join(dir, filename), func(w io.Writer) error {
+			return writeRegoPolicy(w, q)
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err := writeFileAtomic(filepath.Join(dir, ".manifest"), func(w io.Writer) error {
+		return writeJSON(w, map[string][]string{"roots": {regoRoot}})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".rego") || current[entry.Name()] {
+			continue
+		}
+		debugf("Removing stale %s\n", entry.Name())
+		if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil {
+			return 0, err
+		}
+	}
+	return len(current), nil
+}
+
+// regoPackage is the package for q, under regoRoot
+func regoPackage(q Query) string {
+	return regoRoot + "." + strings.Trim(regoIdentRegex.ReplaceAllString(strings.ToLower(q.Slug), "_"), "_")
+}
+
+// writeRegoPolicy writes a deny rule for q. SQL can't be translated into
+// Rego, so the query is carried as a comment and the rule body is left for
+// the policy author; until then the rule never denies.
+func writeRegoPolicy(w io.Writer, q Query) error {
+	bw := bufio.NewWriter(w)
+
+	// OPA reads a METADATA comment block as YAML annotations on the package
+	io.WriteString(bw, "# METADATA\n")
+	fmt.Fprintf(bw, "# title: %s\n", escapeYAML(q.Name))
+	fmt.Fprintf(bw, "# description: %s\n", escapeYAML(q.Description))
+	io.WriteString(bw, "# custom:\n")
+	fmt.Fprintf(bw, "#   slug: %s\n", escapeYAML(q.Slug))
+	if q.Platform != "" {
+		fmt.Fprintf(bw, "#   platform: %s\n", escapeYAML(q.Platform))
+	}
+	if q.LongDescription != "" {
+		fmt.Fprintf(bw, "#   documentation: %s\n", escapeYAML(q.LongDescription))
+	}
+	fmt.Fprintf(bw, "package %s\n\nimport rego.v1\n\n", regoPackage(q))
+
+	io.WriteString(bw, "# Converted from an osquery policy, which fails on a host when this query\n")
+	io.WriteString(bw, "# returns rows. Replace the placeholder below with a condition on input that\n")
+	io.WriteString(bw, "# holds exactly when it would:\n#\n")
+	for _, line := range strings.Split(strings.TrimSpace(q.Query), "\n") {
+		fmt.Fprintf(bw, "#%s\n", strings.TrimRight("   "+line, " "))
+	}
+
+	message := q.Name
+	if q.Described {
+		message += ": " + q.Description
+	}
+	msg, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	io.WriteString(bw, "deny contains msg if {\n")
+	io.WriteString(bw, "\tfalse # placeholder until the query above is translated\n")
+	fmt.Fprintf(bw, "\tmsg := %s\n}\n", msg)
+
+	return bw.Flush()
+}
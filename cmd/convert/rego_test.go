@@ -0,0 +1,101 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestRegoPolicy(t *testing.T) {
+	q, _ := parseTestQuery(t, "policy/2-shell.sql", fixtureQuery)
+	var buf strings.Builder
+	if err := writeRegoPolicy(&buf, q); err != nil {
+		t.Fatal(err)
+	}
+	text := buf.String()
+	lines := strings.Split(text, "\n")
+
+	// The METADATA block runs up to the package line and must be YAML
+	var metadata []string
+	pkg := 0
+	for i, line := range lines[1:] {
+		if strings.HasPrefix(line, "package ") {
+			pkg = i + 1
+			break
+		}
+		metadata = append(metadata, strings.TrimPrefix(strings.TrimPrefix(line, "#"), " "))
+	}
+	if lines[0] != "# METADATA" || pkg == 0 {
+		t.Fatalf("rule does not open with a METADATA block and package:\n%s", text)
+	}
+	var annotations struct {
+		Title  string            `yaml:"title"`
+		Custom map[string]string `yaml:"custom"`
+	}
+	if err := yaml.Unmarshal([]byte(strings.Join(metadata, "\n")), &annotations); err != nil {
+		t.Fatalf("METADATA is not YAML: %v\n%s", err, text)
+	}
+	if annotations.Title != q.Name || annotations.Custom["slug"] != q.Slug {
+		t.Errorf("METADATA is %+v", annotations)
+	}
+	if want := "package chainguard.policy_shell"; lines[pkg] != want {
+		t.Errorf("package line is %q, want %q", lines[pkg], want)
+	}
+	for _, line := range strings.Split(q.Query, "\n") {
+		if !containsString(lines, "#   "+line) {
+			t.Errorf("query line %q is not carried as a comment", line)
+		}
+	}
+	if !containsString(lines, "deny contains msg if {") {
+		t.Errorf("no deny rule in:\n%s", text)
+	}
+}
+
+func TestRegoPackage(t *testing.T) {
+	for slug, want := range map[string]string{
+		"policy-shell":          "chainguard.policy_shell",
+		"Policy--SSH.Root":      "chainguard.policy_ssh_root",
+		"-policy-trailing-":     "chainguard.policy_trailing",
+		"policy-2fa-enrollment": "chainguard.policy_2fa_enrollment",
+	} {
+		if got := regoPackage(Query{Slug: slug}); got != want {
+			t.Errorf("regoPackage(%q) = %q, want %q", slug, got, want)
+		}
+	}
+}
+
+func TestWriteRegoBundle(t *testing.T) {
+	quietTest(t)
+	policy, _ := parseTestQuery(t, "policy/2-shell.sql", fixtureQuery)
+	detection, _ := parseTestQuery(t, "detection/execution/2-shell.sql", fixtureQuery)
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "policy-removed.rego"), []byte("package chainguard.policy_removed\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "README.md"), []byte("Generated\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := writeRegoBundle([]Query{policy, detection}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("wrote %d policies, want 1", n)
+	}
+	// Only policies get a rule, stale rules go, and other files stay
+	if got, want := listFiles(t, dir), []string{".manifest", "README.md", "policy-shell.rego"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("bundle holds %q, want %q", got, want)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, ".manifest"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"roots"`) || !strings.Contains(string(data), `"chainguard"`) {
+		t.Errorf("manifest does not claim the chainguard root:\n%s", data)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// metadataChecks report whether a query has a field -require-metadata can
+// demand
+var metadataChecks = map[string]func(q Query) bool{
+	"description": func(q Query) bool { return q.Described },
+	"interval":    func(q Query) bool { return q.IntervalSet || q.Interval > 0 },
+	"platform":    func(q Query) bool { return q.Platform != "" },
+	"severity":    func(q Query) bool { return q.Severity != "" },
+	"tags":        func(q Query) bool { return len(q.Tags) > 0 },
+	"techniques":  func(q Query) bool { return len(q.Techniques) > 0 },
+}
+
+func metadataFieldNames() []string {
+	names := make([]string, 0, len(metadataChecks))
+	for name := range metadataChecks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parseRequiredFields validates a comma-separated -require-metadata value
+func parseRequiredFields(value string) ([]string, error) {
+	fields := normalizeList(strings.Split(strings.ToLower(value), ","))
+	for _, field := range fields {
+		if _, ok := metadataChecks[field]; !ok {
+			return nil, fmt.Errorf("unknown field %q (want %s)", field, strings.Join(metadataFieldNames(), ", "))
+		}
+	}
+	return fields, nil
+}
+
+// checkRequiredMetadata fails when any detection lacks one of fields,
+// reporting every offender first. A description only counts when it came
+// from the file, not from -empty-description.
+func checkRequiredMetadata(queries []Query, fields []string) error {
+	offenders := 0
+	for _, q := range queries {
+		if q.Category != "detection" {
+			continue
+		}
+		var missing []string
+		for _, field := range fields {
+			if !metadataChecks[field](q) {
+				missing = append(missing, field)
+			}
+		}
+		if len(missing) > 0 {
+			fmt.Fprintf(os.Stderr, "Missing metadata: %s (%s): %s\n", q.Name, q.Path, strings.Join(missing, ", "))
+			offenders++
+		}
+	}
+	if offenders > 0 {
+		return fmt.Errorf("%d detections are missing required metadata", offenders)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// schedulePolicy maps query names and tags to intervals in seconds
+type schedulePolicy struct {
+	Queries map[string]int `json:"queries"`
+	Tags    map[string]int `json:"tags"`
+}
+
+func loadSchedule(path string) (schedulePolicy, error) {
+	var s schedulePolicy
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return s, err
+	}
+	if err := json.Unmarshal(data, &s); err != nil {
+		return s, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	for name, interval := range s.Queries {
+		if interval <= 0 {
+			return s, fmt.Errorf("%s: interval for query %q must be positive", path, name)
+		}
+	}
+	for tag, interval := range s.Tags {
+		if interval <= 0 {
+			return s, fmt.Errorf("%s: interval for tag %q must be positive", path, tag)
+		}
+	}
+
+	return s, nil
+}
+
+// intervalFor returns the scheduled interval for a query. A name match wins
+// over tag matches; when several tags match, the shortest interval wins.
+func (s schedulePolicy) intervalFor(q Query) (int, bool) {
+	if interval, ok := s.Queries[q.Name]; ok {
+		return interval, true
+	}
+
+	best := 0
+	for _, tag := range q.Tags {
+		if interval, ok := s.Tags[tag]; ok && (best == 0 || interval < best) {
+			best = interval
+		}
+	}
+	return best, best > 0
+}
+
+func (schedulePolicy) precedence() int { return precedenceSchedule }
+
+func (s schedulePolicy) apply(q *Query) {
+	if interval, ok := s.intervalFor(*q); ok {
+		q.Interval = interval
+		q.IntervalSet = true
+	}
+}
+
+// warnUnmatchedSchedule reports query entries that name no parsed query,
+// which usually means a query was renamed upstream
+func warnUnmatchedSchedule(queries []Query, s schedulePolicy) {
+	matched := map[string]bool{}
+	for _, q := range queries {
+		matched[q.Name] = true
+	}
+
+	var unmatched []string
+	for name := range s.Queries {
+		if !matched[name] {
+			unmatched = append(unmatched, name)
+		}
+	}
+	sort.Strings(unmatched)
+	for _, name := range unmatched {
+		warnf("schedule entry %q matches no query\n", name)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestScheduleIntervalFor(t *testing.T) {
+	s := schedulePolicy{
+		Queries: map[string]int{"Unexpected Shell": 120},
+		Tags:    map[string]int{"process": 900, "persistent": 300},
+	}
+	tests := []struct {
+		name  string
+		query Query
+		want  int
+		ok    bool
+	}{
+		{"name match", Query{Name: "Unexpected Shell", Tags: []string{"persistent"}}, 120, true},
+		{"shortest tag wins", Query{Name: "Other", Tags: []string{"process", "persistent"}}, 300, true},
+		{"single tag", Query{Name: "Other", Tags: []string{"process"}}, 900, true},
+		{"no match", Query{Name: "Other", Tags: []string{"network"}}, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := s.intervalFor(tt.query)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("intervalFor = %d, %v; want %d, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestLoadSchedule(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	s, err := loadSchedule(write("ok.json", `{"queries": {"Unexpected Shell": 120}, "tags": {"process": 900}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Queries["Unexpected Shell"] != 120 || s.Tags["process"] != 900 {
+		t.Errorf("loaded %+v", s)
+	}
+
+	for name, content := range map[string]string{
+		"zero.json":     `{"queries": {"Unexpected Shell": 0}}`,
+		"negative.json": `{"tags": {"process": -5}}`,
+		"invalid.json":  `{"queries":`,
+	} {
+		if _, err := loadSchedule(write(name, content)); err == nil {
+			t.Errorf("%s: loaded without error", name)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"reflect"
+	"time"
+)
+
+// querySchemaID identifies the generated schema
+const querySchemaID = "https://github.com/RasterSec/fleetdm-osquery-defense-kit/query.schema.json"
+
+// querySchema describes Query as encoding/json marshals it. It is derived
+// from the struct by reflection, so new fields appear without edits here.
+// Every key is always present; pointer and slice fields may be null.
+func querySchema() (map[string]any, error) {
+	t := reflect.TypeOf(Query{})
+	properties := map[string]any{}
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		prop, err := schemaFor(field.Type)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", field.Name, err)
+		}
+		properties[field.Name] = prop
+		required = append(required, field.Name)
+	}
+
+	return map[string]any{
+		"$schema":              "https://json-schema.org/draft/2020-12/schema",
+		"$id":                  querySchemaID,
+		"title":                "Query",
+		"description":          "A converted osquery-defense-kit query",
+		"type":                 "object",
+		"properties":           properties,
+		"required":             required,
+		"additionalProperties": false,
+	}, nil
+}
+
+func schemaFor(t reflect.Type) (map[string]any, error) {
+	if t == reflect.TypeOf(time.Time{}) {
+		return map[string]any{"type": "string", "format": "date-time"}, nil
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}, nil
+	case reflect.Int, reflect.Int64, reflect.Int32:
+		return map[string]any{"type": "integer"}, nil
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}, nil
+	case reflect.Pointer:
+		inner, err := schemaFor(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		inner["type"] = []any{inner["type"], "null"}
+		return inner, nil
+	case reflect.Slice:
+		items, err := schemaFor(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"type": []any{"array", "null"}, "items": items}, nil
+	case reflect.Map:
+		values, err := schemaFor(t.Elem())
+		if err != nil || t.Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("no JSON schema mapping for %s", t)
+		}
+		return map[string]any{"type": []any{"object", "null"}, "additionalProperties": values}, nil
+	}
+	return nil, fmt.Errorf("no JSON schema mapping for %s", t)
+}
+
+// writeQuerySchema writes query.schema.json to outputDir
+func writeQuerySchema(outputDir string) error {
+	schema, err := querySchema()
+	if err != nil {
+		return err
+	}
+	filename := filepath.Join(outputDir, "query.schema.json")
+	if err := writeFileAtomic(filename, func(w io.Writer) error {
+		return writeJSON(w, schema)
+	}); err != nil {
+		return err
+	}
+	infof("Wrote %s\n", filename)
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+)
+
+// compileQuerySchema writes the schema as -emit-schema does and compiles it
+func compileQuerySchema(t *testing.T) *jsonschema.Schema {
+	t.Helper()
+	quietTest(t)
+	dir := t.TempDir()
+	if err := writeQuerySchema(dir); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "query.schema.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("query.schema.json is not JSON: %v", err)
+	}
+	compiler := jsonschema.NewCompiler()
+	compiler.AssertFormat()
+	if err := compiler.AddResource(querySchemaID, doc); err != nil {
+		t.Fatal(err)
+	}
+	schema, err := compiler.Compile(querySchemaID)
+	if err != nil {
+		t.Fatalf("query.schema.json does not compile: %v", err)
+	}
+	return schema
+}
+
+// validateJSON checks the JSON encoding of v against schema
+func validateJSON(t *testing.T, schema *jsonschema.Schema, v any) error {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return schema.Validate(doc)
+}
+
+func TestQuerySchema(t *testing.T) {
+	schema := compileQuerySchema(t)
+
+	q, _ := parseTestQuery(t, "detection/execution/2-shell.sql", fixtureQuery)
+	observer, sudo := true, false
+	q.ObserverCanRun, q.RequiresSudo = &observer, &sudo
+	q.Created = time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	q.Labels = []string{"production"}
+	for name, record := range map[string]Query{"parsed": q, "zero": {}} {
+		if err := validateJSON(t, schema, record); err != nil {
+			t.Errorf("%s query does not validate: %v", name, err)
+		}
+	}
+
+	// Records that break the contract are rejected
+	var fields map[string]any
+	data, _ := json.Marshal(q)
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatal(err)
+	}
+	bad := map[string]func(map[string]any){
+		"unknown field":  func(m map[string]any) { m["Extra"] = 1 },
+		"missing field":  func(m map[string]any) { delete(m, "Name") },
+		"wrong type":     func(m map[string]any) { m["Interval"] = "300" },
+		"null string":    func(m map[string]any) { m["Query"] = nil },
+		"bad date":       func(m map[string]any) { m["Created"] = "2024-01-15" },
+		"bad list items": func(m map[string]any) { m["Tags"] = []any{1} },
+	}
+	for name, mutate := range bad {
+		record := map[string]any{}
+		for k, v := range fields {
+			record[k] = v
+		}
+		mutate(record)
+		if err := validateJSON(t, schema, record); err == nil {
+			t.Errorf("%s: record validated", name)
+		}
+	}
+}
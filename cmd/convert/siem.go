@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// tacticIDs maps ATT&CK tactics to their IDs and display names
+var tacticIDs = map[string][2]string{
+	"reconnaissance":       {"TA0043", "Reconnaissance"},
+	"resource-development": {"TA0042", "Resource Development"},
+	"initial-access":       {"TA0001", "Initial Access"},
+	"execution":            {"TA0002", "Execution"},
+	"persistence":          {"TA0003", "Persistence"},
+	"privilege-escalation": {"TA0004", "Privilege Escalation"},
+	"defense-evasion":      {"TA0005", "Defense Evasion"},
+	"credential-access":    {"TA0006", "Credential Access"},
+	"discovery":            {"TA0007", "Discovery"},
+	"lateral-movement":     {"TA0008", "Lateral Movement"},
+	"collection":           {"TA0009", "Collection"},
+	"command-and-control":  {"TA0011", "Command and Control"},
+	"exfiltration":         {"TA0010", "Exfiltration"},
+	"impact":               {"TA0040", "Impact"},
+}
+
+// riskScores are Elastic's default risk scores per severity
+var riskScores = map[string]int{"low": 21, "medium": 47, "high": 73}
+
+// siemRule is an Elastic detection rule skeleton. The osquery SQL can't be
+// translated into a SIEM query, so it is carried in Note and Query is left
+// for the rule author.
+type siemRule struct {
+	RuleID      string       `json:"rule_id"`
+	Name        string       `json:"name"`
+	Description string       `json:"description"`
+	Type        string       `json:"type"`
+	Language    string       `json:"language"`
+	Query       string       `json:"query"`
+	Severity    string       `json:"severity"`
+	RiskScore   int          `json:"risk_score"`
+	Enabled     bool         `json:"enabled"`
+	Tags        []string     `json:"tags,omitempty"`
+	Threat      []siemThreat `json:"threat,omitempty"`
+	Note        string       `json:"note"`
+}
+
+type siemThreat struct {
+	Framework string          `json:"framework"`
+	Tactic    siemReference   `json:"tactic"`
+	Technique []siemReference `json:"technique,omitempty"`
+}
+
+type siemReference struct {
+	ID           string          `json:"id"`
+	Name         string          `json:"name"`
+	Reference    string          `json:"reference"`
+	Subtechnique []siemReference `json:"subtechnique,omitempty"`
+}
+
+// writeSIEMRules writes one disabled rule per query as NDJSON, the format
+// Elastic's rule import accepts
+func writeSIEMRules(queries []Query, filename string) error {
+	return writeFileAtomic(filename, func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		for _, q := range queries {
+			if err := enc.Encode(siemRuleFor(q)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func siemRuleFor(q Query) siemRule {
+	severity := q.Severity
+	if severity == "" {
+		severity = "low"
+		for name, level := range severityLevels {
+			if level == q.Level {
+				severity = name
+			}
+		}
+	}
+
+	return siemRule{
+		RuleID:      q.Slug,
+		Name:        q.Name,
+		Description: q.Description,
+		Type:        "query",
+		Language:    "kuery",
+		Severity:    severity,
+		RiskScore:   riskScores[severity],
+		Tags:        append([]string{"osquery", q.Category}, q.Tags...),
+		Threat:      siemThreats(q.Techniques),
+		Note:        fmt.Sprintf("Converted from an osquery detection; translate the SQL below into a SIEM query before enabling.\n\n```sql\n%s\n```", strings.TrimSpace(q.Query)),
+	}
+}
+
+// siemThreats groups techniques under each tactic they belong to, in
+// kill-chain order, with sub-techniques nested under their parent. Names are
+// the IDs since the converter has no technique names.
+func siemThreats(techniques []string) []siemThreat {
+	var threats []siemThreat
+	for _, tactic := range tacticsFor(techniques) {
+		id := tacticIDs[tactic]
+		threat := siemThreat{
+			Framework: "MITRE ATT&CK",
+			Tactic:    siemReference{ID: id[0], Name: id[1], Reference: "https://attack.mitre.org/tactics/" + id[0] + "/"},
+		}
+		for _, technique := range techniques {
+			parent, _, isSub := strings.Cut(technique, ".")
+			if !containsString(techniqueTactics[parent], tactic) {
+				continue
+			}
+
+			i := len(threat.Technique)
+			for j, t := range threat.Technique {
+				if t.ID == parent {
+					i = j
+				}
+			}
+			if i == len(threat.Technique) {
+				threat.Technique = append(threat.Technique, attackReference(parent))
+			}
+			if isSub {
+				threat.Technique[i].Subtechnique = append(threat.Technique[i].Subtechnique, attackReference(technique))
+			}
+		}
+		threats = append(threats, threat)
+	}
+	return threats
+}
+
+func attackReference(technique string) siemReference {
+	return siemReference{
+		ID:        technique,
+		Name:      technique,
+		Reference: "https://attack.mitre.org/techniques/" + strings.ReplaceAll(technique, ".", "/") + "/",
+	}
+}
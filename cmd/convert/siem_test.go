@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestWriteSIEMRules(t *testing.T) {
+	queries := []Query{
+		{Name: "[detection/execution] Shell", Description: "Unexpected shell", Query: "SELECT pid\nFROM processes\n", Slug: "detection-execution-shell",
+			Category: "detection", Level: 3, Tags: []string{"process"}, Techniques: []string{"T1059", "T1059.004"}},
+		{Name: "[policy] Ssh", Description: "SSH hardening", Query: "SELECT 1", Slug: "policy-ssh", Category: "policy", Severity: "medium"},
+	}
+	filename := filepath.Join(t.TempDir(), "rules.ndjson")
+	if err := writeSIEMRules(queries, filename); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var rules []map[string]any
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var rule map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &rule); err != nil {
+			t.Fatalf("line %d is not a JSON object: %v", len(rules)+1, err)
+		}
+		rules = append(rules, rule)
+		lines = append(lines, scanner.Text())
+	}
+	if len(rules) != len(queries) {
+		t.Fatalf("%d rules, want one per query", len(rules))
+	}
+
+	keys := func(m map[string]any) string {
+		var ks []string
+		for k := range m {
+			ks = append(ks, k)
+		}
+		sort.Strings(ks)
+		return strings.Join(ks, ",")
+	}
+	if got := keys(rules[0]); got != "description,enabled,language,name,note,query,risk_score,rule_id,severity,tags,threat,type" {
+		t.Errorf("rule keys = %s", got)
+	}
+	if got := keys(rules[1]); got != "description,enabled,language,name,note,query,risk_score,rule_id,severity,tags,type" {
+		t.Errorf("rule without techniques has keys %s", got)
+	}
+
+	shell := rules[0]
+	for key, want := range map[string]any{
+		"rule_id": "detection-execution-shell", "name": queries[0].Name, "description": "Unexpected shell",
+		"type": "query", "language": "kuery", "query": "", "severity": "high", "risk_score": 73.0, "enabled": false,
+	} {
+		if shell[key] != want {
+			t.Errorf("%s = %v, want %v", key, shell[key], want)
+		}
+	}
+	// The SQL is carried in the note, not translated
+	if note, _ := shell["note"].(string); !strings.Contains(note, "```sql\nSELECT pid\nFROM processes\n```") {
+		t.Errorf("note = %q", note)
+	}
+	if tags, _ := json.Marshal(shell["tags"]); string(tags) != `["osquery","detection","process"]` {
+		t.Errorf("tags = %s", tags)
+	}
+
+	// Written unescaped, with sub-techniques under their parent
+	want := `"threat":[{"framework":"MITRE ATT&CK","tactic":{"id":"TA0002","name":"Execution","reference":"https://attack.mitre.org/tactics/TA0002/"},` +
+		`"technique":[{"id":"T1059","name":"T1059","reference":"https://attack.mitre.org/techniques/T1059/",` +
+		`"subtechnique":[{"id":"T1059.004","name":"T1059.004","reference":"https://attack.mitre.org/techniques/T1059/004/"}]}]}]`
+	if !strings.Contains(lines[0], want) {
+		t.Errorf("rule = %s\nwant threat %s", lines[0], want)
+	}
+
+	if rules[1]["severity"] != "medium" || rules[1]["risk_score"] != 47.0 {
+		t.Errorf("-- severity: gave %v, %v", rules[1]["severity"], rules[1]["risk_score"])
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// maxDocsPerFile splits query files holding more queries than this into
+// numbered parts, set from -max-docs-per-file; 0 never splits
+var maxDocsPerFile int
+
+// writeQueryFile writes queries to filename, or with -max-docs-per-file to
+// filename's numbered parts, e.g. chainguard-detection-001.yml. Parts hold
+// consecutive runs of queries in order, so the boundaries only move when
+// the queries do. Whichever layout is not written is removed, along with
+// parts left over from a run that needed more of them.
+func writeQueryFile(filename string, queries []Query, overrides ...metadataSource) error {
+	parts := 1
+	if maxDocsPerFile > 0 && len(queries) > maxDocsPerFile {
+		parts = (len(queries) + maxDocsPerFile - 1) / maxDocsPerFile
+	}
+
+	if parts == 1 {
+		if err := removeParts(filename, 0); err != nil {
+			return err
+		}
+		return writeQueryDocumentFile(filename, queries, overrides...)
+	}
+
+	for i := 0; i < parts; i++ {
+		chunk := queries[i*maxDocsPerFile : min((i+1)*maxDocsPerFile, len(queries))]
+		if err := writeQueryDocumentFile(partName(filename, i+1), chunk, overrides...); err != nil {
+			return err
+		}
+	}
+	if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := removeParts(filename, parts); err != nil {
+		return err
+	}
+	debugf("Split %s into %d files of up to %d queries\n", filename, parts, maxDocsPerFile)
+	return nil
+}
+
+// writeQueryDocumentFile writes queries to filename as one YAML file,
+// applying any file-wide overrides to each query, whatever
+// -max-docs-per-file says. The combined file uses it directly, since -append
+// and change review read it back whole.
+func writeQueryDocumentFile(filename string, queries []Query, overrides ...metadataSource) error {
+	return writeFileAtomic(filename, func(w io.Writer) error {
+		io.WriteString(w, headerComment)
+		return writeQueryDocuments(w, queries, overrides...)
+	})
+}
+
+// partName numbers filename, e.g. chainguard-detection.yml becomes
+// chainguard-detection-001.yml for part 1
+func partName(filename string, part int) string {
+	ext := filepath.Ext(filename)
+	return fmt.Sprintf("%s-%03d%s", strings.TrimSuffix(filename, ext), part, ext)
+}
+
+// removeParts deletes the numbered parts of filename after the first keep
+func removeParts(filename string, keep int) error {
+	ext := filepath.Ext(filename)
+	base := strings.TrimSuffix(filename, ext)
+	matches, err := filepath.Glob(base + "-[0-9][0-9][0-9]" + ext)
+	if err != nil {
+		return err
+	}
+	for _, match := range matches {
+		part, _ := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(match, base+"-"), ext))
+		if part <= keep {
+			continue
+		}
+		debugf("Removing stale %s\n", match)
+		if err := os.Remove(match); err != nil {
+			return err
+		}
+	}
+	return nil
+}
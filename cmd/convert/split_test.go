@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMaxDocsPerFile(t *testing.T) {
+	quietTest(t)
+	saved := maxDocsPerFile
+	t.Cleanup(func() { maxDocsPerFile = saved })
+	maxDocsPerFile = 2
+
+	queries := make([]Query, 5)
+	for i := range queries {
+		queries[i] = Query{Name: fmt.Sprintf("[detection] %d", i), Query: "SELECT 1", Category: "detection", Slug: fmt.Sprintf("detection-%d", i)}
+	}
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "chainguard-detection.yml")
+
+	// Each step runs over the files the previous one left
+	steps := []struct {
+		name    string
+		queries int
+		want    map[string][]string // file -> query names, in order
+	}{
+		{"exactly N stay in one file", 2, map[string][]string{
+			"chainguard-detection.yml": {"[detection] 0", "[detection] 1"},
+		}},
+		{"N+1 split in order", 3, map[string][]string{
+			"chainguard-detection-001.yml": {"[detection] 0", "[detection] 1"},
+			"chainguard-detection-002.yml": {"[detection] 2"},
+		}},
+		{"more parts", 5, map[string][]string{
+			"chainguard-detection-001.yml": {"[detection] 0", "[detection] 1"},
+			"chainguard-detection-002.yml": {"[detection] 2", "[detection] 3"},
+			"chainguard-detection-003.yml": {"[detection] 4"},
+		}},
+		{"fewer parts remove the stale ones", 4, map[string][]string{
+			"chainguard-detection-001.yml": {"[detection] 0", "[detection] 1"},
+			"chainguard-detection-002.yml": {"[detection] 2", "[detection] 3"},
+		}},
+		{"back under N the parts go", 1, map[string][]string{
+			"chainguard-detection.yml": {"[detection] 0"},
+		}},
+	}
+	for _, step := range steps {
+		if err := writeQueryFile(filename, queries[:step.queries]); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		got := map[string][]string{}
+		for _, name := range listFiles(t, dir) {
+			got[name] = docNames(t, filepath.Join(dir, name))
+		}
+		if !reflect.DeepEqual(got, step.want) {
+			t.Errorf("%s: wrote %v, want %v", step.name, got, step.want)
+		}
+	}
+}
+
+func TestPartName(t *testing.T) {
+	for part, want := range map[int]string{1: "out/chainguard-detection-001.yml", 12: "out/chainguard-detection-012.yml", 1000: "out/chainguard-detection-1000.yml"} {
+		if got := partName("out/chainguard-detection.yml", part); got != want {
+			t.Errorf("part %d = %s, want %s", part, got, want)
+		}
+	}
+}
+
+func TestMaxDocsPerFileFlag(t *testing.T) {
+	upstream := writeUpstream(t, map[string]string{
+		"detection/execution/2-bash.sql":  fixtureQuery,
+		"detection/execution/2-shell.sql": fixtureQuery,
+		"detection/execution/2-zsh.sql":   fixtureQuery,
+	})
+	output := t.TempDir()
+	if err := runConvert(t, "-upstream", upstream, "-output", output, "-max-docs-per-file", "2"); err != nil {
+		t.Fatal(err)
+	}
+	files := strings.Join(listFiles(t, output), ",")
+	if !strings.Contains(files, "chainguard-detection-001.yml,chainguard-detection-002.yml") || strings.Contains(files, "chainguard-detection.yml") {
+		t.Errorf("detection file not split: %s", files)
+	}
+	// -append and review read the combined file whole
+	if got := docNames(t, filepath.Join(output, "chainguard-all.yml")); len(got) != 3 {
+		t.Errorf("chainguard-all.yml holds %v", got)
+	}
+
+	err := runConvert(t, "-upstream", upstream, "-output", t.TempDir(), "-max-docs-per-file", "-1")
+	if err == nil || !strings.Contains(err.Error(), "must not be negative") {
+		t.Errorf("-max-docs-per-file -1 gave %v", err)
+	}
+}
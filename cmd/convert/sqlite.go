@@ -0,0 +1,119 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	_ "modernc.org/sqlite"
+)
+
+const catalogSchema = `
+CREATE TABLE queries (
+	id          INTEGER PRIMARY KEY,
+	name        TEXT NOT NULL,
+	description TEXT NOT NULL,
+	query       TEXT NOT NULL,
+	platform    TEXT NOT NULL,
+	interval    INTEGER NOT NULL,
+	level       INTEGER NOT NULL,
+	category    TEXT NOT NULL,
+	subcategory TEXT NOT NULL
+);
+CREATE TABLE tags (
+	id   INTEGER PRIMARY KEY,
+	name TEXT NOT NULL UNIQUE
+);
+CREATE TABLE query_tags (
+	query_id INTEGER NOT NULL REFERENCES queries(id),
+	tag_id   INTEGER NOT NULL REFERENCES tags(id),
+	PRIMARY KEY (query_id, tag_id)
+);
+CREATE TABLE query_techniques (
+	query_id  INTEGER NOT NULL REFERENCES queries(id),
+	technique TEXT NOT NULL,
+	PRIMARY KEY (query_id, technique)
+);
+`
+
+// writeSQLiteCatalog writes all queries into a fresh SQLite database with
+// tags and ATT&CK techniques in join tables. The database is built under a
+// temp name and renamed over filename once complete.
+func writeSQLiteCatalog(queries []Query, filename string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	if err := buildCatalog(queries, tmp.Name()); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), filename); err != nil {
+		return err
+	}
+	recordWritten(filename)
+	return nil
+}
+
+func buildCatalog(queries []Query, filename string) error {
+	db, err := sql.Open("sqlite", filename)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(catalogSchema); err != nil {
+		return fmt.Errorf("creating schema: %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	tagIDs := map[string]int64{}
+	for _, q := range queries {
+		res, err := tx.Exec(`INSERT INTO queries (name, description, query, platform, interval, level, category, subcategory)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			q.Name, q.Description, q.Query, q.Platform, q.Interval, q.Level, q.Category, q.Subcategory)
+		if err != nil {
+			return fmt.Errorf("inserting %s: %w", q.Name, err)
+		}
+		queryID, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+
+		for _, tag := range q.Tags {
+			tagID, ok := tagIDs[tag]
+			if !ok {
+				res, err := tx.Exec(`INSERT INTO tags (name) VALUES (?)`, tag)
+				if err != nil {
+					return fmt.Errorf("inserting tag %s: %w", tag, err)
+				}
+				if tagID, err = res.LastInsertId(); err != nil {
+					return err
+				}
+				tagIDs[tag] = tagID
+			}
+			if _, err := tx.Exec(`INSERT INTO query_tags (query_id, tag_id) VALUES (?, ?)`, queryID, tagID); err != nil {
+				return fmt.Errorf("tagging %s: %w", q.Name, err)
+			}
+		}
+
+		for _, technique := range q.Techniques {
+			if _, err := tx.Exec(`INSERT INTO query_techniques (query_id, technique) VALUES (?, ?)`, queryID, technique); err != nil {
+				return fmt.Errorf("mapping %s: %w", q.Name, err)
+			}
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteSQLiteCatalog(t *testing.T) {
+	queries := []Query{
+		{Name: "[detection/execution] Shell", Description: "Shell", Query: "SELECT 1", Platform: "linux", Interval: 300, Level: 3,
+			Category: "detection", Subcategory: "execution", Tags: []string{"process", "state"}, Techniques: []string{"T1059.004"}},
+		{Name: "[detection/c2] Dns", Description: "Dns", Query: "SELECT 2", Category: "detection", Subcategory: "c2",
+			Tags: []string{"process", "network"}},
+		{Name: "[policy] Ssh", Description: "Ssh", Query: "SELECT 3", Category: "policy"},
+	}
+	filename := filepath.Join(t.TempDir(), "catalog.db")
+	if err := writeSQLiteCatalog(queries, filename); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sql.Open("sqlite", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { db.Close() }()
+
+	count := func(query string) int {
+		t.Helper()
+		var n int
+		if err := db.QueryRow(query).Scan(&n); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		return n
+	}
+	for query, want := range map[string]int{
+		"SELECT count(*) FROM queries":          3,
+		"SELECT count(*) FROM tags":             3,
+		"SELECT count(*) FROM query_tags":       4,
+		"SELECT count(*) FROM query_techniques": 1,
+		// The analysis the catalog exists for
+		"SELECT count(*) FROM queries WHERE id NOT IN (SELECT query_id FROM query_techniques)": 2,
+	} {
+		if got := count(query); got != want {
+			t.Errorf("%s = %d, want %d", query, got, want)
+		}
+	}
+
+	var platform string
+	var interval, level int
+	if err := db.QueryRow("SELECT platform, interval, level FROM queries WHERE name = ?", queries[0].Name).Scan(&platform, &interval, &level); err != nil {
+		t.Fatal(err)
+	}
+	if platform != "linux" || interval != 300 || level != 3 {
+		t.Errorf("row = %s, %d, %d; want linux, 300, 3", platform, interval, level)
+	}
+
+	var tagged int
+	if err := db.QueryRow(`SELECT count(*) FROM query_tags qt JOIN tags t ON t.id = qt.tag_id WHERE t.name = 'process'`).Scan(&tagged); err != nil {
+		t.Fatal(err)
+	}
+	if tagged != 2 {
+		t.Errorf("process is joined to %d queries, want 2", tagged)
+	}
+
+	// A second write replaces the database rather than appending to it
+	db.Close()
+	if err := writeSQLiteCatalog(queries[:1], filename); err != nil {
+		t.Fatal(err)
+	}
+	if db, err = sql.Open("sqlite", filename); err != nil {
+		t.Fatal(err)
+	}
+	if got := count("SELECT count(*) FROM queries"); got != 1 {
+		t.Errorf("after rewriting, %d queries, want 1", got)
+	}
+}
@@ -0,0 +1,433 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokIdent  tokenKind = iota // keyword or identifier, including "quoted" ones
+	tokString                  // 'single-quoted' literal
+	tokNumber
+	tokPunct
+)
+
+// sqlToken is a lexical token of a query body. Comments are dropped.
+type sqlToken struct {
+	kind   tokenKind
+	text   string // for tokIdent, unquoted
+	offset int    // byte offset in the query
+	depth  int    // parenthesis depth the token appears at
+}
+
+func (t sqlToken) is(keyword string) bool {
+	return t.kind == tokIdent && strings.EqualFold(t.text, keyword)
+}
+
+// tokenizeSQL splits a query into tokens. It is deliberately lenient: an
+// unterminated string or comment simply runs to the end of the input.
+func tokenizeSQL(query string) []sqlToken {
+	var tokens []sqlToken
+	depth := 0
+
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '-' && strings.HasPrefix(query[i:], "--"):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				return tokens
+			}
+			i += end + 1
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				return tokens
+			}
+			i += end + 4
+		case c == '\'' || c == '"' || c == '`':
+			end := closingQuote(query, i)
+			kind := tokIdent
+			if c == '\'' {
+				kind = tokString
+			}
+			text := strings.ReplaceAll(query[i+1:end], string([]byte{c, c}), string(c))
+			tokens = append(tokens, sqlToken{kind: kind, text: text, offset: i, depth: depth})
+			i = end + 1
+		case isIdentByte(c) && !isDigit(c):
+			start := i
+			for i < len(query) && isIdentByte(query[i]) {
+				i++
+			}
+			tokens = append(tokens, sqlToken{kind: tokIdent, text: query[start:i], offset: start, depth: depth})
+		case isDigit(c):
+			start := i
+			for i < len(query) && (isIdentByte(query[i]) || query[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, sqlToken{kind: tokNumber, text: query[start:i], offset: start, depth: depth})
+		default:
+			if c == ')' {
+				depth--
+			}
+			tokens = append(tokens, sqlToken{kind: tokPunct, text: string(c), offset: i, depth: depth})
+			if c == '(' {
+				depth++
+			}
+			i++
+		}
+	}
+
+	return tokens
+}
+
+// closingQuote returns the index of the quote closing the one at start,
+// treating doubled quotes as escapes. Unterminated quotes close at the end.
+func closingQuote(s string, start int) int {
+	quote := s[start]
+	for i := start + 1; i < len(s); i++ {
+		if s[i] != quote {
+			continue
+		}
+		if i+1 < len(s) && s[i+1] == quote {
+			i++
+			continue
+		}
+		return i
+	}
+	return len(s)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c == '$' || isDigit(c) || (c|0x20 >= 'a' && c|0x20 <= 'z') || c >= 0x80
+}
+
+// selectList returns the expressions of the outermost SELECT, each as its
+// tokens. For WITH queries this is the main SELECT after the CTEs.
+func selectList(tokens []sqlToken) [][]sqlToken {
+	start := -1
+	for i, t := range tokens {
+		if t.depth == 0 && t.is("SELECT") {
+			start = i + 1
+			break
+		}
+	}
+	if start < 0 {
+		return nil
+	}
+
+	var exprs [][]sqlToken
+	var current []sqlToken
+	for _, t := range tokens[start:] {
+		if t.depth == 0 && (t.is("FROM") || t.is("WHERE") || t.is("UNION") || t.is("GROUP") || t.is("ORDER") || t.is("LIMIT") || t.text == ";") {
+			break
+		}
+		if len(exprs) == 0 && len(current) == 0 && (t.is("DISTINCT") || t.is("ALL")) {
+			continue
+		}
+		if t.depth == 0 && t.kind == tokPunct && t.text == "," {
+			exprs = append(exprs, current)
+			current = nil
+			continue
+		}
+		current = append(current, t)
+	}
+	if len(current) > 0 {
+		exprs = append(exprs, current)
+	}
+	return exprs
+}
+
+// balanceProblem is an unbalanced parenthesis or unterminated quote
+type balanceProblem struct {
+	message string
+	offset  int
+}
+
+// checkBalance reports unbalanced parentheses and unterminated quotes in a
+// query, ignoring anything inside comments and string literals
+func checkBalance(query string) []balanceProblem {
+	var problems []balanceProblem
+	var open []int
+
+	for i := 0; i < len(query); i++ {
+		switch c := query[i]; {
+		case c == '-' && strings.HasPrefix(query[i:], "--"):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				i = len(query)
+			} else {
+				i += end
+			}
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				problems = append(problems, balanceProblem{"unterminated /* comment", i})
+				i = len(query)
+			} else {
+				i += end + 3
+			}
+		case c == '\'' || c == '"':
+			end := closingQuote(query, i)
+			if end == len(query) {
+				problems = append(problems, balanceProblem{fmt.Sprintf("unterminated %c quote", c), i})
+			}
+			i = end
+		case c == '(':
+			open = append(open, i)
+		case c == ')':
+			if len(open) == 0 {
+				problems = append(problems, balanceProblem{"unmatched )", i})
+				continue
+			}
+			open = open[:len(open)-1]
+		}
+	}
+
+	for _, offset := range open {
+		problems = append(problems, balanceProblem{"unclosed (", offset})
+	}
+	return problems
+}
+
+// lineOf returns the 1-based line number of offset in s
+func lineOf(s string, offset int) int {
+	return strings.Count(s[:offset], "\n") + 1
+}
+
+// selectColumns returns the result column names of a query's outermost
+// SELECT, using AS aliases where given. It returns nil when the columns
+// can't be known from the query alone, e.g. for SELECT *.
+func selectColumns(query string) []string {
+	exprs := selectList(tokenizeSQL(query))
+	if len(exprs) == 0 {
+		return nil
+	}
+
+	columns := make([]string, 0, len(exprs))
+	for _, expr := range exprs {
+		if len(expr) == 0 {
+			return nil
+		}
+		last := expr[len(expr)-1]
+		if last.text == "*" && last.kind == tokPunct {
+			return nil
+		}
+
+		switch {
+		case len(expr) >= 3 && expr[len(expr)-2].is("AS"):
+			columns = append(columns, last.text)
+		case len(expr) >= 2 && last.kind == tokIdent && isImplicitAlias(expr[len(expr)-2]):
+			columns = append(columns, last.text)
+		case len(expr) == 1 && last.kind == tokIdent:
+			columns = append(columns, last.text)
+		case len(expr) == 3 && expr[0].kind == tokIdent && expr[1].text == "." && last.kind == tokIdent:
+			columns = append(columns, last.text)
+		default:
+			// SQLite names an unaliased expression after its source text
+			columns = append(columns, query[expr[0].offset:tokenEnd(query, last)])
+		}
+	}
+	return columns
+}
+
+// isImplicitAlias reports whether an identifier following t is an alias
+// ("count(*) total") rather than part of an expression
+func isImplicitAlias(t sqlToken) bool {
+	switch t.kind {
+	case tokIdent:
+		return !t.is("AND") && !t.is("OR") && !t.is("NOT") && !t.is("IS") && !t.is("CASE") && !t.is("WHEN") && !t.is("THEN") && !t.is("ELSE") && !t.is("DISTINCT")
+	case tokString, tokNumber:
+		return true
+	default:
+		return t.text == ")"
+	}
+}
+
+// tokenEnd returns the offset just past t in query
+func tokenEnd(query string, t sqlToken) int {
+	if c := query[t.offset]; c == '\'' || c == '"' || c == '`' {
+		return min(closingQuote(query, t.offset)+1, len(query))
+	}
+	return t.offset + len(t.text)
+}
+
+// referencedTables returns the lowercase names of the tables a query reads
+// from via FROM and JOIN, including comma joins after a join constraint.
+// CTE names and table-valued functions such as json_each(...) are left out.
+func referencedTables(query string) []string {
+	tokens := tokenizeSQL(query)
+
+	ctes := map[string]bool{}
+	for i := 0; i+2 < len(tokens); i++ {
+		if tokens[i].kind == tokIdent && tokens[i+1].is("AS") && tokens[i+2].text == "(" {
+			ctes[strings.ToLower(tokens[i].text)] = true
+		}
+	}
+
+	var tables []string
+	for i := 0; i < len(tokens); i++ {
+		if !tokens[i].is("FROM") && !tokens[i].is("JOIN") {
+			continue
+		}
+		for j := i + 1; j < len(tokens) && tokens[j].kind == tokIdent; {
+			isCall := j+1 < len(tokens) && tokens[j+1].text == "("
+			name := strings.ToLower(tokens[j].text)
+			if !isCall && !ctes[name] && !containsString(tables, name) {
+				tables = append(tables, name)
+			}
+
+			// Skip an optional alias, then continue after a comma join
+			j++
+			if j < len(tokens) && tokens[j].is("AS") {
+				j++
+			}
+			if j < len(tokens) && tokens[j].kind == tokIdent && !isClauseKeyword(tokens[j]) {
+				j++
+			}
+
+			// A join constraint may precede the comma
+			if j < len(tokens) && (tokens[j].is("ON") || tokens[j].is("USING")) {
+				for j++; j < len(tokens); j++ {
+					t := tokens[j]
+					if t.depth < tokens[i].depth || (t.depth == tokens[i].depth && (t.text == "," || isClauseKeyword(t))) {
+						break
+					}
+				}
+			}
+			if j >= len(tokens) || tokens[j].text != "," || tokens[j].depth != tokens[i].depth {
+				break
+			}
+			j++
+		}
+	}
+	return tables
+}
+
+// isClauseKeyword reports whether t ends a FROM item rather than aliasing it
+func isClauseKeyword(t sqlToken) bool {
+	for _, keyword := range []string{"WHERE", "JOIN", "LEFT", "INNER", "CROSS", "NATURAL", "ON", "USING", "GROUP", "ORDER", "LIMIT", "UNION", "HAVING", "WINDOW"} {
+		if t.is(keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// canonicalSQL trims trailing whitespace from every line of query, except
+// where a line break falls inside a quoted string or identifier, so editor
+// settings don't show up as output diffs
+func canonicalSQL(query string) string {
+	var quoted [][2]int
+	for _, t := range tokenizeSQL(query) {
+		if c := query[t.offset]; c == '\'' || c == '"' || c == '`' {
+			quoted = append(quoted, [2]int{t.offset, closingQuote(query, t.offset)})
+		}
+	}
+	inQuote := func(offset int) bool {
+		for _, r := range quoted {
+			if offset > r[0] && offset <= r[1] {
+				return true
+			}
+		}
+		return false
+	}
+
+	lines := strings.Split(query, "\n")
+	offset := 0
+	for i, line := range lines {
+		end := offset + len(line)
+		if !inQuote(end) {
+			lines[i] = strings.TrimRight(line, " \t")
+		}
+		offset = end + 1
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// trimStatement removes a single trailing semicolon from query and returns
+// the number of statements it contains. A semicolon followed only by
+// comments still counts as trailing.
+func trimStatement(query string) (string, int) {
+	tokens := tokenizeSQL(query)
+	if n := len(tokens); n > 0 && tokens[n-1].text == ";" && tokens[n-1].kind == tokPunct {
+		last := tokens[n-1].offset
+		query = canonicalSQL(query[:last] + query[last+1:])
+		tokens = tokens[:n-1]
+	}
+
+	statements := 0
+	inStatement := false
+	for _, t := range tokens {
+		if t.kind == tokPunct && t.text == ";" && t.depth == 0 {
+			inStatement = false
+			continue
+		}
+		if !inStatement {
+			statements++
+			inStatement = true
+		}
+	}
+	return query, statements
+}
+
+// aggregateFunctions reduce their input to one row without GROUP BY
+var aggregateFunctions = []string{"count", "sum", "total", "min", "max", "avg", "group_concat"}
+
+// outerLimit returns the LIMIT of the outermost query, if it has one with
+// a literal row count
+func outerLimit(tokens []sqlToken) (int, bool) {
+	for i := 0; i+1 < len(tokens); i++ {
+		if tokens[i].depth == 0 && tokens[i].is("LIMIT") && tokens[i+1].kind == tokNumber {
+			if limit, err := strconv.Atoi(tokens[i+1].text); err == nil {
+				return limit, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// hasOuterLimit reports whether the outermost query has a LIMIT clause
+func hasOuterLimit(tokens []sqlToken) bool {
+	for _, t := range tokens {
+		if t.depth == 0 && t.is("LIMIT") {
+			return true
+		}
+	}
+	return false
+}
+
+// singleRow reports whether the outermost query aggregates without GROUP
+// BY, so it returns at most one row. A compound query only does when every
+// branch of its UNION, INTERSECT, or EXCEPT aggregates.
+func singleRow(tokens []sqlToken) bool {
+	aggregate := false
+	for i, t := range tokens {
+		if t.depth != 0 || t.kind != tokIdent {
+			continue
+		}
+		if t.is("GROUP") {
+			return false
+		}
+		if t.is("UNION") || t.is("INTERSECT") || t.is("EXCEPT") {
+			if !aggregate {
+				return false
+			}
+			aggregate = false
+			continue
+		}
+		if i+1 < len(tokens) && tokens[i+1].text == "(" && containsString(aggregateFunctions, strings.ToLower(t.text)) {
+			aggregate = true
+		}
+	}
+	return aggregate
+}
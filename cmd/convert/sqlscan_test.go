@@ -0,0 +1,94 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSelectColumns(t *testing.T) {
+	tests := []struct {
+		name, query string
+		want        []string // nil for unknown schema
+	}{
+		{"plain", "SELECT pid, name FROM processes", []string{"pid", "name"}},
+		{"qualified", "SELECT p.pid, p.name FROM processes p", []string{"pid", "name"}},
+		{"AS alias", "SELECT p.name AS process_name FROM processes p", []string{"process_name"}},
+		{"implicit alias", "SELECT count(*) total, pid FROM processes", []string{"total", "pid"}},
+		{"quoted", "SELECT \"column name\", `tick`, a AS \"Quoted Alias\" FROM t", []string{"column name", "tick", "Quoted Alias"}},
+		{"distinct", "SELECT DISTINCT name FROM processes", []string{"name"}},
+		{"unaliased expressions", "SELECT upper(name), 1, 'x' FROM processes", []string{"upper(name)", "1", "'x'"}},
+		{"case", "SELECT CASE WHEN uid = 0 THEN 'root' ELSE 'user' END AS kind FROM users", []string{"kind"}},
+		{"subquery column", "SELECT (SELECT count(*) FROM users) AS users, pid FROM processes", []string{"users", "pid"}},
+		{"comments", "-- header\nSELECT /* inline */ pid\nFROM processes", []string{"pid"}},
+		{"main select after CTEs", "WITH x AS (SELECT a FROM t) SELECT b, c FROM x", []string{"b", "c"}},
+		{"first select of a union", "SELECT name FROM a UNION SELECT path FROM b", []string{"name"}},
+		{"multi-line", "SELECT\n  pid,\n  name AS process\nFROM processes\nWHERE pid > 1", []string{"pid", "process"}},
+		{"star", "SELECT * FROM processes", nil},
+		{"qualified star", "SELECT p.* FROM processes p", nil},
+		{"star among columns", "SELECT pid, * FROM processes", nil},
+		{"no select", "PRAGMA table_info(processes)", nil},
+		{"empty", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := selectColumns(tt.query)
+			if (got == nil) != (tt.want == nil) || strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("selectColumns(%q) = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReferencedTables(t *testing.T) {
+	tests := []struct {
+		name, query, want string
+	}{
+		{"one table", "SELECT pid FROM processes", "processes"},
+		{"aliased", "SELECT p.pid FROM processes AS p", "processes"},
+		{"joins", "SELECT * FROM processes p JOIN process_open_sockets s ON s.pid = p.pid LEFT OUTER JOIN users u USING (uid)", "processes,process_open_sockets,users"},
+		{"comma joins", "SELECT * FROM processes p, users u WHERE p.uid = u.uid", "processes,users"},
+		{"comma after a join constraint", "SELECT * FROM processes p JOIN users u USING (uid), listening_ports l", "processes,users,listening_ports"},
+		{"subqueries", "SELECT * FROM processes WHERE pid IN (SELECT pid FROM listening_ports) AND path IN (SELECT path FROM hash)", "processes,listening_ports,hash"},
+		{"derived table", "SELECT * FROM (SELECT pid FROM process_events) e JOIN processes USING (pid)", "process_events,processes"},
+		{"CTE names left out", "WITH recent AS (SELECT pid FROM process_events) SELECT * FROM recent JOIN processes USING (pid)", "process_events,processes"},
+		{"table-valued function left out", "SELECT value FROM json_each('[1]') JOIN users", "users"},
+		{"repeated once, lowercased", "SELECT * FROM Processes p1 JOIN processes p2 ON p1.parent = p2.pid", "processes"},
+		{"union", "SELECT name FROM chrome_extensions UNION SELECT name FROM firefox_addons", "chrome_extensions,firefox_addons"},
+		{"words in strings and comments", "SELECT 'FROM users' FROM processes -- JOIN hash", "processes"},
+		{"no tables", "SELECT 1", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := strings.Join(referencedTables(tt.query), ","); got != tt.want {
+				t.Errorf("referencedTables = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrimStatement(t *testing.T) {
+	tests := []struct {
+		name, query string
+		want        string
+		statements  int
+	}{
+		{"no semicolon", "SELECT 1", "SELECT 1", 1},
+		{"trailing semicolon", "SELECT pid FROM processes;", "SELECT pid FROM processes", 1},
+		{"semicolon on its own line", "SELECT pid\nFROM processes\n;", "SELECT pid\nFROM processes", 1},
+		{"semicolon before a trailing comment", "SELECT pid FROM processes; -- every process", "SELECT pid FROM processes -- every process", 1},
+		{"semicolon in a trailing comment", "SELECT pid FROM processes -- done;", "SELECT pid FROM processes -- done;", 1},
+		{"semicolon in a block comment", "SELECT pid FROM processes /* ; */", "SELECT pid FROM processes /* ; */", 1},
+		{"semicolon in a string", "SELECT pid FROM processes WHERE cmdline LIKE '%;%'", "SELECT pid FROM processes WHERE cmdline LIKE '%;%'", 1},
+		{"two statements", "SELECT 1; SELECT 2", "SELECT 1; SELECT 2", 2},
+		{"two statements and a trailing semicolon", "SELECT 1;\nSELECT 2;", "SELECT 1;\nSELECT 2", 2},
+		{"empty", "", "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, statements := trimStatement(tt.query)
+			if got != tt.want || statements != tt.statements {
+				t.Errorf("trimStatement(%q) = %q, %d; want %q, %d", tt.query, got, statements, tt.want, tt.statements)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestStableOutput(t *testing.T) {
+	upstream := writeUpstream(t, map[string]string{
+		"detection/execution/2-shell.sql": fixtureQuery,
+		// Tags out of order and trailing whitespace, outside and inside a literal
+		"detection/c2/3-dns-tunnel.sql": "-- Long DNS names\n-- tags: network dns c2\n-- severity: high\nSELECT name   \nFROM dns_cache\nWHERE name LIKE '%.  \nx';\n",
+		"policy/ssh-root.sql":           "-- Root login over SSH\n-- platform: linux\nSELECT 1 FROM augeas WHERE label = 'PermitRootLogin' AND value = 'yes';\n",
+		"incident_response/users.sql":   "-- Local users\n-- interval: 3600\nSELECT * FROM users;\n",
+	})
+	args := []string{"-upstream", upstream, "-format", "yaml,osquery-pack,terraform,gitops,siem,rego", "-catalog", "-gen-tests"}
+
+	// The second run starts elsewhere, so nothing may depend on the working directory
+	var outputs [2]string
+	for i := range outputs {
+		outputs[i] = t.TempDir()
+		t.Chdir(t.TempDir())
+		if err := runConvert(t, append(args, "-output", outputs[i])...); err != nil {
+			t.Fatalf("run %d: %v", i+1, err)
+		}
+	}
+
+	files := listFiles(t, outputs[0])
+	if got := listFiles(t, outputs[1]); !slices.Equal(files, got) {
+		t.Fatalf("runs wrote different files:\n%q\n%q", files, got)
+	}
+	if len(files) < 6 {
+		t.Fatalf("only %q written", files)
+	}
+	all, err := os.ReadFile(filepath.Join(outputs[0], "chainguard-all.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(all, []byte("SELECT name   \n")) || !bytes.Contains(all, []byte("LIKE '%.  \n")) {
+		t.Errorf("trailing whitespace not canonicalized outside literals:\n%s", all)
+	}
+
+	for _, name := range files {
+		first, err := os.ReadFile(filepath.Join(outputs[0], name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, err := os.ReadFile(filepath.Join(outputs[1], name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(first, second) {
+			t.Errorf("%s differs between runs:\n%s\n---\n%s", name, first, second)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"io"
+	"path/filepath"
+)
+
+// buildTableIndex maps each osquery table to the queries that read it,
+// including tables joined or read in subqueries, for judging which
+// detections a change in a table affects
+func buildTableIndex(queries []Query) map[string][]string {
+	index := map[string][]string{}
+	for _, q := range queries {
+		for _, table := range referencedTables(q.Query) {
+			index[table] = appendUnique(index[table], q.Name)
+		}
+	}
+	return index
+}
+
+// writeTableIndex writes the index as table-index.json in outputDir
+func writeTableIndex(queries []Query, outputDir string) error {
+	return writeFileAtomic(filepath.Join(outputDir, "table-index.json"), func(w io.Writer) error {
+		return writeJSON(w, buildTableIndex(queries))
+	})
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestWriteTableIndex(t *testing.T) {
+	queries := []Query{
+		{Name: "Shell", Query: "SELECT p.name FROM processes p JOIN process_open_sockets s USING (pid)"},
+		{Name: "Listeners", Query: "SELECT * FROM listening_ports WHERE pid IN (SELECT pid FROM processes WHERE uid = 0)"},
+		{Name: "Users", Query: "WITH admins AS (SELECT uid FROM user_groups) SELECT * FROM users JOIN admins USING (uid)"},
+		{Name: "Constant", Query: "SELECT 1"},
+	}
+	dir := t.TempDir()
+	if err := writeTableIndex(queries, dir); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "table-index.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var index map[string][]string
+	if err := json.Unmarshal(data, &index); err != nil {
+		t.Fatalf("table-index.json does not parse: %v\n%s", err, data)
+	}
+	want := map[string][]string{
+		"processes":            {"Shell", "Listeners"},
+		"process_open_sockets": {"Shell"},
+		"listening_ports":      {"Listeners"},
+		"user_groups":          {"Users"},
+		"users":                {"Users"},
+	}
+	if !reflect.DeepEqual(index, want) {
+		t.Errorf("index = %v, want %v", index, want)
+	}
+}
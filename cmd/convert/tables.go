@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// removedTables maps osquery tables that no longer exist to the release that
+// removed them. Queries reading them return nothing on current osquery.
+// Extend or replace the list with -removed-tables.
+var removedTables = map[string]string{
+	"pkg_packages": "4.0.0", // FreeBSD support was dropped
+}
+
+// tableVersions maps osquery tables, or table.column for a column added
+// after its table, to the first release that has them. Replace the list
+// with -table-versions.
+var tableVersions = map[string]string{
+	"es_process_file_events": "5.6.0",
+}
+
+// osqueryVersionRegex matches the -osquery-version values accepted
+var osqueryVersionRegex = regexp.MustCompile(`^\d+(\.\d+){0,2}$`)
+
+// loadVersionMap reads a JSON object mapping table names (or table.column)
+// to an osquery version, the format of -removed-tables and -table-versions
+func loadVersionMap(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var tables map[string]string
+	if err := json.Unmarshal(data, &tables); err != nil {
+		return nil, err
+	}
+	return tables, nil
+}
+
+// compareVersions compares dotted numeric versions, treating missing or
+// non-numeric parts as 0
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// unavailableIn returns the tables and table.column entries q reads that
+// versions lists as added after target, in a stable order. Columns count as
+// read when their name appears anywhere in the query.
+func unavailableIn(q Query, versions map[string]string, target string) []string {
+	keys := make([]string, 0, len(versions))
+	for key := range versions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	idents := map[string]bool{}
+	for _, t := range tokenizeSQL(q.Query) {
+		if t.kind == tokIdent {
+			idents[strings.ToLower(t.text)] = true
+		}
+	}
+
+	var found []string
+	for _, table := range referencedTables(q.Query) {
+		for _, key := range keys {
+			column, isColumn := strings.CutPrefix(key, table+".")
+			if key != table && (!isColumn || !idents[column]) {
+				continue
+			}
+			if compareVersions(versions[key], target) > 0 {
+				found = append(found, key)
+			}
+		}
+	}
+	return found
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// writeTerraform writes all queries as fleetdm_query resource blocks for the
+// Fleet Terraform provider
+func writeTerraform(queries []Query, filename string) error {
+	return writeFileAtomic(filename, func(w io.Writer) error {
+		seen := map[string]bool{}
+		for i, q := range queries {
+			if i > 0 {
+				fmt.Fprintln(w)
+			}
+			if err := writeTerraformResource(w, terraformName(q.Name, seen), q); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func writeTerraformResource(w io.Writer, name string, q Query) error {
+	bw := bufio.NewWriter(w)
+
+	attr := func(key, value string) {
+		fmt.Fprintf(bw, "  %-18s = %s\n", key, value)
+	}
+
+	fmt.Fprintf(bw, "resource \"fleetdm_query\" %s {\n", strconv.Quote(name))
+	attr("name", hclString(q.Name))
+	attr("description", hclString(q.Description))
+
+	delimiter := heredocDelimiter(q.Query)
+	attr("query", "<<-"+delimiter)
+	for _, line := range strings.Split(q.Query, "\n") {
+		if line == "" {
+			fmt.Fprintln(bw)
+			continue
+		}
+		fmt.Fprintf(bw, "    %s\n", hclTemplateEscape(line))
+	}
+	fmt.Fprintf(bw, "  %s\n", delimiter)
+
+	if q.Platform != "" {
+		attr("platform", hclString(q.Platform))
+	}
+	if q.IntervalSet || q.Interval > 0 {
+		attr("interval", strconv.Itoa(jitteredInterval(q)))
+	}
+	attr("logging", hclString(loggingFor(q)))
+	if q.ObserverCanRun != nil {
+		attr("observer_can_run", strconv.FormatBool(*q.ObserverCanRun))
+	}
+	if len(q.Labels) > 0 {
+		quoted := make([]string, len(q.Labels))
+		for i, label := range q.Labels {
+			quoted[i] = hclString(label)
+		}
+		attr("labels_include_any", "["+strings.Join(quoted, ", ")+"]")
+	}
+	fmt.Fprintln(bw, "}")
+
+	return bw.Flush()
+}
+
+// terraformName turns a query name into a valid, unique Terraform resource
+// name. seen tracks names already handed out.
+func terraformName(queryName string, seen map[string]bool) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(queryName) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case b.Len() > 0 && !strings.HasSuffix(b.String(), "_"):
+			b.WriteByte('_')
+		}
+	}
+
+	name := strings.TrimSuffix(b.String(), "_")
+	if name == "" || isDigit(name[0]) {
+		name = "query_" + name
+	}
+
+	unique := name
+	for n := 2; seen[unique]; n++ {
+		unique = fmt.Sprintf("%s_%d", name, n)
+	}
+	seen[unique] = true
+	return unique
+}
+
+// hclString quotes s as an HCL string literal. Template sequences are escaped
+// so the value is taken literally.
+func hclString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return hclTemplateEscape(b.String())
+}
+
+// hclTemplateEscape escapes ${ and %{, which HCL would otherwise interpolate
+// in both quoted strings and heredocs
+func hclTemplateEscape(s string) string {
+	s = strings.ReplaceAll(s, "${", "$${")
+	return strings.ReplaceAll(s, "%{", "%%{")
+}
+
+// heredocDelimiter picks a heredoc marker that does not occur as a line of
+// the query
+func heredocDelimiter(query string) string {
+	delimiter := "EOT"
+	for n := 2; ; n++ {
+		clash := false
+		for _, line := range strings.Split(query, "\n") {
+			if strings.TrimSpace(line) == delimiter {
+				clash = true
+				break
+			}
+		}
+		if !clash {
+			return delimiter
+		}
+		delimiter = fmt.Sprintf("EOT%d", n)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+)
+
+func TestWriteTerraformParses(t *testing.T) {
+	observer := true
+	queries := []Query{
+		{Name: "[detection/execution] Unexpected Shell", Description: `Shell "spawned" by a daemon`, Platform: "linux",
+			Query: "SELECT pid\nFROM processes\n\nWHERE cmdline LIKE '%${HOME}%' AND name != '%{x}';", Interval: 300, IntervalSet: true,
+			ObserverCanRun: &observer, Labels: []string{"production", "linux-servers"}},
+		// Same sanitized name, and a line that would end a plain EOT heredoc
+		{Name: "[detection/execution] Unexpected-Shell!", Description: "Second\nline", Query: "SELECT 1\nEOT\nSELECT 2"},
+		{Name: "2fa disabled", Description: "Starts with a digit", Query: "SELECT 2"},
+	}
+	filename := filepath.Join(t.TempDir(), "queries.tf")
+	if err := writeTerraform(queries, filename); err != nil {
+		t.Fatal(err)
+	}
+	src, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file, diags := hclparse.NewParser().ParseHCL(src, filename)
+	if diags.HasErrors() {
+		t.Fatalf("generated HCL does not parse: %v\n%s", diags, src)
+	}
+	body := file.Body.(*hclsyntax.Body)
+
+	wantNames := []string{"detection_execution_unexpected_shell", "detection_execution_unexpected_shell_2", "query_2fa_disabled"}
+	if len(body.Blocks) != len(queries) {
+		t.Fatalf("%d blocks, want %d:\n%s", len(body.Blocks), len(queries), src)
+	}
+	for i, block := range body.Blocks {
+		q := queries[i]
+		if block.Type != "resource" || len(block.Labels) != 2 || block.Labels[0] != "fleetdm_query" || block.Labels[1] != wantNames[i] {
+			t.Errorf("block %d = %s %q, want resource [fleetdm_query %s]", i, block.Type, block.Labels, wantNames[i])
+			continue
+		}
+		attrs := block.Body.Attributes
+		str := func(name string) string {
+			t.Helper()
+			attr, ok := attrs[name]
+			if !ok {
+				t.Errorf("%s: no %s attribute", wantNames[i], name)
+				return ""
+			}
+			v, diags := attr.Expr.Value(&hcl.EvalContext{})
+			if diags.HasErrors() {
+				t.Errorf("%s: evaluating %s: %v", wantNames[i], name, diags)
+				return ""
+			}
+			return v.AsString()
+		}
+
+		// Template sequences and quotes come back literally
+		if got := str("name"); got != q.Name {
+			t.Errorf("name = %q, want %q", got, q.Name)
+		}
+		if got := str("description"); got != q.Description {
+			t.Errorf("description = %q, want %q", got, q.Description)
+		}
+		// The heredoc strips its indentation and ends with a newline
+		if got, want := str("query"), q.Query+"\n"; got != want {
+			t.Errorf("%s: query = %q, want %q", wantNames[i], got, want)
+		}
+	}
+
+	first := body.Blocks[0].Body.Attributes
+	for _, name := range []string{"platform", "interval", "observer_can_run", "labels_include_any"} {
+		if _, ok := first[name]; !ok {
+			t.Errorf("%s: no %s attribute", wantNames[0], name)
+		}
+	}
+	if _, ok := body.Blocks[2].Body.Attributes["platform"]; ok {
+		t.Errorf("%s: platform written for a query without one", wantNames[2])
+	}
+}
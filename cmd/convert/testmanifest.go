@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// testManifestEntry is one query with the assertions a downstream harness
+// should check when running it
+type testManifestEntry struct {
+	Name       string   `json:"name"`
+	Slug       string   `json:"slug"`
+	Path       string   `json:"path"`
+	Platform   string   `json:"platform,omitempty"`
+	Query      string   `json:"query"`
+	Assertions []string `json:"assertions"`
+}
+
+// writeTestManifest writes test-manifest.json with every query that carries
+// -- test: assertions. The converter does not run them.
+func writeTestManifest(queries []Query, outputDir string) error {
+	entries := []testManifestEntry{}
+	for _, q := range queries {
+		if len(q.TestAssertions) == 0 {
+			continue
+		}
+		entries = append(entries, testManifestEntry{
+			Name:       q.Name,
+			Slug:       q.Slug,
+			Path:       q.Source,
+			Platform:   q.Platform,
+			Query:      q.Query,
+			Assertions: q.TestAssertions,
+		})
+	}
+
+	filename := filepath.Join(outputDir, "test-manifest.json")
+	if err := writeFileAtomic(filename, func(w io.Writer) error {
+		return writeJSON(w, map[string]any{"queries": entries})
+	}); err != nil {
+		return err
+	}
+	infof("Wrote %s (%d queries with assertions)\n", filename, len(entries))
+	return nil
+}
+
+// testStubFormats are the -test-format values accepted
+var testStubFormats = []string{"yaml", "json"}
+
+// testStub is the skeleton written for each query by -gen-tests. Expected
+// is left for the author to fill in.
+type testStub struct {
+	Name       string   `json:"name"`
+	Path       string   `json:"path"`
+	Query      string   `json:"query"`
+	Assertions []string `json:"assertions"`
+	Expected   []any    `json:"expected"`
+}
+
+// writeTestStubs writes a test stub per query under outputDir/tests,
+// mirroring the source layout. Existing stubs are never overwritten, so
+// filled-in tests survive later runs.
+func writeTestStubs(queries []Query, outputDir, format string) error {
+	written, kept := 0, 0
+	for _, q := range queries {
+		filename := filepath.Join(outputDir, "tests", filepath.FromSlash(strings.TrimSuffix(q.Source, ".sql")+".test."+format))
+		if _, err := os.Stat(filename); err == nil {
+			kept++
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			return err
+		}
+
+		stub := testStub{Name: q.Name, Path: q.Source, Query: q.Query, Assertions: q.TestAssertions, Expected: []any{}}
+		if stub.Assertions == nil {
+			stub.Assertions = []string{}
+		}
+		err := writeFileAtomic(filename, func(w io.Writer) error {
+			if format == "json" {
+				return writeJSON(w, stub)
+			}
+			return writeTestStubYAML(w, stub)
+		})
+		if err != nil {
+			return err
+		}
+		written++
+	}
+	infof("Wrote %d test stubs to %s (%d existing kept)\n", written, filepath.Join(outputDir, "tests"), kept)
+	return nil
+}
+
+func writeTestStubYAML(w io.Writer, stub testStub) error {
+	fmt.Fprintf(w, "# Test stub for %s. Fill in the rows the query should return on a\n", stub.Name)
+	io.WriteString(w, "# host where it fires; this file is not overwritten once it exists.\n")
+	fmt.Fprintf(w, "name: %s\n", escapeYAML(stub.Name))
+	fmt.Fprintf(w, "path: %s\n", escapeYAML(stub.Path))
+
+	writeBlockScalar(w, "query", "  ", stub.Query)
+
+	if len(stub.Assertions) == 0 {
+		io.WriteString(w, "assertions: []\n")
+	} else {
+		io.WriteString(w, "assertions:\n")
+		for _, a := range stub.Assertions {
+			fmt.Fprintf(w, "  - %s\n", a)
+		}
+	}
+	_, err := io.WriteString(w, "expected: []\n")
+	return err
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestWriteTestManifest(t *testing.T) {
+	quietTest(t)
+	asserted, warnings := parseTestQuery(t, "detection/execution/2-shell.sql", `-- Shell
+-- platform: linux
+-- test: expect_empty_on_clean_host
+-- test: expect_rows_on_compromised_host
+-- test: expect_empty_on_clean_host
+-- test: Not An Assertion
+SELECT pid FROM processes
+`)
+	if got := strings.Join(asserted.TestAssertions, ","); got != "expect_empty_on_clean_host,expect_rows_on_compromised_host" {
+		t.Errorf("assertions = %q", got)
+	}
+	if len(warnings) != 1 {
+		t.Errorf("warnings = %q, want one for the invalid assertion", warnings)
+	}
+	asserted.Slug, asserted.Source = "detection-execution-shell", "detection/execution/2-shell.sql"
+	plain, _ := parseTestQuery(t, "policy/ssh.sql", "-- Ssh\nSELECT 1\n")
+
+	// Assertions are also preserved in the emitted YAML
+	if doc, text := emitTestQuery(t, asserted); doc.Metadata.Annotations["tests"] != "expect_empty_on_clean_host,expect_rows_on_compromised_host" {
+		t.Errorf("tests annotation missing:\n%s", text)
+	}
+
+	manifest := func(queries []Query) map[string][]map[string]any {
+		t.Helper()
+		dir := t.TempDir()
+		if err := writeTestManifest(queries, dir); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(filepath.Join(dir, "test-manifest.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var m map[string][]map[string]any
+		if err := json.Unmarshal(data, &m); err != nil {
+			t.Fatalf("manifest is not JSON: %v\n%s", err, data)
+		}
+		return m
+	}
+
+	entries := manifest([]Query{plain, asserted})["queries"]
+	if len(entries) != 1 {
+		t.Fatalf("%d entries, want only the query with assertions", len(entries))
+	}
+	entry := entries[0]
+	for key, want := range map[string]any{
+		"name": asserted.Name, "slug": "detection-execution-shell", "path": "detection/execution/2-shell.sql",
+		"platform": "linux", "query": "SELECT pid FROM processes",
+	} {
+		if entry[key] != want {
+			t.Errorf("%s = %v, want %v", key, entry[key], want)
+		}
+	}
+	if got, _ := json.Marshal(entry["assertions"]); string(got) != `["expect_empty_on_clean_host","expect_rows_on_compromised_host"]` {
+		t.Errorf("assertions = %s", got)
+	}
+
+	// With no assertions anywhere the manifest is an empty list, not null
+	if entries, ok := manifest([]Query{plain})["queries"]; !ok || entries == nil || len(entries) != 0 {
+		t.Errorf("empty manifest = %v", entries)
+	}
+}
+
+func TestWriteTestStubs(t *testing.T) {
+	quietTest(t)
+	shell, _ := parseTestQuery(t, "detection/execution/2-shell.sql", "-- Shell\n-- test: expect_empty_on_clean_host\nSELECT pid\nFROM processes\nWHERE name = 'sh'\n")
+	users, _ := parseTestQuery(t, "incident_response/users.sql", "-- Local users\nSELECT * FROM users\n")
+	users.Name = "Users: local" // needs quoting in YAML
+
+	for _, format := range testStubFormats {
+		t.Run(format, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := writeTestStubs([]Query{shell, users}, dir, format); err != nil {
+				t.Fatal(err)
+			}
+			want := []string{"tests/detection/execution/2-shell.test." + format, "tests/incident_response/users.test." + format}
+			if got := listFiles(t, dir); strings.Join(got, " ") != strings.Join(want, " ") {
+				t.Fatalf("files = %q, want %q", got, want)
+			}
+
+			for i, q := range []Query{shell, users} {
+				data, err := os.ReadFile(filepath.Join(dir, want[i]))
+				if err != nil {
+					t.Fatal(err)
+				}
+				var stub struct {
+					Name       string   `json:"name" yaml:"name"`
+					Path       string   `json:"path" yaml:"path"`
+					Query      string   `json:"query" yaml:"query"`
+					Assertions []string `json:"assertions" yaml:"assertions"`
+					Expected   []any    `json:"expected" yaml:"expected"`
+				}
+				if format == "json" {
+					err = json.Unmarshal(data, &stub)
+				} else {
+					err = yaml.Unmarshal(data, &stub)
+				}
+				if err != nil {
+					t.Fatalf("%s does not parse: %v\n%s", want[i], err, data)
+				}
+				if stub.Name != q.Name || stub.Path != q.Source || strings.TrimSpace(stub.Query) != q.Query ||
+					strings.Join(stub.Assertions, ",") != strings.Join(q.TestAssertions, ",") || stub.Expected == nil || len(stub.Expected) != 0 {
+					t.Errorf("%s = %+v", want[i], stub)
+				}
+			}
+
+			// Filled-in stubs survive a later run, and new queries get one
+			filled := filepath.Join(dir, filepath.FromSlash(want[0]))
+			if err := os.WriteFile(filled, []byte("filled in"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			policy, _ := parseTestQuery(t, "policy/ssh.sql", "-- Ssh\nSELECT 1\n")
+			if err := writeTestStubs([]Query{shell, users, policy}, dir, format); err != nil {
+				t.Fatal(err)
+			}
+			if data, _ := os.ReadFile(filled); string(data) != "filled in" {
+				t.Errorf("existing stub overwritten with:\n%s", data)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "tests", "policy", "ssh.test."+format)); err != nil {
+				t.Errorf("no stub for the new query: %v", err)
+			}
+		})
+	}
+}
+
+func TestGenTestsFlag(t *testing.T) {
+	upstream := writeUpstream(t, map[string]string{"detection/execution/2-shell.sql": fixtureQuery})
+	output := t.TempDir()
+	if err := runConvert(t, "-upstream", upstream, "-output", output, "-gen-tests", "-test-format", "json"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(output, "tests", "detection", "execution", "2-shell.test.json")); err != nil {
+		t.Error(err)
+	}
+	if err := runConvert(t, "-upstream", upstream, "-output", t.TempDir(), "-gen-tests", "-test-format", "toml"); err == nil {
+		t.Errorf("-test-format toml accepted")
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// badTextRune reports whether r should not appear in a name or description:
+// control characters and invisible format characters such as zero-width
+// spaces, bidi overrides, and byte order marks
+func badTextRune(r rune) bool {
+	return unicode.IsControl(r) || unicode.Is(unicode.Cf, r)
+}
+
+// checkText warns about invalid UTF-8 and control characters in the name and
+// description of each query. With sanitize set, offending bytes are removed
+// (tabs become spaces) instead of being passed through to the output.
+func checkText(queries []Query, sanitize bool) {
+	for i := range queries {
+		q := &queries[i]
+		for _, field := range []struct {
+			label string
+			value *string
+		}{
+			{"name", &q.Name},
+			{"description", &q.Description},
+		} {
+			if reportBadText(q.Name, field.label, *field.value) && sanitize {
+				*field.value = sanitizeText(*field.value)
+			}
+		}
+	}
+}
+
+// reportBadText prints a warning for every offending byte offset in s and
+// reports whether any were found
+func reportBadText(queryName, label, s string) bool {
+	found := false
+	for offset := 0; offset < len(s); {
+		r, size := utf8.DecodeRuneInString(s[offset:])
+		switch {
+		case r == utf8.RuneError && size == 1:
+			warnf("%s: %s has invalid UTF-8 byte 0x%02x at offset %d\n", queryName, label, s[offset], offset)
+			found = true
+		case badTextRune(r):
+			warnf("%s: %s has non-printable character %U at offset %d\n", queryName, label, r, offset)
+			found = true
+		}
+		offset += size
+	}
+	return found
+}
+
+func sanitizeText(s string) string {
+	var b strings.Builder
+	for offset := 0; offset < len(s); {
+		r, size := utf8.DecodeRuneInString(s[offset:])
+		offset += size
+		switch {
+		case r == utf8.RuneError && size == 1:
+		case r == '\t':
+			b.WriteByte(' ')
+		case badTextRune(r):
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// typographicASCII maps typographic characters that render inconsistently
+// in Fleet's UI to their ASCII equivalents
+var typographicASCII = map[rune]string{
+	'‘': "'",   // left single quote
+	'’': "'",   // right single quote, apostrophe
+	'‚': "'",   // low single quote
+	'“': `"`,   // left double quote
+	'”': `"`,   // right double quote
+	'„': `"`,   // low double quote
+	'–': "-",   // en dash
+	'—': "-",   // em dash
+	'…': "...", // ellipsis
+	' ': " ",   // no-break space
+	' ': " ",   // thin space
+	' ': " ",   // narrow no-break space
+}
+
+// checkTypography warns about curly quotes, dashes, and non-breaking spaces
+// in each query's name and description, or with ascii set replaces them
+// with their ASCII equivalents
+func checkTypography(queries []Query, ascii bool) {
+	for i := range queries {
+		q := &queries[i]
+		name := q.Name
+		for _, field := range []struct {
+			label string
+			value *string
+		}{
+			{"name", &q.Name},
+			{"description", &q.Description},
+			{"documentation", &q.LongDescription},
+		} {
+			var found []string
+			for _, r := range *field.value {
+				if _, ok := typographicASCII[r]; ok && !containsString(found, strconv.QuoteRune(r)) {
+					found = append(found, strconv.QuoteRune(r))
+				}
+			}
+			switch {
+			case len(found) == 0:
+			case ascii:
+				*field.value = asciiText(*field.value)
+			default:
+				warnf("%s: %s has typographic characters %s; pass -ascii-descriptions to replace them\n", name, field.label, strings.Join(found, " "))
+			}
+		}
+	}
+}
+
+func asciiText(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if replacement, ok := typographicASCII[r]; ok {
+			b.WriteString(replacement)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
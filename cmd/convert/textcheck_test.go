@@ -0,0 +1,119 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCheckText(t *testing.T) {
+	tests := []struct {
+		name        string
+		description string
+		warnings    []string
+		sanitized   string
+	}{
+		{
+			"control character",
+			"Shell\x1b[31m spawned\tby cron",
+			[]string{
+				"Warning: [detection] Shell: description has non-printable character U+001B at offset 5",
+				"Warning: [detection] Shell: description has non-printable character U+0009 at offset 18",
+			},
+			"Shell[31m spawned by cron",
+		},
+		{
+			"NUL",
+			"Shell\x00 spawned",
+			[]string{"Warning: [detection] Shell: description has non-printable character U+0000 at offset 5"},
+			"Shell spawned",
+		},
+		{
+			"invalid UTF-8",
+			"Caf\xe9 shell \xff",
+			[]string{
+				"Warning: [detection] Shell: description has invalid UTF-8 byte 0xe9 at offset 3",
+				"Warning: [detection] Shell: description has invalid UTF-8 byte 0xff at offset 11",
+			},
+			"Caf shell",
+		},
+		{
+			"clean",
+			"Shell spawned by cron – daily",
+			nil,
+			"Shell spawned by cron – daily",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, sanitize := range []bool{false, true} {
+				queries := []Query{{Name: "[detection] Shell", Description: tt.description}}
+				warnings := captureWarnings(t, func() { checkText(queries, sanitize) })
+				if !reflect.DeepEqual(warnings, tt.warnings) {
+					t.Errorf("sanitize %t: warnings = %q, want %q", sanitize, warnings, tt.warnings)
+				}
+				want := tt.description
+				if sanitize {
+					want = tt.sanitized
+				}
+				if queries[0].Description != want {
+					t.Errorf("sanitize %t: description = %q, want %q", sanitize, queries[0].Description, want)
+				}
+				if queries[0].Name != "[detection] Shell" {
+					t.Errorf("sanitize %t: clean name changed to %q", sanitize, queries[0].Name)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckTypography(t *testing.T) {
+	const description = "Shell’s parent isn’t “cron” – or sshd — at boot…"
+	for _, ascii := range []bool{false, true} {
+		queries := []Query{{Name: "[detection] Shell", Description: description, LongDescription: "Plain text"}}
+		warnings := captureWarnings(t, func() { checkTypography(queries, ascii) })
+		if ascii {
+			if len(warnings) > 0 {
+				t.Errorf("-ascii-descriptions warned: %q", warnings)
+			}
+			if want := `Shell's parent isn't "cron" - or sshd - at boot...`; queries[0].Description != want {
+				t.Errorf("-ascii-descriptions: description = %q, want %q", queries[0].Description, want)
+			}
+			continue
+		}
+		want := []string{`Warning: [detection] Shell: description has typographic characters '’' '“' '”' '–' '—' '…'; pass -ascii-descriptions to replace them`}
+		if !reflect.DeepEqual(warnings, want) {
+			t.Errorf("warnings = %q, want %q", warnings, want)
+		}
+		if queries[0].Description != description {
+			t.Errorf("description changed without -ascii-descriptions: %q", queries[0].Description)
+		}
+	}
+}
+
+func TestASCIIDescriptionsFlag(t *testing.T) {
+	upstream := writeUpstream(t, map[string]string{
+		"detection/execution/2-shell.sql": "-- Shell spawned by “cron” – daily…\n-- platform: linux\nSELECT pid FROM processes;\n",
+	})
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{nil, "description: Shell spawned by “cron” – daily…\n"},
+		{[]string{"-ascii-descriptions"}, `description: "Shell spawned by \"cron\" - daily..."` + "\n"},
+	} {
+		output := t.TempDir()
+		if err := runConvert(t, append([]string{"-upstream", upstream, "-output", output}, tt.args...)...); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(filepath.Join(output, "chainguard-detection.yml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(data), tt.want) {
+			t.Errorf("%v: want %q in:\n%s", tt.args, tt.want, data)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"crypto/sha1"
+	"fmt"
+)
+
+// urlNamespace is the RFC 9562 namespace for names that are URLs
+var urlNamespace = [16]byte{0x6b, 0xa7, 0xb8, 0x11, 0x9d, 0xad, 0x11, 0xd1, 0x80, 0xb4, 0x00, 0xc0, 0x4f, 0xd4, 0x30, 0xc8}
+
+// queryNamespace is the namespace of every query UUID, itself the UUIDv5 of
+// this project's URL. Changing it changes every emitted uuid.
+var queryNamespace = uuidV5(urlNamespace, "https://github.com/RasterSec/fleetdm-osquery-defense-kit")
+
+// uuidV5 derives a name-based UUID from namespace and name with SHA-1
+func uuidV5(namespace [16]byte, name string) [16]byte {
+	h := sha1.New()
+	h.Write(namespace[:])
+	h.Write([]byte(name))
+	var u [16]byte
+	copy(u[:], h.Sum(nil))
+	u[6] = u[6]&0x0f | 0x50 // version 5
+	u[8] = u[8]&0x3f | 0x80 // RFC 9562 variant
+	return u
+}
+
+// queryUUID is the uuid annotation of a query. It is derived from the slug,
+// so it survives a new name or level but changes when the file moves.
+func queryUUID(slug string) string {
+	u := uuidV5(queryNamespace, slug)
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+}
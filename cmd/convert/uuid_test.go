@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUUIDv5(t *testing.T) {
+	// Python's uuid.uuid5(uuid.NAMESPACE_URL, ...) gives the same values
+	format := func(u [16]byte) string {
+		return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+	}
+	if got, want := format(uuidV5(urlNamespace, "https://example.com/")), "dd2c1780-811a-5296-81c5-178a0ef488bc"; got != want {
+		t.Errorf("uuidV5 of https://example.com/ = %s, want %s", got, want)
+	}
+	if got, want := format(queryNamespace), "c836dd0b-d5f1-534b-92d8-693a78b04496"; got != want {
+		t.Errorf("queryNamespace = %s, want %s", got, want)
+	}
+}
+
+func TestQueryUUID(t *testing.T) {
+	// Pinned so that a change to the namespace or derivation is caught
+	const want = "10fee000-20fb-5a88-8415-b19e576d4280"
+	if first, again := queryUUID("detection-c2-dns-tunnel"), queryUUID("detection-c2-dns-tunnel"); first != want || again != want {
+		t.Errorf("uuid of detection-c2-dns-tunnel is %s, then %s; want %s", first, again, want)
+	}
+	if other := queryUUID("detection-c2-dns-tunnel-2"); other == want {
+		t.Errorf("different slugs share the uuid %s", other)
+	}
+
+	// The uuid follows the slug, not the name or the level
+	a, _ := parseTestQuery(t, "detection/execution/2-shell.sql", fixtureQuery)
+	b, _ := parseTestQuery(t, "detection/execution/3-shell.sql", "-- query_name: Daemon shell\n"+fixtureQuery)
+	docA, _ := emitTestQuery(t, a)
+	docB, _ := emitTestQuery(t, b)
+	if a.Slug != b.Slug || a.Name == b.Name {
+		t.Fatalf("fixtures have slugs %q and %q, names %q and %q", a.Slug, b.Slug, a.Name, b.Name)
+	}
+	if uuid := docA.Metadata.Annotations["uuid"]; uuid != queryUUID(a.Slug) || docB.Metadata.Annotations["uuid"] != uuid {
+		t.Errorf("uuid annotations are %q and %q, want %q", uuid, docB.Metadata.Annotations["uuid"], queryUUID(a.Slug))
+	}
+}
+
+func TestQueryUUIDAcrossRuns(t *testing.T) {
+	files := map[string]string{"detection/execution/2-shell.sql": fixtureQuery}
+	var uuids []string
+	for range 2 {
+		// A fresh checkout and output each time
+		output := t.TempDir()
+		if err := runConvert(t, "-upstream", writeUpstream(t, files), "-output", output); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(filepath.Join(output, "chainguard-all.yml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, rest, ok := strings.Cut(string(data), "uuid: ")
+		if !ok {
+			t.Fatalf("no uuid annotation in:\n%s", data)
+		}
+		uuid, _, _ := strings.Cut(rest, "\n")
+		uuids = append(uuids, uuid)
+	}
+	if uuids[0] != uuids[1] {
+		t.Errorf("runs gave uuids %s and %s", uuids[0], uuids[1])
+	}
+}
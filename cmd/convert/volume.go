@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Rows a detection is assumed to return per run, by the shape of its SQL.
+// These are guesses for a busy host, not measurements; see the README.
+const (
+	eventRowsPerRun     = 20 // reads an *_events table
+	filteredRowsPerRun  = 1  // filters an inventory table with WHERE
+	inventoryRowsPerRun = 50 // reads an inventory table unfiltered
+)
+
+// heavyTables are expensive to evaluate: they hash or read file contents,
+// walk the filesystem, or scan process memory
+var heavyTables = map[string]bool{
+	"augeas":             true,
+	"file":               true,
+	"hash":               true,
+	"process_memory_map": true,
+	"process_open_files": true,
+	"yara":               true,
+}
+
+// Shortest interval, in seconds, suggested for each cost class
+const (
+	heavyFilteredFloor   = 900  // filters a heavy table with WHERE
+	heavyUnfilteredFloor = 3600 // reads a heavy table unfiltered
+	unfilteredFloor      = 300  // reads any other table unfiltered
+)
+
+// hasWhere reports whether the outermost query filters with WHERE
+func hasWhere(tokens []sqlToken) bool {
+	for _, t := range tokens {
+		if t.depth == 0 && t.is("WHERE") {
+			return true
+		}
+	}
+	return false
+}
+
+// costClass sorts q by how expensive a run is likely to be, returning the
+// class and the shortest interval suggested for it. Queries that look cheap
+// return a floor of 0.
+func costClass(q Query) (string, int) {
+	filtered := hasWhere(tokenizeSQL(q.Query))
+	for _, table := range referencedTables(q.Query) {
+		if !heavyTables[table] {
+			continue
+		}
+		if filtered {
+			return table, heavyFilteredFloor
+		}
+		return "unfiltered " + table, heavyUnfilteredFloor
+	}
+	if !filtered {
+		return "unfiltered", unfilteredFloor
+	}
+	return "", 0
+}
+
+// volumeReport estimates the differential log volume of each scheduled
+// detection on one host
+type volumeReport struct {
+	Threshold    int              `json:"threshold,omitempty"`
+	EventsPerDay int              `json:"events_per_day"`
+	Queries      []queryLogVolume `json:"queries"`
+}
+
+type queryLogVolume struct {
+	Name         string `json:"name"`
+	Slug         string `json:"slug"`
+	Interval     int    `json:"interval"`
+	RowsPerRun   int    `json:"rows_per_run"`
+	EventsPerDay int    `json:"events_per_day"`
+}
+
+// estimateRows guesses how many rows q returns per run. Every row is taken
+// to be new, which holds for event tables and overstates differential
+// logging of inventory tables, whose unchanged rows are not logged again.
+func estimateRows(q Query) int {
+	rows := inventoryRowsPerRun
+	tokens := tokenizeSQL(q.Query)
+	if hasWhere(tokens) {
+		rows = filteredRowsPerRun
+	}
+	for _, table := range referencedTables(q.Query) {
+		if strings.HasSuffix(table, "_events") {
+			rows = eventRowsPerRun
+			break
+		}
+	}
+
+	// An outer LIMIT caps the result
+	if limit, ok := outerLimit(tokens); ok && limit < rows {
+		rows = limit
+	}
+	return rows
+}
+
+// buildVolume estimates events per day for every detection with an
+// interval, largest first
+func buildVolume(queries []Query, threshold int) volumeReport {
+	report := volumeReport{Threshold: threshold}
+	for _, q := range queries {
+		interval := jitteredInterval(q)
+		if q.Category != "detection" || interval <= 0 {
+			continue
+		}
+		rows := estimateRows(q)
+		v := queryLogVolume{
+			Name:         q.Name,
+			Slug:         q.Slug,
+			Interval:     interval,
+			RowsPerRun:   rows,
+			EventsPerDay: rows * (86400 / interval),
+		}
+		report.EventsPerDay += v.EventsPerDay
+		report.Queries = append(report.Queries, v)
+	}
+	sort.SliceStable(report.Queries, func(i, j int) bool {
+		return report.Queries[i].EventsPerDay > report.Queries[j].EventsPerDay
+	})
+	return report
+}
+
+// warnVolume reports detections projected above the threshold
+func warnVolume(report volumeReport) {
+	if report.Threshold <= 0 {
+		return
+	}
+	for _, v := range report.Queries {
+		if v.EventsPerDay > report.Threshold {
+			warnf("%s: projected at %d events per host per day, over -max-daily-events %d\n", v.Name, v.EventsPerDay, report.Threshold)
+		}
+	}
+}
+
+// writeVolume writes the report as log-volume.json and log-volume.md in
+// outputDir
+func writeVolume(report volumeReport, outputDir string) error {
+	if err := writeFileAtomic(filepath.Join(outputDir, "log-volume.json"), func(w io.Writer) error {
+		return writeJSON(w, report)
+	}); err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(outputDir, "log-volume.md"), func(w io.Writer) error {
+		return writeVolumeMarkdown(w, report)
+	})
+}
+
+func writeVolumeMarkdown(w io.Writer, report volumeReport) error {
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintf(bw, "# Estimated log volume\n\n")
+	fmt.Fprintf(bw, "About %d events per host per day from %d scheduled detections. Estimates assume every row a query returns is new; see the README for the row counts assumed.\n\n", report.EventsPerDay, len(report.Queries))
+
+	fmt.Fprintf(bw, "| Query | Interval | Rows per run | Events per day |\n|-------|----------|--------------|----------------|\n")
+	for _, v := range report.Queries {
+		name := strings.ReplaceAll(v.Name, "|", `\|`)
+		if report.Threshold > 0 && v.EventsPerDay > report.Threshold {
+			name += " (over threshold)"
+		}
+		fmt.Fprintf(bw, "| %s | %d | %d | %d |\n", name, v.Interval, v.RowsPerRun, v.EventsPerDay)
+	}
+
+	return bw.Flush()
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// source is the file a query is being parsed from. Its warnf prefixes the
+// path, and the line when known, and sends the warning to the parse's sink.
+type source struct {
+	path string
+	line int // 1-based line being parsed; 0 = not tied to a line
+	warn func(format string, args ...any)
+}
+
+func (s source) warnf(format string, args ...any) {
+	if s.line > 0 {
+		s.warn("%s:%d: "+format, append([]any{s.path, s.line}, args...)...)
+		return
+	}
+	s.warn("%s: "+format, append([]any{s.path}, args...)...)
+}
+
+// parseWarnings counts the warnings printed by flush, for -metrics
+var parseWarnings int
+
+// warningCollector buffers warnings from concurrent workers by sequence
+// number, so they can be printed in a deterministic order afterwards
+type warningCollector struct {
+	mu       sync.Mutex
+	warnings map[int][]string
+}
+
+func newWarningCollector() *warningCollector {
+	return &warningCollector{warnings: map[int][]string{}}
+}
+
+// scope returns a warnf-style function recording warnings under seq
+func (c *warningCollector) scope(seq int) func(format string, args ...any) {
+	return func(format string, args ...any) {
+		msg := fmt.Sprintf(format, args...)
+		c.mu.Lock()
+		c.warnings[seq] = append(c.warnings[seq], msg)
+		c.mu.Unlock()
+	}
+}
+
+// flush prints and forgets the warnings recorded under seq
+func (c *warningCollector) flush(seq int) {
+	c.mu.Lock()
+	msgs := c.warnings[seq]
+	delete(c.warnings, seq)
+	c.mu.Unlock()
+
+	parseWarnings += len(msgs)
+	for _, msg := range msgs {
+		warnf("%s", msg)
+	}
+}
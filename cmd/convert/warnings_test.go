@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestWarningCollectorConcurrent(t *testing.T) {
+	const workers, perWorker = 32, 50
+	c := newWarningCollector()
+	var wg sync.WaitGroup
+	for seq := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			warn := c.scope(seq)
+			for i := range perWorker {
+				warn("file-%02d.sql: warning %02d of %d\n", seq, i, perWorker)
+			}
+		}()
+	}
+	wg.Wait()
+
+	lines := captureWarnings(t, func() {
+		for seq := range workers {
+			c.flush(seq)
+		}
+	})
+	if len(lines) != workers*perWorker {
+		t.Fatalf("%d lines, want %d", len(lines), workers*perWorker)
+	}
+	// Every line is whole, and they come out grouped by scope in order
+	for i, line := range lines {
+		want := fmt.Sprintf("Warning: file-%02d.sql: warning %02d of %d", i/perWorker, i%perWorker, perWorker)
+		if line != want {
+			t.Fatalf("line %d = %q, want %q", i, line, want)
+		}
+	}
+
+	// A flushed scope is forgotten
+	if again := captureWarnings(t, func() { c.flush(0) }); len(again) > 0 {
+		t.Errorf("flushing twice printed %q", again)
+	}
+}
+
+func TestParseAllQueriesWarningOrder(t *testing.T) {
+	const files = 200
+	dir := t.TempDir()
+	sub := filepath.Join(dir, "detection", "execution")
+	if err := os.MkdirAll(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for i := range files {
+		content := fmt.Sprintf("-- Query %d\n-- platform: linux, solaris, aix\nSELECT %d\n", i, i)
+		if err := os.WriteFile(filepath.Join(sub, fmt.Sprintf("2-query-%03d.sql", i)), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	parse := func() []string {
+		return captureWarnings(t, func() {
+			queries, err := parseAllQueries(dir, parseOptions{EmptyDescription: "name"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(queries) != files {
+				t.Fatalf("parsed %d queries, want %d", len(queries), files)
+			}
+		})
+	}
+
+	line := regexp.MustCompile(`^Warning: \S+/2-query-(\d{3})\.sql:2: ignoring unknown platform "(solaris|aix)"$`)
+	first := parse()
+	if len(first) != 2*files {
+		t.Fatalf("%d warnings, want %d", len(first), 2*files)
+	}
+	for i, w := range first {
+		m := line.FindStringSubmatch(w)
+		if m == nil {
+			t.Fatalf("garbled warning %q", w)
+		}
+		// Files in walk order, each file's warnings in the order raised
+		wantPlatform := "solaris"
+		if i%2 == 1 {
+			wantPlatform = "aix"
+		}
+		if m[1] != fmt.Sprintf("%03d", i/2) || m[2] != wantPlatform {
+			t.Fatalf("warning %d is %q, out of order", i, w)
+		}
+	}
+
+	for range 3 {
+		if again := parse(); fmt.Sprint(again) != fmt.Sprint(first) {
+			t.Fatal("warnings differ between runs")
+		}
+	}
+}
+
+func TestWarningLineNumbers(t *testing.T) {
+	includes := writeUpstream(t, map[string]string{
+		"loop": "-- include: loop\nSELECT 1\n",
+	})
+	const path = "detection/execution/2-shell.sql"
+	tests := []struct {
+		name    string
+		content string
+		want    []string // warning prefixes, in order
+	}{
+		{"bad interval", "-- Unexpected shell\n-- platform: linux\n-- interval: soon\nSELECT 1\n", []string{path + ":3: "}},
+		{"several headers", "-- Unexpected shell\n-- created: tomorrow\n-- tags: process\n-- platform: plan9\nSELECT 1\n", []string{path + ":2: ", path + ":4: "}},
+		{"CRLF", "-- Unexpected shell\r\n-- interval: -1\r\nSELECT 1\r\n", []string{path + ":2: "}},
+		{"missing include", "-- Unexpected shell\nSELECT pid FROM processes\n-- include: missing\n", []string{path + ":3: "}},
+		// Warnings about the query as a whole carry only the path
+		{"two statements", "-- Unexpected shell\n-- interval: 60\nSELECT 1; SELECT 2;\n", []string{path + ": "}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, warnings := parseTestQueryOpts(t, path, tt.content, parseOptions{IncludeDir: includes})
+			if len(warnings) != len(tt.want) {
+				t.Fatalf("warnings = %q, want %d", warnings, len(tt.want))
+			}
+			for i, want := range tt.want {
+				if !strings.HasPrefix(warnings[i], want) {
+					t.Errorf("warning %q does not start with %q", warnings[i], want)
+				}
+			}
+		})
+	}
+
+	// An include that fails the parse names the line of the include
+	_, err := parseQueryReader(strings.NewReader("-- Unexpected shell\n-- platform: linux\n-- include: loop\n"), path, "detection", "detection",
+		parseOptions{IncludeDir: includes, Warn: func(string, ...any) {}})
+	if err == nil || !strings.HasPrefix(err.Error(), "line 3: ") {
+		t.Errorf("include cycle error = %v, want it to start with line 3", err)
+	}
+}
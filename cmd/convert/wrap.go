@@ -0,0 +1,123 @@
+package main
+
+import "strings"
+
+// wrapKeywords start a new line when a long line is wrapped
+var wrapKeywords = []string{"FROM", "WHERE", "AND", "OR", "JOIN", "LEFT", "INNER", "CROSS", "UNION", "GROUP", "ORDER", "HAVING", "LIMIT"}
+
+// wrapSQL reflows lines longer than width, breaking after commas and before
+// clause keywords. Breaks only replace whitespace between tokens, so string
+// literals, identifiers, and comments are never split. If wrapping would
+// change the token stream the query is returned unchanged.
+func wrapSQL(query string, width int) string {
+	if width <= 0 {
+		return query
+	}
+
+	tokens := tokenizeSQL(query)
+	breaks := map[int]bool{}
+	for i := 1; i < len(tokens); i++ {
+		prev, t := tokens[i-1], tokens[i]
+		if strings.Contains(query[prev.offset:t.offset], "\n") {
+			continue
+		}
+		afterComma := prev.kind == tokPunct && prev.text == ","
+		if afterComma || (isWrapKeyword(t) && !(t.is("JOIN") && (prev.is("LEFT") || prev.is("INNER") || prev.is("CROSS") || prev.is("OUTER")))) {
+			breaks[t.offset] = true
+		}
+	}
+
+	var b strings.Builder
+	offset := 0
+	for i, line := range strings.Split(query, "\n") {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		b.WriteString(wrapLine(line, offset, width, breaks))
+		offset += len(line) + 1
+	}
+
+	wrapped := b.String()
+	if !sameTokens(tokens, tokenizeSQL(wrapped)) {
+		return query
+	}
+	return wrapped
+}
+
+// wrapLine breaks a single line at the candidate offsets in breaks, which
+// are relative to the whole query starting at lineOffset
+func wrapLine(line string, lineOffset, width int, breaks map[int]bool) string {
+	if len(line) <= width {
+		return line
+	}
+
+	indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+	continuation := indent + "  "
+
+	var b strings.Builder
+	start := 0       // start of the current segment in line
+	prefix := indent // indentation the current segment is written with
+	segment0 := true // the first segment keeps the line's own indentation
+	for {
+		rest := line[start:]
+		if len(prefix)+len(strings.TrimLeft(rest, " \t")) <= width {
+			break
+		}
+
+		// Prefer the last break that fits, else the first one available
+		cut := -1
+		for j := start + 1; j < len(line); j++ {
+			if !breaks[lineOffset+j] {
+				continue
+			}
+			head := strings.TrimRight(line[start:j], " \t")
+			if !segment0 {
+				head = strings.TrimLeft(head, " \t")
+			}
+			if cut >= 0 && len(prefix)+len(head) > width {
+				break
+			}
+			cut = j
+		}
+		if cut < 0 {
+			break
+		}
+
+		head := strings.TrimRight(line[start:cut], " \t")
+		if !segment0 {
+			head = prefix + strings.TrimLeft(head, " \t")
+		}
+		b.WriteString(head)
+		b.WriteByte('\n')
+		start, prefix, segment0 = cut, continuation, false
+	}
+
+	if segment0 {
+		return line
+	}
+	b.WriteString(prefix + strings.TrimLeft(line[start:], " \t"))
+	return b.String()
+}
+
+func isWrapKeyword(t sqlToken) bool {
+	for _, keyword := range wrapKeywords {
+		if t.is(keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameTokens reports whether two token streams are identical apart from
+// their positions
+func sameTokens(a, b []sqlToken) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].kind != b[i].kind || a[i].text != b[i].text || a[i].depth != b[i].depth {
+			return false
+		}
+	}
+	return true
+}
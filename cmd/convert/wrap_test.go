@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWrapSQLLongSelectList(t *testing.T) {
+	query := "SELECT p.pid, p.name, p.path, p.cmdline, p.cwd, p.uid, p.gid, p.start_time, p.parent, pp.name AS parent_name, pp.path AS parent_path FROM processes p JOIN processes pp ON p.parent = pp.pid WHERE p.name IN ('sh', 'bash', 'zsh') AND pp.name = 'sshd';"
+	const width = 60
+
+	wrapped := wrapSQL(query, width)
+	lines := strings.Split(wrapped, "\n")
+	if len(lines) < 4 {
+		t.Fatalf("wrapped into %d lines:\n%s", len(lines), wrapped)
+	}
+	for _, line := range lines {
+		if len(line) > width {
+			t.Errorf("line longer than %d: %q", width, line)
+		}
+	}
+	if !sameTokens(tokenizeSQL(query), tokenizeSQL(wrapped)) {
+		t.Errorf("wrapping changed the tokens:\n%s", wrapped)
+	}
+	// Only whitespace changed
+	if strings.Join(strings.Fields(wrapped), " ") != query {
+		t.Errorf("wrapping changed more than whitespace:\n%s", wrapped)
+	}
+
+	// Breaks fall after commas or before clause keywords
+	for i := 1; i < len(lines); i++ {
+		first := strings.ToUpper(strings.Fields(lines[i])[0])
+		if !containsString(wrapKeywords, first) && !strings.HasSuffix(lines[i-1], ",") {
+			t.Errorf("line %q does not start at a break", lines[i])
+		}
+	}
+	if !strings.HasPrefix(lines[1], "  ") {
+		t.Errorf("continuation line %q is not indented", lines[1])
+	}
+
+	if again := wrapSQL(wrapped, width); again != wrapped {
+		t.Errorf("wrapping is not stable:\n%s\nthen\n%s", wrapped, again)
+	}
+}
+
+func TestWrapSQLKeepsLiteralsWhole(t *testing.T) {
+	literal := "'" + strings.Repeat("a, b FROM c ", 8) + "'"
+	query := `SELECT "column, with FROM comma", ` + literal + ` AS long_value, path FROM file WHERE path = '/etc/passwd' -- note, FROM here`
+	wrapped := wrapSQL(query, 40)
+	if !strings.Contains(wrapped, literal) || !strings.Contains(wrapped, `"column, with FROM comma"`) {
+		t.Errorf("literal or identifier split:\n%s", wrapped)
+	}
+	if !strings.Contains(wrapped, "-- note, FROM here") {
+		t.Errorf("comment split:\n%s", wrapped)
+	}
+	if !sameTokens(tokenizeSQL(query), tokenizeSQL(wrapped)) {
+		t.Errorf("wrapping changed the tokens:\n%s", wrapped)
+	}
+}
+
+func TestWrapSQLDisabled(t *testing.T) {
+	query := "SELECT a, b, c FROM t WHERE a = 1 AND b = 2"
+	for _, width := range []int{0, -1} {
+		if got := wrapSQL(query, width); got != query {
+			t.Errorf("width %d wrapped the query:\n%s", width, got)
+		}
+	}
+	if got := wrapSQL(query, 200); got != query {
+		t.Errorf("a short line was wrapped:\n%s", got)
+	}
+	// LEFT JOIN stays together
+	if got := wrapSQL("SELECT a FROM t LEFT JOIN u ON t.id = u.id", 20); strings.Contains(got, "LEFT\n") {
+		t.Errorf("LEFT JOIN split:\n%s", got)
+	}
+}
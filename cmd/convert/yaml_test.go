@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestBlockScalarIndentation(t *testing.T) {
+	tests := []struct {
+		name, query, header string
+	}{
+		{"plain", "SELECT pid\nFROM processes", "|"},
+		{"indented first line", "  SELECT pid\nFROM processes", "|2"},
+		{"tab first line", "\tSELECT pid\nFROM processes", "|2"},
+		{"all lines indented", "    SELECT pid\n    FROM processes\n      WHERE pid > 1", "|2"},
+		{"indented later lines", "SELECT pid\n  FROM processes\n    WHERE pid > 1", "|"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := Query{Name: "[detection/execution] Shell", Query: tt.query, Category: "detection"}
+			doc, text := emitTestQuery(t, q)
+			if got := doc.Spec["query"]; got != tt.query+"\n" {
+				t.Errorf("query read back as %q, want %q in:\n%s", got, tt.query+"\n", text)
+			}
+			if !strings.Contains(text, "  query: "+tt.header+"\n") {
+				t.Errorf("query not written with %s:\n%s", tt.header, text)
+			}
+		})
+	}
+}
+
+func TestBlockScalarRoundTrip(t *testing.T) {
+	tests := []struct {
+		query  string
+		quoted bool // written double-quoted rather than as a block scalar
+	}{
+		{"SELECT 1", false},
+		{"SELECT 1\n\n\n", false},
+		{"  SELECT name\nFROM users  ", false},
+		{"SELECT * FROM file WHERE path LIKE '/tmp/%  \n\t%'", false},
+		{"SELECT '\r\n' AS crlf", true},
+		{"SELECT '\u0085\u2028' AS breaks", true},
+		{"SELECT 'a\x00b'", true},
+		{"\ufeffSELECT 1", true},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		io.WriteString(&buf, "spec:\n")
+		writeBlockScalar(&buf, "  query", "    ", tt.query)
+		if err := checkQueryValue(buf.Bytes(), blockScalarValue(tt.query)); err != nil {
+			t.Errorf("%q: %v in:\n%s", tt.query, err, buf.Bytes())
+		}
+		if quoted := strings.HasPrefix(buf.String(), "spec:\n  query: \""); quoted != tt.quoted {
+			t.Errorf("%q quoted = %t, want %t:\n%s", tt.query, quoted, tt.quoted, buf.Bytes())
+		}
+
+		// Whole documents read back the same
+		q := Query{Name: "[detection/execution] Shell", Query: tt.query, Category: "detection"}
+		if doc, text := emitTestQuery(t, q); doc.Spec["query"] != blockScalarValue(tt.query) {
+			t.Errorf("%q emitted as %q:\n%s", tt.query, doc.Spec["query"], text)
+		}
+	}
+
+	if err := checkQueryValue([]byte("spec:\n  query: |\n    SELECT 2\n"), "SELECT 1\n"); err == nil {
+		t.Errorf("a different query body was accepted")
+	}
+}
+
+func TestAnnotationsOnlyWhenSet(t *testing.T) {
+	bare := Query{Name: "[policy] Everything", Description: "Everything", Query: "SELECT * FROM os_version", Category: "policy"}
+	if annotations := queryAnnotations(bare); len(annotations) > 0 {
+		t.Errorf("query without metadata has annotations %v", annotations)
+	}
+	if _, text := emitTestQuery(t, bare); strings.Contains(text, "metadata:") {
+		t.Errorf("metadata written for a query without annotations:\n%s", text)
+	}
+
+	q := bare
+	q.Query = "SELECT name, version FROM os_version"
+	q.Slug = "policy-everything"
+	q.Tags = []string{"inventory"}
+	q.Annotations = map[string]string{"owner": "", "team_channel": "#secops"}
+	annotations := queryAnnotations(q)
+	want := map[string]string{
+		"slug":         "policy-everything",
+		"uuid":         queryUUID("policy-everything"),
+		"tags":         "inventory",
+		"columns":      "name,version",
+		"team_channel": "#secops",
+	}
+	if len(annotations) != len(want) {
+		t.Errorf("annotations = %v, want %v", annotations, want)
+	}
+	for key, value := range want {
+		if annotations[key] != value {
+			t.Errorf("%s = %q, want %q", key, annotations[key], value)
+		}
+	}
+}
+
+func TestEscapeYAMLTypeAmbiguous(t *testing.T) {
+	for _, s := range []string{
+		"1", "42", "+1", "0x1F", "0o17", "1_000", "3.14", ".5", "1e3", ".inf", "-.Inf", ".NaN",
+		"true", "False", "yes", "No", "on", "OFF", "y", "n",
+		"null", "Null", "~",
+		"2024-01-31", "2024-1-5", "2024-01-31T10:00:00Z",
+	} {
+		if got := escapeYAML(s); got == s {
+			t.Errorf("%q written unquoted", s)
+		}
+		q := Query{Name: s, Description: s, Query: "SELECT 1", Category: "policy", Annotations: map[string]string{"value": s}}
+		doc, text := emitTestQuery(t, q)
+		if doc.Spec["name"] != s || doc.Spec["description"] != s || doc.Metadata.Annotations["value"] != s {
+			t.Errorf("%q did not read back as the same string:\n%s", s, text)
+		}
+	}
+
+	// Strings that only look similar stay plain
+	for _, s := range []string{"1password", "true positive", "2fa", "no-op", "v1", "null_device"} {
+		if got := escapeYAML(s); got != s {
+			t.Errorf("%q quoted as %s", s, got)
+		}
+	}
+}
+
+func TestDuplicateKeysRejected(t *testing.T) {
+	tests := []struct {
+		name, doc string
+		dup       string // duplicated key; "" = valid
+	}{
+		{"valid", "apiVersion: v1\nkind: query\nspec:\n  name: a\n  interval: 60\n", ""},
+		{"same key in sibling mappings", "- name: a\n  interval: 60\n- name: b\n  interval: 300\n", ""},
+		{"spec key", "spec:\n  interval: 60\n  interval: 300\n", "interval"},
+		{"top-level key", "kind: query\nspec: {}\nkind: label\n", "kind"},
+		{"annotation", "metadata:\n  annotations:\n    slug: a\n    slug: b\n", "slug"},
+		{"list item", "queries:\n  - name: a\n    name: b\n", "name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkDuplicateKeys([]byte(tt.doc))
+			switch {
+			case tt.dup == "" && err != nil:
+				t.Errorf("valid document rejected: %v", err)
+			case tt.dup != "" && (err == nil || !strings.Contains(err.Error(), `"`+tt.dup+`"`)):
+				t.Errorf("error = %v, want one naming %q", err, tt.dup)
+			}
+		})
+	}
+
+	// An emitter writing a key twice fails the run with the query name and
+	// key, and nothing reaches the output
+	q := Query{Name: "[detection/execution] Shell", Query: "SELECT 1"}
+	var out strings.Builder
+	err := writeCheckedYAML(&out, q, func(w io.Writer) error {
+		if err := writeQueryYAML(w, q); err != nil {
+			return err
+		}
+		_, err := io.WriteString(w, "  interval: 300\n  interval: 60\n")
+		return err
+	})
+	if err == nil || !strings.Contains(err.Error(), q.Name) || !strings.Contains(err.Error(), `"interval"`) {
+		t.Errorf("error = %v, want one naming the query and interval", err)
+	}
+	if out.Len() > 0 {
+		t.Errorf("rejected document was written:\n%s", out.String())
+	}
+
+	out.Reset()
+	if err := writeCheckedYAML(&out, q, func(w io.Writer) error { return writeQueryYAML(w, q) }); err != nil || out.Len() == 0 {
+		t.Errorf("valid document: error %v, %d bytes written", err, out.Len())
+	}
+}
+
+func TestSingleDocument(t *testing.T) {
+	var queries []Query
+	for _, fixture := range []string{fixtureQuery, fixtureFrontMatter, "-- Root login over SSH\n-- as: label\nSELECT 1 FROM users WHERE uid = 0;\n"} {
+		q, _ := parseTestQuery(t, "detection/execution/2-shell.sql", fixture)
+		queries = append(queries, q)
+	}
+	saved := singleDocument
+	defer func() { singleDocument = saved }()
+
+	var stream, single bytes.Buffer
+	singleDocument = false
+	if err := writeQueryDocuments(&stream, queries); err != nil {
+		t.Fatal(err)
+	}
+	singleDocument = true
+	if err := writeQueryDocuments(&single, queries); err != nil {
+		t.Fatal(err)
+	}
+
+	var fromStream []any
+	dec := yaml.NewDecoder(&stream)
+	for {
+		var doc any
+		if err := dec.Decode(&doc); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("document stream does not parse: %v", err)
+		}
+		fromStream = append(fromStream, doc)
+	}
+	var fromSingle []any
+	if err := yaml.Unmarshal(single.Bytes(), &fromSingle); err != nil {
+		t.Fatalf("single document does not parse: %v\n%s", err, single.String())
+	}
+	if len(fromStream) != len(queries) || !reflect.DeepEqual(fromStream, fromSingle) {
+		t.Errorf("the two forms parse to different queries:\n%v\n%v", fromStream, fromSingle)
+	}
+
+	// No queries is an empty list rather than an empty file
+	single.Reset()
+	if err := writeQueryDocuments(&single, nil); err != nil || single.String() != "[]\n" {
+		t.Errorf("empty single document = %q, %v", single.String(), err)
+	}
+}
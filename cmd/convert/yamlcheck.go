@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"gopkg.in/yaml.v3"
+)
+
+// writeCheckedYAML renders a query's document into a buffer and only copies
+// it to w once it has parsed without duplicate mapping keys. Lenient parsers
+// would silently keep the last of two "interval:" lines, so an emitter bug
+// fails the run instead of shipping.
+func writeCheckedYAML(w io.Writer, q Query, render func(w io.Writer) error) error {
+	var buf bytes.Buffer
+	if err := render(&buf); err != nil {
+		return err
+	}
+	if err := checkDuplicateKeys(buf.Bytes()); err != nil {
+		return fmt.Errorf("%s: emitted YAML is invalid: %w", q.Name, err)
+	}
+	if err := checkQueryValue(buf.Bytes(), blockScalarValue(emittedQuery(q))); err != nil {
+		return fmt.Errorf("%s: emitted YAML changes the query: %w", q.Name, err)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// checkDuplicateKeys parses data and reports the first mapping that defines
+// a key twice
+func checkDuplicateKeys(data []byte) error {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		// yaml.v3 refuses some duplicates itself; report those too
+		return err
+	}
+	return duplicateKeyIn(&root)
+}
+
+// checkQueryValue reports a query in data that does not decode to want,
+// byte for byte
+func checkQueryValue(data []byte, want string) error {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return err
+	}
+	value := queryNode(&root)
+	if value == nil {
+		return nil
+	}
+	if value.Value != want {
+		return fmt.Errorf("query reads back as %q, want %q", value.Value, want)
+	}
+	return nil
+}
+
+// queryNode returns the value of the query key of a query document, which
+// is under spec except in a GitOps list item
+func queryNode(n *yaml.Node) *yaml.Node {
+	if n.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			switch n.Content[i].Value {
+			case "query":
+				return n.Content[i+1]
+			case "spec":
+				return queryNode(n.Content[i+1])
+			}
+		}
+		return nil
+	}
+	for _, child := range n.Content {
+		if value := queryNode(child); value != nil {
+			return value
+		}
+	}
+	return nil
+}
+
+func duplicateKeyIn(n *yaml.Node) error {
+	if n.Kind == yaml.MappingNode {
+		seen := map[string]int{}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key := n.Content[i]
+			if line, ok := seen[key.Value]; ok {
+				return fmt.Errorf("duplicate key %q on lines %d and %d", key.Value, line, key.Line)
+			}
+			seen[key.Value] = key.Line
+		}
+	}
+	for _, child := range n.Content {
+		if err := duplicateKeyIn(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}